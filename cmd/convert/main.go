@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -21,11 +22,17 @@ import (
 
 var (
 	inputPath    = flag.String("i", "", "Input path")
-	inputFormat  = flag.String("if", "", "Input format (mbtiles, pmtiles, wtiles, xyz)")
+	inputFormat  = flag.String("if", "", "Input format (mbtiles, pmtiles, wtiles, xyz, tar, zip)")
 	outputPath   = flag.String("o", "", "Output path")
-	outputFormat = flag.String("of", "", "Output format (mbtiles, pmtiles, wtiles, xyz)")
-	deduplicate  = flag.Bool("d", true, "Deduplicate tiles (for mbtiles format)")
+	outputFormat = flag.String("of", "", "Output format (mbtiles, pmtiles, wtiles, xyz, tar, zip)")
+	deduplicate  = flag.Bool("d", true, "Deduplicate tiles (for mbtiles and wtiles formats)")
+	tilesPattern = flag.String("pattern", "{z}/{x}/{y}.png", "File pattern of tiles inside tar and zip archives")
+	gridsPattern = flag.String("grids", "", "UTFGrid files pattern (e.g. /tiles/{z}/{x}/{y}.grid.json), to write UTFGrids of mbtiles input or to read UTFGrids for mbtiles output")
+	xyzWorkers   = flag.Int("j", 8, "Number of goroutines reading or writing files (for xyz format)")
+	unmatched    = flag.String("unmatched", "ignore", "Handling of input files not matching the pattern: ignore, warn or error (for xyz format)")
+	skipSame     = flag.Bool("skip-identical", false, "Don't rewrite existing files with identical data (for xyz format)")
 	disableLogs  = flag.Bool("q", false, "Disable debug logs")
+	indexFlags   = internal.RegisterIndexFlags()
 )
 
 var logger = log.Default()
@@ -50,7 +57,12 @@ func run() error {
 	inputFormat := internal.DeduceFormat(*inputFormat, *inputPath)
 	outputFormat := internal.DeduceFormat(*outputFormat, *outputPath)
 
-	var err error
+	policy, err := parseUnmatchedPolicy(*unmatched)
+	if err != nil {
+		return err
+	}
+	xyzReaderOpts := []xyz.ReaderOption{xyz.WithUnmatchedPolicy(policy), xyz.WithLogger(logger)}
+
 	var reader tile.Visitor
 	switch inputFormat {
 	case "mbtiles":
@@ -60,7 +72,11 @@ func run() error {
 	case "wtiles":
 		reader, err = wt.NewFileReader(*inputPath)
 	case "xyz", "":
-		reader, err = xyz.NewReader(*inputPath)
+		reader, err = xyz.NewReader(*inputPath, append(xyzReaderOpts, xyz.WithWalkConcurrency(*xyzWorkers))...)
+	case "tar":
+		reader, err = xyz.NewTarReader(*inputPath, *tilesPattern, xyzReaderOpts...)
+	case "zip":
+		reader, err = xyz.NewZipReader(*inputPath, *tilesPattern, xyzReaderOpts...)
 	default:
 		return fmt.Errorf("invalid input format: %q", inputFormat)
 	}
@@ -74,6 +90,7 @@ func run() error {
 	var mbMetadata map[string]string
 	var pmHeaderMetadata pm.HeaderMetadata
 	var pmJsonMetadata []byte
+	var xyzMetadata *xyz.Metadata
 
 	switch inputFormat {
 	case "mbtiles":
@@ -82,15 +99,21 @@ func run() error {
 			return err
 		}
 	case "pmtiles":
-		pmHeaderMetadata = reader.(*pm.Reader).HeaderMetadata()
-		pmJsonMetadata, err = reader.(*pm.Reader).ReadMetadata()
+		pmHeaderMetadata = reader.(*pm.FileReader).HeaderMetadata()
+		pmJsonMetadata, err = reader.(*pm.FileReader).ReadMetadata()
 		if err != nil {
 			return err
 		}
+	case "xyz", "", "tar", "zip":
+		xyzMetadata, err = reader.(xyzMetadataReader).ReadMetadata()
+		if err != nil {
+			return err
+		}
+		mbMetadata = metadataXyzToMb(xyzMetadata)
 	}
 
 	switch {
-	case inputFormat == "mbtiles" && outputFormat == "pmtiles":
+	case (inputFormat == "mbtiles" || xyzMetadata != nil) && outputFormat == "pmtiles":
 		pmHeaderMetadata, err = metadataMbToPm(mbMetadata)
 		if err != nil {
 			return fmt.Errorf("failed to convert metadata: %s", err)
@@ -105,6 +128,13 @@ func run() error {
 			return fmt.Errorf("failed to convert metadata: %s", err)
 		}
 		mbMetadata["name"] = filepath.Base(*inputPath)
+	case inputFormat == "mbtiles" && isXyz(outputFormat):
+		xyzMetadata, err = metadataMbToXyz(mbMetadata)
+		if err != nil {
+			return fmt.Errorf("failed to convert metadata: %s", err)
+		}
+	case inputFormat == "pmtiles" && isXyz(outputFormat):
+		xyzMetadata = metadataPmToXyz(&pmHeaderMetadata, pmJsonMetadata)
 	}
 
 	var writer tile.Writer
@@ -123,12 +153,33 @@ func run() error {
 			pm.WithLogger(logger),
 		)
 	case "wtiles":
+		indexOpts, indexErr := indexFlags.WriterOptions()
+		if indexErr != nil {
+			return indexErr
+		}
+		if r, ok := reader.(*wt.FileReader); ok && indexFlags.Empty() {
+			indexOpts = r.IndexOptions()
+		}
 		writer, err = wt.NewWriter(
 			*outputPath,
-			wt.WithLogger(logger),
+			append(indexOpts, wt.WithDeduplication(*deduplicate), wt.WithLogger(logger))...,
 		)
 	case "xyz", "":
-		writer, err = xyz.NewWriter(*outputPath)
+		xyzOpts := []xyz.WriterOption{xyz.WithConcurrency(*xyzWorkers), xyz.WithSkipIdentical(*skipSame)}
+		if xyzMetadata != nil {
+			xyzOpts = append(xyzOpts, xyz.WithMetadata(*xyzMetadata))
+		}
+		writer, err = xyz.NewWriter(*outputPath, xyzOpts...)
+	case "tar", "zip":
+		var archiveOpts []xyz.WriterOption
+		if xyzMetadata != nil {
+			archiveOpts = append(archiveOpts, xyz.WithMetadata(*xyzMetadata))
+		}
+		if outputFormat == "tar" {
+			writer, err = xyz.NewTarWriter(*outputPath, *tilesPattern, archiveOpts...)
+		} else {
+			writer, err = xyz.NewZipWriter(*outputPath, *tilesPattern, archiveOpts...)
+		}
 	default:
 		return fmt.Errorf("invalid output format: %q", outputFormat)
 	}
@@ -142,18 +193,90 @@ func run() error {
 	bar := progressbar.DefaultBytes(-1)
 	defer bar.Close()
 
-	err = reader.VisitTiles(func(tileID tile.ID, tileData []byte) error {
-		err := writer.WriteTile(tileID, tileData)
-		bar.Add(len(tileData))
-		return err
-	})
+	contentVisitor, contentWriter := contentReaderWriter(reader, writer)
+	if contentVisitor != nil {
+		logger.Println("input tiles are deduplicated, content identifiers are used instead of hashing")
+		err = contentVisitor.VisitContents(func(tileID tile.ID, contentID uint64, tileData []byte) error {
+			err := contentWriter.WriteContent(tileID, contentID, tileData)
+			bar.Add(len(tileData))
+			return err
+		})
+	} else {
+		err = reader.VisitTiles(func(tileID tile.ID, tileData []byte) error {
+			err := writer.WriteTile(tileID, tileData)
+			bar.Add(len(tileData))
+			return err
+		})
+	}
 	if err != nil {
 		return err
 	}
 
+	if err := convertGrids(reader, writer); err != nil {
+		return err
+	}
+
 	return writer.Finalize()
 }
 
+// convertGrids copies UTFGrids between mbtiles files, or between mbtiles file
+// and UTFGrid files of gridsPattern.
+func convertGrids(reader tile.Visitor, writer tile.Writer) error {
+	mbReader, _ := reader.(*mb.Reader)
+	mbWriter, _ := writer.(mb.Writer)
+	hasGrids := mbReader != nil && mbReader.HasGrids()
+
+	switch {
+	case hasGrids && mbWriter != nil:
+		return mbReader.VisitGrids(mbWriter.WriteGrid)
+	case hasGrids && *gridsPattern != "":
+		gridWriter, err := xyz.NewWriter(*gridsPattern)
+		if err != nil {
+			return err
+		}
+		err = mbReader.VisitGrids(func(tileID tile.ID, grid mb.Grid) error {
+			utfGrid, err := grid.UTFGrid()
+			if err != nil {
+				return fmt.Errorf("invalid UTFGrid of tile %v: %w", tileID, err)
+			}
+			return gridWriter.WriteTile(tileID, utfGrid)
+		})
+		if err != nil {
+			return err
+		}
+		return gridWriter.Finalize()
+	case hasGrids:
+		logger.Println("UTFGrids of input are not converted, use -grids to write them as files")
+	case mbWriter != nil && *gridsPattern != "":
+		gridReader, err := xyz.NewReader(*gridsPattern)
+		if err != nil {
+			return err
+		}
+		return gridReader.VisitTiles(func(tileID tile.ID, utfGrid []byte) error {
+			grid, err := mb.ParseUTFGrid(utfGrid)
+			if err != nil {
+				return fmt.Errorf("invalid UTFGrid of tile %v: %w", tileID, err)
+			}
+			return mbWriter.WriteGrid(tileID, grid)
+		})
+	}
+	return nil
+}
+
+// contentReaderWriter returns reader and writer as content visitor and writer,
+// if the reader identifies equal tiles and the writer deduplicates them.
+func contentReaderWriter(reader tile.Visitor, writer tile.Writer) (tile.ContentVisitor, tile.ContentWriter) {
+	mbReader, ok := reader.(*mb.Reader)
+	if !ok || !mbReader.Deduplicated() || !*deduplicate {
+		return nil, nil
+	}
+	contentWriter, ok := writer.(tile.ContentWriter)
+	if !ok {
+		return nil, nil
+	}
+	return mbReader, contentWriter
+}
+
 func metadataMbToPm(metadata map[string]string) (pm.HeaderMetadata, error) {
 	header := pm.HeaderMetadata{}
 
@@ -242,3 +365,136 @@ func metadataPmToMb(pmMetadata *pm.HeaderMetadata) (map[string]string, error) {
 
 	return mbMetadata, nil
 }
+
+func metadataXyzToMb(xyzMetadata *xyz.Metadata) map[string]string {
+	mbMetadata := make(map[string]string)
+	for name, value := range map[string]string{
+		"name":        xyzMetadata.Name,
+		"description": xyzMetadata.Description,
+		"attribution": xyzMetadata.Attribution,
+		"format":      xyzMetadata.Format,
+	} {
+		if value != "" {
+			mbMetadata[name] = value
+		}
+	}
+	mbMetadata["minzoom"] = fmt.Sprintf("%d", xyzMetadata.MinZoom)
+	mbMetadata["maxzoom"] = fmt.Sprintf("%d", xyzMetadata.MaxZoom)
+	if b := xyzMetadata.Bounds; len(b) == 4 {
+		mbMetadata["bounds"] = fmt.Sprintf("%g,%g,%g,%g", b[0], b[1], b[2], b[3])
+	}
+	if c := xyzMetadata.Center; len(c) == 3 {
+		mbMetadata["center"] = fmt.Sprintf("%g,%g,%d", c[0], c[1], int(c[2]))
+	}
+	if xyzMetadata.VectorLayers != nil {
+		jsonValue, _ := json.Marshal(map[string]json.RawMessage{"vector_layers": xyzMetadata.VectorLayers})
+		mbMetadata["json"] = string(jsonValue)
+	}
+	return mbMetadata
+}
+
+func metadataMbToXyz(mbMetadata map[string]string) (*xyz.Metadata, error) {
+	xyzMetadata := &xyz.Metadata{
+		TileJSON:    "3.0.0",
+		Name:        mbMetadata["name"],
+		Description: mbMetadata["description"],
+		Attribution: mbMetadata["attribution"],
+		Format:      mbMetadata["format"],
+	}
+
+	if boundsValue, found := mbMetadata["bounds"]; found {
+		xyzMetadata.Bounds = make([]float64, 4)
+		b := xyzMetadata.Bounds
+		if _, err := fmt.Sscanf(boundsValue, "%f,%f,%f,%f", &b[0], &b[1], &b[2], &b[3]); err != nil {
+			return nil, err
+		}
+	}
+
+	if centerValue, found := mbMetadata["center"]; found {
+		xyzMetadata.Center = make([]float64, 3)
+		c := xyzMetadata.Center
+		if _, err := fmt.Sscanf(centerValue, "%f,%f,%f", &c[0], &c[1], &c[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, value := range map[string]*uint32{"minzoom": &xyzMetadata.MinZoom, "maxzoom": &xyzMetadata.MaxZoom} {
+		if zoomValue, found := mbMetadata[name]; found {
+			if _, err := fmt.Sscanf(zoomValue, "%d", value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if jsonValue, found := mbMetadata["json"]; found {
+		var jsonMetadata struct {
+			VectorLayers json.RawMessage `json:"vector_layers"`
+		}
+		if err := json.Unmarshal([]byte(jsonValue), &jsonMetadata); err != nil {
+			return nil, err
+		}
+		xyzMetadata.VectorLayers = jsonMetadata.VectorLayers
+	}
+
+	return xyzMetadata, nil
+}
+
+func metadataPmToXyz(pmMetadata *pm.HeaderMetadata, pmJsonMetadata []byte) *xyz.Metadata {
+	const E7 = 10000000.0
+	mbMetadata, _ := metadataPmToMb(pmMetadata)
+	xyzMetadata := &xyz.Metadata{
+		TileJSON: "3.0.0",
+		Format:   mbMetadata["format"],
+		MinZoom:  uint32(pmMetadata.MinZoom),
+		MaxZoom:  uint32(pmMetadata.MaxZoom),
+		Bounds: []float64{
+			float64(pmMetadata.MinLonE7) / E7,
+			float64(pmMetadata.MinLatE7) / E7,
+			float64(pmMetadata.MaxLonE7) / E7,
+			float64(pmMetadata.MaxLatE7) / E7,
+		},
+		Center: []float64{
+			float64(pmMetadata.CenterLonE7) / E7,
+			float64(pmMetadata.CenterLatE7) / E7,
+			float64(pmMetadata.CenterZoom),
+		},
+	}
+
+	var jsonMetadata struct {
+		Name         string          `json:"name"`
+		Description  string          `json:"description"`
+		Attribution  string          `json:"attribution"`
+		VectorLayers json.RawMessage `json:"vector_layers"`
+	}
+	if json.Unmarshal(pmJsonMetadata, &jsonMetadata) == nil {
+		xyzMetadata.Name = jsonMetadata.Name
+		xyzMetadata.Description = jsonMetadata.Description
+		xyzMetadata.Attribution = jsonMetadata.Attribution
+		xyzMetadata.VectorLayers = jsonMetadata.VectorLayers
+	}
+
+	return xyzMetadata
+}
+
+// xyzMetadataReader is implemented by readers of xyz directories and archives.
+type xyzMetadataReader interface {
+	ReadMetadata() (*xyz.Metadata, error)
+}
+
+// isXyz reports whether the format stores tiles as files of a pattern.
+func isXyz(format string) bool {
+	return format == "xyz" || format == "" || format == "tar" || format == "zip"
+}
+
+func parseUnmatchedPolicy(policy string) (xyz.UnmatchedPolicy, error) {
+	switch policy {
+	case "ignore":
+		return xyz.UnmatchedIgnore, nil
+	case "warn":
+		return xyz.UnmatchedWarn, nil
+	case "error":
+		return xyz.UnmatchedError, nil
+	default:
+		return 0, fmt.Errorf("invalid unmatched files policy: %q", policy)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
@@ -21,8 +22,11 @@ import (
 var (
 	inputPath       = flag.String("i", "", "Input file path")
 	inputFormat     = flag.String("if", "", "Input file format (mbtiles, pmtiles, wtiles)")
-	outputIndexPath = flag.String("o", "", "Output index file path")
+	outputIndexPath = flag.String("o", "", "Output index file path (binary, or text with .csv, .ndjson extension)")
 	outputTilesPath = flag.String("t", "", "Output tiles file path")
+	sortOrder       = flag.String("sort", "", "Sort index items (hilbert, morton, offset, zoom), by default in the order of the input")
+	tempDir         = flag.String("tmp", "", "Directory for temporary files of sorting")
+	legacyIndex     = flag.Bool("legacy", false, "Write index without header, for tools that don't support it")
 )
 
 func main() {
@@ -58,6 +62,12 @@ func run() error {
 		defer closer.Close()
 	}
 
+	if *sortOrder != "" {
+		if _, err := index.ParseOrder(*sortOrder); err != nil {
+			return err
+		}
+	}
+
 	if locationReader, ok := reader.(tile.LocationVisitor); ok {
 		return exportLocations(locationReader)
 	} else {
@@ -75,14 +85,35 @@ func exportLocations(reader tile.LocationVisitor) error {
 	bar := progressbar.DefaultBytes(-1)
 	defer bar.Close()
 
-	indexWriter := bufio.NewWriter(indexFile)
-	indexEncoder := index.NewEncoder(io.MultiWriter(indexWriter, bar))
+	indexEncoder, err := internal.NewIndexEncoder(indexFile, *legacyIndex)
+	if err != nil {
+		return err
+	}
+
+	if *sortOrder != "" {
+		sorter := newSorter()
+		defer sorter.Close()
+		if err := sorter.AddFrom(reader); err != nil {
+			return err
+		}
+		reader = sorter
+	}
 
-	if err := indexEncoder.EncodeFrom(reader); err != nil {
+	itemSize := binary.Size(index.Item{})
+	err = reader.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		bar.Add(itemSize)
+		return indexEncoder.Encode(index.NewItem(tileID, location))
+	})
+	if err != nil {
 		return err
 	}
 
-	return indexWriter.Flush()
+	return indexEncoder.Finalize()
+}
+
+func newSorter() *index.Sorter {
+	order, _ := index.ParseOrder(*sortOrder)
+	return index.NewSorter(order, index.WithTempDir(*tempDir))
 }
 
 func exportTiles(reader tile.Visitor) error {
@@ -91,8 +122,18 @@ func exportTiles(reader tile.Visitor) error {
 		return err
 	}
 	defer indexFile.Close()
-	indexWriter := bufio.NewWriter(indexFile)
-	indexEncoder := index.NewEncoder(indexWriter)
+	indexEncoder, err := internal.NewIndexEncoder(indexFile, *legacyIndex)
+	if err != nil {
+		return err
+	}
+
+	var sorter *index.Sorter
+	encodeItem := indexEncoder.Encode
+	if *sortOrder != "" {
+		sorter = newSorter()
+		defer sorter.Close()
+		encodeItem = sorter.Add
+	}
 
 	tilesFile, err := os.Create(*outputTilesPath)
 	if err != nil {
@@ -114,7 +155,7 @@ func exportTiles(reader tile.Visitor) error {
 			Offset: tilesOffset,
 		}
 
-		if err := indexEncoder.Encode(indexItem); err != nil {
+		if err := encodeItem(indexItem); err != nil {
 			return err
 		}
 
@@ -136,7 +177,12 @@ func exportTiles(reader tile.Visitor) error {
 	if err := tilesWriter.Flush(); err != nil {
 		return err
 	}
-	if err := indexWriter.Flush(); err != nil {
+	if sorter != nil {
+		if err := indexEncoder.EncodeFrom(sorter); err != nil {
+			return err
+		}
+	}
+	if err := indexEncoder.Finalize(); err != nil {
 		return err
 	}
 
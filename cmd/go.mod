@@ -6,6 +6,7 @@ replace github.com/eak1mov/go-libtiles v0.5.0 => ..
 
 require (
 	github.com/eak1mov/go-libtiles v0.5.0
+	github.com/klauspost/compress v1.20.1
 	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/schollz/progressbar/v3 v3.18.0
 	github.com/ulikunitz/xz v0.5.15
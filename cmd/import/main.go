@@ -1,13 +1,11 @@
 package main
 
 import (
-	"cmp"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"slices"
 
 	"github.com/eak1mov/go-libtiles/cmd/internal"
 	"github.com/eak1mov/go-libtiles/index"
@@ -20,13 +18,15 @@ import (
 )
 
 var (
-	inputIndexPath = flag.String("i", "", "Input index file path")
+	inputIndexPath = flag.String("i", "", "Input index file path (binary, or text with .csv, .ndjson extension)")
 	inputTilesPath = flag.String("t", "", "Input tiles file path")
 	outputPath     = flag.String("o", "", "Output file path")
 	outputFormat   = flag.String("of", "", "Output file format (mbtiles, pmtiles, wtiles)")
 	sortOffsets    = flag.Bool("sort", false, "Sort by offset before writing")
+	tempDir        = flag.String("tmp", "", "Directory for temporary files of sorting")
 	bulkMode       = flag.Bool("bulk", true, "Use bulk import")
 	disableLogs    = flag.Bool("q", false, "Disable debug logs")
+	indexFlags     = internal.RegisterIndexFlags()
 )
 
 var logger = log.Default()
@@ -48,15 +48,24 @@ func main() {
 }
 
 func run() error {
-	indexData, err := os.ReadFile(*inputIndexPath)
+	indexFile, err := os.Open(*inputIndexPath)
 	if err != nil {
 		return err
 	}
-	indexItems, err := index.DecodeAll(indexData)
-	if err != nil {
-		return err
+	defer indexFile.Close()
+
+	indexItems := internal.NewIndexDecoder(indexFile)
+	if indexDecoder, ok := indexItems.(*index.Decoder); ok {
+		indexHeader, err := indexDecoder.Header()
+		if err != nil {
+			return fmt.Errorf("invalid index file: %w", err)
+		}
+		if indexHeader != nil {
+			logger.Printf("index file: %d items, checksum %08x", indexHeader.Count, indexHeader.Checksum)
+		} else {
+			logger.Println("index file without header, item count and checksum are not validated")
+		}
 	}
-	indexData = nil
 
 	tilesFile, err := os.Open(*inputTilesPath)
 	if err != nil {
@@ -65,9 +74,12 @@ func run() error {
 	defer tilesFile.Close()
 
 	if *sortOffsets {
-		slices.SortFunc(indexItems, func(a, b index.Item) int {
-			return cmp.Compare(a.Offset, b.Offset)
-		})
+		sorter := index.NewSorter(index.OrderOffset, index.WithTempDir(*tempDir))
+		defer sorter.Close()
+		if err := sorter.AddFrom(indexItems); err != nil {
+			return err
+		}
+		indexItems = sorter
 	}
 
 	if *bulkMode {
@@ -77,28 +89,32 @@ func run() error {
 	}
 }
 
-func importBulk(indexItems []index.Item, tilesFile *os.File) error {
+func importBulk(indexItems tile.LocationVisitor, tilesFile *os.File) error {
 	switch internal.DeduceFormat(*outputFormat, *outputPath) {
 	case "pmtiles":
 		return pm.Import(
 			*outputPath,
-			index.ItemsVisitor(indexItems),
+			indexItems,
 			tilesFile,
 			pm.WithLogger(logger),
 		)
 	case "wtiles":
+		indexOpts, err := indexFlags.WriterOptions()
+		if err != nil {
+			return err
+		}
 		return wt.Import(
 			*outputPath,
-			index.ItemsVisitor(indexItems),
+			indexItems,
 			tilesFile,
-			wt.WithLogger(logger),
+			append(indexOpts, wt.WithLogger(logger))...,
 		)
 	default:
 		return fmt.Errorf("invalid output format: %q", *outputFormat)
 	}
 }
 
-func importIterative(indexItems []index.Item, tilesFile *os.File) (err error) {
+func importIterative(indexItems tile.LocationVisitor, tilesFile *os.File) (err error) {
 	var writer tile.Writer
 	switch internal.DeduceFormat(*outputFormat, *outputPath) {
 	case "mbtiles":
@@ -106,7 +122,11 @@ func importIterative(indexItems []index.Item, tilesFile *os.File) (err error) {
 	case "pmtiles":
 		writer, err = pm.NewWriter(*outputPath, pm.WithLogger(logger))
 	case "wtiles":
-		writer, err = wt.NewWriter(*outputPath, wt.WithLogger(logger))
+		indexOpts, indexErr := indexFlags.WriterOptions()
+		if indexErr != nil {
+			return indexErr
+		}
+		writer, err = wt.NewWriter(*outputPath, append(indexOpts, wt.WithLogger(logger))...)
 	default:
 		return fmt.Errorf("invalid output format: %q", *outputFormat)
 	}
@@ -117,28 +137,27 @@ func importIterative(indexItems []index.Item, tilesFile *os.File) (err error) {
 		defer closer.Close()
 	}
 
-	sumLength := int64(0)
-	maxLength := uint32(0)
-	for _, item := range indexItems {
-		sumLength += int64(item.Length)
-		maxLength = max(maxLength, item.Length)
-	}
-
-	bar := progressbar.DefaultBytes(sumLength)
+	bar := progressbar.DefaultBytes(-1)
 	defer bar.Close()
 
-	buffer := make([]byte, maxLength)
+	var buffer []byte
 
-	for _, item := range indexItems {
-		tileData := buffer[:item.Length]
-		if _, err := tilesFile.ReadAt(tileData, int64(item.Offset)); err != nil {
+	err = indexItems.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		if uint64(cap(buffer)) < location.Length {
+			buffer = make([]byte, location.Length)
+		}
+		tileData := buffer[:location.Length]
+		if _, err := tilesFile.ReadAt(tileData, int64(location.Offset)); err != nil {
 			return err
 		}
-		tileID := tile.ID{X: item.X, Y: item.Y, Z: item.Z}
 		if err := writer.WriteTile(tileID, tileData); err != nil {
 			return err
 		}
 		bar.Add(len(tileData))
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return writer.Finalize()
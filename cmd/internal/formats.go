@@ -1,6 +1,16 @@
 package internal
 
-import "strings"
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/eak1mov/go-libtiles/wt"
+	"github.com/eak1mov/go-libtiles/wt/fbs"
+	"github.com/eak1mov/go-libtiles/wt/index/block"
+)
 
 func DeduceFormat(format, filePath string) string {
 	switch {
@@ -12,7 +22,91 @@ func DeduceFormat(format, filePath string) string {
 		return "pmtiles"
 	case strings.HasSuffix(filePath, ".wtiles"):
 		return "wtiles"
+	case strings.HasSuffix(filePath, ".tar"):
+		return "tar"
+	case strings.HasSuffix(filePath, ".zip"):
+		return "zip"
 	default:
 		return format
 	}
 }
+
+// ParseBlockLevels parses comma-separated zooms where block levels of the
+// WebTiles sparse index start (e.g. "0,6,12"), empty string means default levels.
+func ParseBlockLevels(value string) (block.LevelsMask, error) {
+	if value == "" {
+		return 0, nil
+	}
+	var zooms []uint32
+	for _, part := range strings.Split(value, ",") {
+		zoom, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32)
+		if err != nil || zoom > wt.MaxZoom {
+			return 0, fmt.Errorf("invalid block levels %q", value)
+		}
+		zooms = append(zooms, uint32(zoom))
+	}
+	return block.NewLevelsMask(zooms...), nil
+}
+
+// FormatBlockLevels formats block level starts as accepted by ParseBlockLevels.
+func FormatBlockLevels(blockLevels block.LevelsMask) string {
+	var parts []string
+	for blockRange := range blockLevels.Ranges() {
+		parts = append(parts, strconv.Itoa(int(blockRange.Start)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// IndexFlags are command line flags describing the WebTiles index layout.
+type IndexFlags struct {
+	Format string // basic, plain, sparse
+	Levels string // see ParseBlockLevels
+	Region string // z/x/y of the plain index root
+}
+
+// RegisterIndexFlags registers -index, -levels and -region flags.
+func RegisterIndexFlags() *IndexFlags {
+	f := &IndexFlags{}
+	flag.StringVar(&f.Format, "index", "", "Index format for wtiles output (basic, plain, sparse), default is sparse or the format of wtiles input")
+	flag.StringVar(&f.Levels, "levels", "", "Comma-separated zooms where sparse index block levels start, e.g. 0,6,12 (for wtiles output)")
+	flag.StringVar(&f.Region, "region", "", "Root tile z/x/y of the plain index region, e.g. 12/2200/1343 (for wtiles output)")
+	return f
+}
+
+// Empty reports whether no index flags were set.
+func (f *IndexFlags) Empty() bool {
+	return f.Format == "" && f.Levels == "" && f.Region == ""
+}
+
+var indexFormats = map[string]fbs.IndexFormat{
+	"basic":  fbs.IndexFormatBasicPlain,
+	"plain":  fbs.IndexFormatPlain,
+	"sparse": fbs.IndexFormatSparse,
+}
+
+// WriterOptions returns wt.Writer options for the index layout.
+func (f *IndexFlags) WriterOptions() ([]wt.WriterOption, error) {
+	var opts []wt.WriterOption
+	if f.Format != "" {
+		indexFormat, found := indexFormats[strings.ToLower(f.Format)]
+		if !found {
+			return nil, fmt.Errorf("invalid index format: %q", f.Format)
+		}
+		opts = append(opts, wt.WithIndexFormat(indexFormat))
+	}
+	if f.Levels != "" {
+		blockLevels, err := ParseBlockLevels(f.Levels)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, wt.WithBlockLevels(blockLevels))
+	}
+	if f.Region != "" {
+		var root tile.ID
+		if _, err := fmt.Sscanf(f.Region, "%d/%d/%d", &root.Z, &root.X, &root.Y); err != nil || !root.Valid() {
+			return nil, fmt.Errorf("invalid index region: %q", f.Region)
+		}
+		opts = append(opts, wt.WithIndexRegion(root))
+	}
+	return opts, nil
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eak1mov/go-libtiles/index"
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// IndexEncoding returns the encoding of the index file by its extension:
+// "csv", "ndjson" (.ndjson, .jsonl) or "binary" (any other extension).
+func IndexEncoding(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".csv":
+		return "csv"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	default:
+		return "binary"
+	}
+}
+
+// IndexEncoder writes index items to a file, Finalize must be called after
+// the last item.
+type IndexEncoder interface {
+	Encode(item index.Item) error
+	EncodeFrom(src tile.LocationVisitor) error
+	Finalize() error
+}
+
+// NewIndexEncoder creates an encoder of the index file, the encoding is
+// selected by the file extension (see IndexEncoding). Binary files are written
+// with index.Header, unless legacy is set.
+func NewIndexEncoder(file *os.File, legacy bool) (IndexEncoder, error) {
+	writer := bufio.NewWriter(file)
+	switch IndexEncoding(file.Name()) {
+	case "csv":
+		encoder := index.NewCSVEncoder(writer)
+		return textEncoder{encoder, func() error {
+			if err := encoder.Flush(); err != nil {
+				return err
+			}
+			return writer.Flush()
+		}}, nil
+	case "ndjson":
+		return textEncoder{index.NewJSONEncoder(writer), writer.Flush}, nil
+	}
+	if !legacy {
+		return index.NewFileEncoder(file)
+	}
+	return textEncoder{index.NewEncoder(writer), writer.Flush}, nil
+}
+
+type itemEncoder interface {
+	Encode(item index.Item) error
+	EncodeFrom(src tile.LocationVisitor) error
+}
+
+type textEncoder struct {
+	itemEncoder
+	flush func() error
+}
+
+func (e textEncoder) Finalize() error {
+	return e.flush()
+}
+
+// NewIndexDecoder creates a decoder of the index file, the encoding is
+// selected by the file extension (see IndexEncoding).
+func NewIndexDecoder(file *os.File) tile.LocationVisitor {
+	reader := bufio.NewReader(file)
+	switch IndexEncoding(file.Name()) {
+	case "csv":
+		return index.NewCSVDecoder(reader)
+	case "ndjson":
+		return index.NewJSONDecoder(reader)
+	default:
+		return index.NewDecoder(reader)
+	}
+}
@@ -0,0 +1,298 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// LogRecord is a single parsed access log entry.
+type LogRecord struct {
+	TileID tile.ID
+	Count  uint64
+}
+
+// LogParser parses a single line of an access log.
+// It returns ok == false for lines that do not describe a tile request.
+type LogParser interface {
+	ParseLine(line []byte) (record LogRecord, ok bool, err error)
+}
+
+// NewLogParser creates a parser for the given log format:
+//   - "tilelog": OSM tile_logs format ("z/x/y count" per line),
+//   - "combined": nginx/Apache combined log format, urlPattern is used to
+//     extract tile coordinates from the request path (e.g. "/tiles/{z}/{x}/{y}.png"),
+//   - "csv": "z/x/y,count" per line, optional header line.
+func NewLogParser(format, urlPattern string) (LogParser, error) {
+	switch format {
+	case "tilelog", "":
+		return tilelogParser{}, nil
+	case "combined":
+		return newCombinedParser(urlPattern)
+	case "csv":
+		return csvParser{}, nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %q", format)
+	}
+}
+
+// Log data: https://planet.openstreetmap.org/tile_logs/
+// Format description: https://github.com/openstreetmap/tilelog
+type tilelogParser struct{}
+
+var tilelogRegexp = regexp.MustCompile(`^(\d+)/(\d+)/(\d+)\s+(\d+)$`)
+
+func (tilelogParser) ParseLine(line []byte) (LogRecord, bool, error) {
+	matches := tilelogRegexp.FindSubmatch(line)
+	if matches == nil {
+		return LogRecord{}, false, fmt.Errorf("failed to parse line: %q", line)
+	}
+	return parseRecord(matches[1], matches[2], matches[3], matches[4])
+}
+
+type csvParser struct{}
+
+var csvRegexp = regexp.MustCompile(`^(\d+)/(\d+)/(\d+),(\d+)$`)
+
+func (csvParser) ParseLine(line []byte) (LogRecord, bool, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || bytes.HasPrefix(line, []byte("tile,")) {
+		return LogRecord{}, false, nil
+	}
+	matches := csvRegexp.FindSubmatch(line)
+	if matches == nil {
+		return LogRecord{}, false, fmt.Errorf("failed to parse line: %q", line)
+	}
+	return parseRecord(matches[1], matches[2], matches[3], matches[4])
+}
+
+// Combined log format: %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+// Only the request line and the status code are used.
+type combinedParser struct {
+	pathRegexp *regexp.Regexp
+}
+
+var combinedRegexp = regexp.MustCompile(`^\S+ \S+ \S+ \[[^\]]*\] "(?:GET|HEAD) (\S+)[^"]*" (\d{3}) `)
+
+func newCombinedParser(urlPattern string) (*combinedParser, error) {
+	for _, p := range []string{"{x}", "{y}", "{z}"} {
+		if !strings.Contains(urlPattern, p) {
+			return nil, fmt.Errorf("invalid url pattern: placeholder %v not found", p)
+		}
+	}
+	regexPattern := regexp.QuoteMeta(urlPattern)
+	regexPattern = strings.ReplaceAll(regexPattern, "\\{x\\}", "(?P<x>\\d+)")
+	regexPattern = strings.ReplaceAll(regexPattern, "\\{y\\}", "(?P<y>\\d+)")
+	regexPattern = strings.ReplaceAll(regexPattern, "\\{z\\}", "(?P<z>\\d+)")
+	pathRegexp, err := regexp.Compile(regexPattern + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid url pattern: %w", err)
+	}
+	return &combinedParser{pathRegexp: pathRegexp}, nil
+}
+
+func (p *combinedParser) ParseLine(line []byte) (LogRecord, bool, error) {
+	matches := combinedRegexp.FindSubmatch(line)
+	if matches == nil {
+		return LogRecord{}, false, nil // not a GET/HEAD request or garbage in the log
+	}
+
+	path, status := matches[1], matches[2]
+	if status[0] != '2' && !bytes.Equal(status, []byte("304")) {
+		return LogRecord{}, false, nil
+	}
+	if i := bytes.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	pathMatches := p.pathRegexp.FindSubmatch(path)
+	if pathMatches == nil {
+		return LogRecord{}, false, nil
+	}
+
+	return parseRecord(
+		pathMatches[p.pathRegexp.SubexpIndex("z")],
+		pathMatches[p.pathRegexp.SubexpIndex("x")],
+		pathMatches[p.pathRegexp.SubexpIndex("y")],
+		[]byte("1"),
+	)
+}
+
+func parseRecord(zData, xData, yData, countData []byte) (LogRecord, bool, error) {
+	z, errZ := strconv.ParseUint(string(zData), 10, 32)
+	x, errX := strconv.ParseUint(string(xData), 10, 32)
+	y, errY := strconv.ParseUint(string(yData), 10, 32)
+	count, errCount := strconv.ParseUint(string(countData), 10, 64)
+	if errZ != nil || errX != nil || errY != nil || errCount != nil {
+		return LogRecord{}, false, fmt.Errorf("failed to parse numbers: %s/%s/%s %s", zData, xData, yData, countData)
+	}
+
+	tileID := tile.ID{X: uint32(x), Y: uint32(y), Z: uint32(z)}
+	if !tileID.Valid() {
+		return LogRecord{}, false, nil
+	}
+	return LogRecord{TileID: tileID, Count: count}, true, nil
+}
+
+// ExpandLogPaths expands glob patterns into a list of log file paths, files
+// matched by several patterns are listed once.
+func ExpandLogPaths(patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no log files given, use -l")
+	}
+	var result []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no log files found: %q", pattern)
+		}
+		for _, match := range matches {
+			if cleanPath := filepath.Clean(match); !seen[cleanPath] {
+				seen[cleanPath] = true
+				result = append(result, match)
+			}
+		}
+	}
+	return result, nil
+}
+
+// OpenLog opens a log file, decompressing it according to the file extension
+// (.gz, .xz or .zst).
+func OpenLog(logPath string) (io.ReadCloser, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = bufio.NewReaderSize(f, 64*1024)
+
+	switch {
+	case strings.HasSuffix(logPath, ".gz"):
+		reader, err = gzip.NewReader(reader)
+	case strings.HasSuffix(logPath, ".xz"):
+		reader, err = xz.NewReader(reader)
+	case strings.HasSuffix(logPath, ".zst"):
+		var decoder *zstd.Decoder
+		if decoder, err = zstd.NewReader(reader); err == nil {
+			reader = decoder.IOReadCloser()
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &logReader{Reader: reader, file: f}, nil
+}
+
+type logReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *logReader) Close() error {
+	if closer, ok := r.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return r.file.Close()
+}
+
+// VisitLog calls fn for each tile request in the log file.
+func VisitLog(logPath string, parser LogParser, fn func(LogRecord) error) error {
+	reader, err := OpenLog(logPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	for scanner.Scan() {
+		record, ok, err := parser.ParseLine(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s: %w", logPath, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+var logDateRegexp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+// LogTime returns the time of a log file: the date from its name
+// (e.g. tiles-2025-12-31.txt.xz) or its modification time.
+func LogTime(logPath string) (time.Time, error) {
+	if date := logDateRegexp.FindString(filepath.Base(logPath)); date != "" {
+		if t, err := time.Parse(time.DateOnly, date); err == nil {
+			return t, nil
+		}
+	}
+	info, err := os.Stat(logPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// ReadLogs reads and aggregates request counts from multiple log files.
+//
+// If halfLife is positive, counts are weighted by the age of each log file
+// relative to the newest one: a file halfLife older contributes half as much.
+func ReadLogs(logPaths []string, parser LogParser, halfLife time.Duration) (map[tile.ID]float64, error) {
+	logTimes := make([]time.Time, len(logPaths))
+	var newestTime time.Time
+	for i, logPath := range logPaths {
+		t, err := LogTime(logPath)
+		if err != nil {
+			return nil, err
+		}
+		logTimes[i] = t
+		if t.After(newestTime) {
+			newestTime = t
+		}
+	}
+
+	result := make(map[tile.ID]float64)
+
+	for i, logPath := range logPaths {
+		weight := 1.0
+		if halfLife > 0 {
+			age := newestTime.Sub(logTimes[i])
+			weight = math.Exp2(-age.Hours() / halfLife.Hours())
+		}
+
+		err := VisitLog(logPath, parser, func(record LogRecord) error {
+			result[record.TileID] += float64(record.Count) * weight
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
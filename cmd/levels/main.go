@@ -0,0 +1,227 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/eak1mov/go-libtiles/cmd/internal"
+	"github.com/eak1mov/go-libtiles/pm"
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/eak1mov/go-libtiles/wt"
+	"github.com/eak1mov/go-libtiles/wt/fbs"
+	"github.com/eak1mov/go-libtiles/wt/index"
+	"github.com/eak1mov/go-libtiles/wt/index/block"
+	"github.com/eak1mov/go-libtiles/wt/index/formats/sparse"
+	"github.com/eak1mov/go-libtiles/wt/index/packed"
+)
+
+var (
+	inputPath   = flag.String("i", "", "Input path")
+	inputFormat = flag.String("f", "", "Input format (pmtiles, wtiles)")
+	logsFormat  = flag.String("lf", "tilelog", "Logs format (tilelog, combined, csv)")
+	logsPattern = flag.String("lp", "/{z}/{x}/{y}.png", "URL pattern for combined logs, matched against the end of the request path")
+	maxLevels   = flag.Int("max-levels", 4, "Maximum number of block levels")
+	maxSpan     = flag.Uint("max-span", 8, "Maximum number of zooms in a block level")
+	requestCost = flag.Uint64("request-cost", 0, "Cost of a single request in bytes, to trade block size for round trips")
+	topCount    = flag.Int("top", 10, "Number of best layouts to print")
+
+	logsPatterns []string
+)
+
+func init() {
+	flag.Func("l", "Logs path or glob, can be repeated; lookups are weighted by request counts instead of uniformly over tiles", func(s string) error {
+		logsPatterns = append(logsPatterns, s)
+		return nil
+	})
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <path> [-l <path>] [-f <format>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// layoutCost is the expected cost of a single tile lookup with given block levels.
+type layoutCost struct {
+	BlockLevels block.LevelsMask
+	IndexSize   uint64
+	Requests    float64 // index blocks fetched per lookup
+	Bytes       float64 // index bytes fetched per lookup
+}
+
+func (c layoutCost) Total() float64 {
+	return c.Bytes + c.Requests*float64(*requestCost)
+}
+
+func run() error {
+	var reader tile.LocationVisitor
+	switch internal.DeduceFormat(*inputFormat, *inputPath) {
+	case "pmtiles":
+		r, err := pm.NewFileReader(*inputPath)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		reader = r
+	case "wtiles":
+		r, err := wt.NewFileReader(*inputPath)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		reader = r
+	default:
+		return fmt.Errorf("invalid input format: %q", *inputFormat)
+	}
+
+	indexMap := make(index.Map)
+	maxZoom := uint32(0)
+	err := reader.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		if tileID.Z > sparse.MaxZoom {
+			return fmt.Errorf("tile %v is above max zoom of sparse index", tileID)
+		}
+		indexMap[tileID] = packed.Pack(location)
+		maxZoom = max(maxZoom, tileID.Z)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	weights := make(map[tile.ID]float64, len(indexMap))
+	if len(logsPatterns) > 0 {
+		logsParser, err := internal.NewLogParser(*logsFormat, *logsPattern)
+		if err != nil {
+			return err
+		}
+		logsPaths, err := internal.ExpandLogPaths(logsPatterns)
+		if err != nil {
+			return err
+		}
+		logsData, err := internal.ReadLogs(logsPaths, logsParser, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read logs: %w", err)
+		}
+		for tileID, count := range logsData {
+			if tileID.Z <= maxZoom {
+				weights[tileID] = count // missing tiles are looked up too
+			}
+		}
+	} else {
+		for tileID := range indexMap {
+			weights[tileID] = 1
+		}
+	}
+
+	entries := indexMap.Sorted()
+	defaultLevels := sparse.DefaultLevels(maxZoom)
+	var costs []layoutCost
+	for _, blockLevels := range candidateLevels(maxZoom, *maxLevels, uint32(*maxSpan)) {
+		cost, err := evaluate(entries, weights, blockLevels)
+		if err != nil {
+			return err
+		}
+		costs = append(costs, cost)
+	}
+	if !slices.ContainsFunc(costs, func(c layoutCost) bool { return c.BlockLevels == defaultLevels }) {
+		cost, err := evaluate(entries, weights, defaultLevels)
+		if err != nil {
+			return err
+		}
+		costs = append(costs, cost)
+	}
+
+	slices.SortFunc(costs, func(a, b layoutCost) int {
+		return cmp.Or(cmp.Compare(a.Total(), b.Total()), cmp.Compare(a.IndexSize, b.IndexSize))
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "levels\tindex size\trequests/lookup\tbytes/lookup\t\t")
+	for i, cost := range costs {
+		if i >= *topCount && cost.BlockLevels != defaultLevels {
+			continue
+		}
+		note := ""
+		if cost.BlockLevels == defaultLevels {
+			note = "default"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.2f\t%.0f\t%s\t\n",
+			internal.FormatBlockLevels(cost.BlockLevels), cost.IndexSize, cost.Requests, cost.Bytes, note)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(costs) > 0 {
+		fmt.Printf("\nbest layout: -levels %s\n", internal.FormatBlockLevels(costs[0].BlockLevels))
+	}
+	return nil
+}
+
+// candidateLevels returns all block levels for tiles up to maxZoom with at
+// most maxLevels levels of at most maxSpan zooms each.
+func candidateLevels(maxZoom uint32, maxLevels int, maxSpan uint32) []block.LevelsMask {
+	var result []block.LevelsMask
+	var generate func(mask block.LevelsMask, start uint32, levels int)
+	generate = func(mask block.LevelsMask, start uint32, levels int) {
+		if maxZoom+1-start <= maxSpan {
+			result = append(result, mask.Clip(maxZoom))
+		}
+		if levels == maxLevels {
+			return
+		}
+		for next := start + 1; next <= min(start+maxSpan, maxZoom); next++ {
+			generate(mask|1<<next, next, levels+1)
+		}
+	}
+	generate(block.NewLevelsMask(0), 0, 1)
+	return result
+}
+
+// evaluate writes the sparse index with given block levels and computes the
+// expected cost of a lookup, weighted by weights.
+func evaluate(entries index.Entries, weights map[tile.ID]float64, blockLevels block.LevelsMask) (layoutCost, error) {
+	headerData := make([]byte, fbs.HeaderSizeExtended)
+	header := fbs.Header{}
+	header.Init(headerData, 0)
+	indexHeader := header.IndexHeader(nil)
+
+	indexData, err := sparse.WriteStream(indexHeader, entries, blockLevels)
+	if err != nil {
+		return layoutCost{}, err
+	}
+
+	var requests, bytes, sumWeights float64
+	for tileID, weight := range weights {
+		indexAccess := func(offset, length uint64) ([]byte, error) {
+			requests += weight
+			bytes += float64(length) * weight
+			return indexData[offset:][:length], nil
+		}
+		if _, err := sparse.Query(indexHeader, tileID, indexAccess); err != nil {
+			return layoutCost{}, err
+		}
+		sumWeights += weight
+	}
+	if sumWeights == 0 {
+		sumWeights = 1
+	}
+
+	return layoutCost{
+		BlockLevels: blockLevels,
+		IndexSize:   uint64(len(indexData)),
+		Requests:    requests / sumWeights,
+		Bytes:       bytes / sumWeights,
+	}, nil
+}
@@ -1,36 +1,48 @@
 package main
 
 import (
-	"bufio"
 	"cmp"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"regexp"
 	"slices"
-	"strconv"
-	"strings"
 
 	"github.com/eak1mov/go-libtiles/cmd/internal"
 	"github.com/eak1mov/go-libtiles/index"
 	"github.com/eak1mov/go-libtiles/pm"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt"
-	"github.com/ulikunitz/xz"
 )
 
 var (
-	inputPath   = flag.String("i", "", "Input path")
-	outputPath  = flag.String("o", "", "Output path")
-	logsPath    = flag.String("l", "", "Logs path (e.g. tiles-2025-12-31.txt.xz)")
-	format      = flag.String("f", "", "Format (pmtiles, wtiles)")
-	disableLogs = flag.Bool("q", false, "Disable debug logs")
+	inputPath    = flag.String("i", "", "Input path")
+	outputPath   = flag.String("o", "", "Output path")
+	logsFormat   = flag.String("lf", "tilelog", "Logs format (tilelog, combined, csv)")
+	logsPattern  = flag.String("lp", "/{z}/{x}/{y}.png", "URL pattern for combined logs, matched against the end of the request path")
+	logsHalfLife = flag.Duration("decay", 0, "Half-life for time-decay weighting of logs (e.g. 168h), disabled if zero")
+	format       = flag.String("f", "", "Format (pmtiles, wtiles)")
+	strategyName = flag.String("s", "popularity", "Optimization strategy (popularity, hilbert, pyramid)")
+	hotPrefix    = flag.Uint64("prefix", 0, "Size of the hot-tile prefix at the start of the data section (MiB), disabled if zero")
+	report       = flag.Bool("report", false, "Report expected byte ranges per viewport for all strategies")
+	viewport     = flag.String("viewport", "4x3", "Viewport size in tiles for the report")
+	disableLogs  = flag.Bool("q", false, "Disable debug logs")
+	indexFlags   = internal.RegisterIndexFlags()
+	logsPatterns []string
 )
 
+func init() {
+	flag.Func("l", "Logs path or glob, can be repeated (e.g. tiles-2025-12-*.txt.xz, access.log-*.gz)", func(s string) error {
+		logsPatterns = append(logsPatterns, s)
+		return nil
+	})
+}
+
 var logger *log.Logger
 
+const reportViewports = 100_000
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s -i <path> -o <path> -l <path> [-f <format>]\n", os.Args[0])
@@ -83,6 +95,7 @@ func run() error {
 				inputFile,
 				pm.WithHeaderMetadata(pmHeaderMetadata),
 				pm.WithMetadata(pmJsonMetadata),
+				pm.WithHotPrefix(*hotPrefix<<20),
 				pm.WithLogger(logger),
 			)
 		}
@@ -106,15 +119,25 @@ func run() error {
 		}
 		defer inputFile.Close()
 
+		indexOpts, err := indexFlags.WriterOptions()
+		if err != nil {
+			return err
+		}
+		if indexFlags.Empty() {
+			indexOpts = r.IndexOptions()
+		}
+
 		doImport = func(newIndex tile.LocationVisitor) error {
-			// TODO: forward index format from input file
 			return wt.Import(
 				*outputPath,
 				newIndex,
 				inputFile,
-				wt.WithHeaderMetadata(wtHeaderMetadata),
-				wt.WithMetadata(wtMetadata),
-				wt.WithLogger(logger),
+				append(indexOpts,
+					wt.WithHeaderMetadata(wtHeaderMetadata),
+					wt.WithMetadata(wtMetadata),
+					wt.WithHotPrefix(*hotPrefix<<20),
+					wt.WithLogger(logger),
+				)...,
 			)
 		}
 	case "index":
@@ -124,7 +147,7 @@ func run() error {
 		}
 		defer inputFile.Close()
 
-		reader = index.NewDecoder(bufio.NewReader(inputFile))
+		reader = internal.NewIndexDecoder(inputFile)
 
 		doImport = func(newIndex tile.LocationVisitor) error {
 			outputFile, err := os.Create(*outputPath)
@@ -133,13 +156,14 @@ func run() error {
 			}
 			defer outputFile.Close()
 
-			writer := bufio.NewWriter(outputFile)
-
-			if err := index.NewEncoder(writer).EncodeFrom(newIndex); err != nil {
+			encoder, err := internal.NewIndexEncoder(outputFile, false)
+			if err != nil {
 				return err
 			}
-
-			return writer.Flush()
+			if err := encoder.EncodeFrom(newIndex); err != nil {
+				return err
+			}
+			return encoder.Finalize()
 		}
 	default:
 		return fmt.Errorf("invalid format: %q", inputFormat)
@@ -154,14 +178,24 @@ func run() error {
 
 	logger.Println("Reading logs...")
 
-	logsData, err := readLogs(*logsPath)
+	logsParser, err := internal.NewLogParser(*logsFormat, *logsPattern)
+	if err != nil {
+		return err
+	}
+	logsPaths, err := internal.ExpandLogPaths(logsPatterns)
+	if err != nil {
+		return err
+	}
+	logsData, err := internal.ReadLogs(logsPaths, logsParser, *logsHalfLife)
 	if err != nil {
 		return fmt.Errorf("failed to read logs: %w", err)
 	}
 
 	logger.Println("Optimizing...")
 
-	optimize(indexItems, logsData)
+	if err := optimize(indexItems, logsData); err != nil {
+		return fmt.Errorf("failed to optimize: %w", err)
+	}
 	logsData = nil
 
 	logger.Println("Writing tiles...")
@@ -175,93 +209,35 @@ func run() error {
 	return nil
 }
 
-// Read logs in OSM tile_logs format.
-// Log data: https://planet.openstreetmap.org/tile_logs/
-// Format description: https://github.com/openstreetmap/tilelog
-func readLogs(inputPath string) (map[tile.ID]uint64, error) {
-	f, err := os.Open(inputPath)
-	if err != nil {
-		return nil, err
+func optimize(indexItems []index.Item, logsData map[tile.ID]float64) error {
+	strategy, found := strategies[*strategyName]
+	if !found {
+		return fmt.Errorf("invalid strategy: %q", *strategyName)
 	}
-	defer f.Close()
-
-	var reader io.Reader = bufio.NewReaderSize(f, 64*1024)
-
-	if strings.HasSuffix(inputPath, ".xz") {
-		if reader, err = xz.NewReader(reader); err != nil {
-			return nil, err
-		}
-	}
-
-	result := make(map[tile.ID]uint64)
-
-	scanner := bufio.NewScanner(reader)
-	lineRegexp := regexp.MustCompile(`^(\d+)/(\d+)/(\d+)\s+(\d+)$`)
-
-	for scanner.Scan() {
-		matches := lineRegexp.FindSubmatch(scanner.Bytes())
-		if matches == nil {
-			return nil, fmt.Errorf("failed to parse line: %q", scanner.Text())
-		}
-
-		z, _ := strconv.Atoi(string(matches[1]))
-		x, _ := strconv.Atoi(string(matches[2]))
-		y, _ := strconv.Atoi(string(matches[3]))
-		count, _ := strconv.Atoi(string(matches[4]))
-
-		tileID := tile.ID{X: uint32(x), Y: uint32(y), Z: uint32(z)}
-		result[tileID] = uint64(count)
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	width, height, err := parseViewport(*viewport)
+	if err != nil {
+		return err
 	}
 
-	return result, nil
-}
-
-type dataChunk struct {
-	Location     tile.Location
-	RequestCount uint64
-}
-
-// Optimization strategy is based on research from https://github.com/babanov1403/tiles
-func optimize(indexItems []index.Item, logsData map[tile.ID]uint64) {
-	chunks := make([]dataChunk, 0)
-	offsetToChunkIdx := make(map[uint64]int)
+	problem := newLayoutProblem(indexItems, logsData)
 
-	for _, item := range indexItems {
-		chunkIdx, found := offsetToChunkIdx[item.Offset]
-
-		if !found {
-			chunkIdx = len(chunks)
-			offsetToChunkIdx[item.Offset] = chunkIdx
-
-			chunks = append(chunks, dataChunk{
-				Location:     item.TileLocation(),
-				RequestCount: 0,
-			})
+	if *report {
+		for _, name := range strategyNames {
+			newOffsets := problem.layout(strategies[name](problem))
+			ranges := problem.viewportRanges(newOffsets, width, height, reportViewports)
+			logger.Printf("Strategy %q: %.2f byte ranges per %dx%d viewport", name, ranges, width, height)
 		}
-
-		chunks[chunkIdx].RequestCount += logsData[item.TileID()]
 	}
 
-	slices.SortFunc(chunks, func(a, b dataChunk) int {
-		return cmp.Or(
-			-cmp.Compare(a.RequestCount, b.RequestCount),
-			cmp.Compare(a.Location.Offset, b.Location.Offset),
-		)
-	})
-
-	newOffsets := make(map[uint64]uint64, len(chunks))
-	currentOffset := uint64(0)
-
-	for _, chunk := range chunks {
-		newOffsets[chunk.Location.Offset] = currentOffset
-		currentOffset += chunk.Location.Length
+	newOffsets := problem.layout(problem.hotFirst(strategy(problem), *hotPrefix<<20))
+	offsetToChunkIdx := make(map[uint64]int, len(problem.chunks))
+	for chunkIdx, chunk := range problem.chunks {
+		offsetToChunkIdx[chunk.Location.Offset] = chunkIdx
 	}
 
 	slices.SortStableFunc(indexItems, func(a, b index.Item) int {
-		return cmp.Compare(newOffsets[a.Offset], newOffsets[b.Offset])
+		return cmp.Compare(newOffsets[offsetToChunkIdx[a.Offset]], newOffsets[offsetToChunkIdx[b.Offset]])
 	})
+
+	return nil
 }
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/eak1mov/go-libtiles/index"
+	"github.com/eak1mov/go-libtiles/pm/spec"
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// dataChunk is a unique piece of tile data, possibly shared by several tiles.
+type dataChunk struct {
+	Location     tile.Location
+	RequestCount float64
+	TileCode     uint64 // minimal Hilbert code (spec.EncodeTileID) of tiles sharing this chunk
+}
+
+// layoutProblem describes tile data to be reordered inside the data section.
+type layoutProblem struct {
+	chunks     []dataChunk
+	tileChunks map[tile.ID]int // tile -> chunk index
+	logsData   map[tile.ID]float64
+}
+
+func newLayoutProblem(indexItems []index.Item, logsData map[tile.ID]float64) *layoutProblem {
+	p := &layoutProblem{
+		tileChunks: make(map[tile.ID]int, len(indexItems)),
+		logsData:   logsData,
+	}
+	offsetToChunkIdx := make(map[uint64]int)
+
+	for _, item := range indexItems {
+		tileCode := spec.EncodeTileID(item.TileID())
+		chunkIdx, found := offsetToChunkIdx[item.Offset]
+
+		if !found {
+			chunkIdx = len(p.chunks)
+			offsetToChunkIdx[item.Offset] = chunkIdx
+
+			p.chunks = append(p.chunks, dataChunk{
+				Location:     item.TileLocation(),
+				RequestCount: 0,
+				TileCode:     tileCode,
+			})
+		}
+
+		p.chunks[chunkIdx].RequestCount += logsData[item.TileID()]
+		p.chunks[chunkIdx].TileCode = min(p.chunks[chunkIdx].TileCode, tileCode)
+		p.tileChunks[item.TileID()] = chunkIdx
+	}
+
+	return p
+}
+
+// layout returns new offsets of chunks placed in the given order.
+func (p *layoutProblem) layout(order []int) []uint64 {
+	newOffsets := make([]uint64, len(p.chunks))
+	currentOffset := uint64(0)
+	for _, chunkIdx := range order {
+		newOffsets[chunkIdx] = currentOffset
+		currentOffset += p.chunks[chunkIdx].Location.Length
+	}
+	return newOffsets
+}
+
+// hotFirst moves the most requested chunks with total length up to
+// prefixLength to the beginning, keeping their relative order.
+func (p *layoutProblem) hotFirst(order []int, prefixLength uint64) []int {
+	if prefixLength == 0 {
+		return order
+	}
+
+	isHot := make([]bool, len(p.chunks))
+	hotLength := uint64(0)
+	for _, chunkIdx := range popularityStrategy(p) {
+		chunk := &p.chunks[chunkIdx]
+		if chunk.RequestCount == 0 || hotLength+chunk.Location.Length > prefixLength {
+			break
+		}
+		isHot[chunkIdx] = true
+		hotLength += chunk.Location.Length
+	}
+
+	result := make([]int, 0, len(order))
+	for _, chunkIdx := range order {
+		if isHot[chunkIdx] {
+			result = append(result, chunkIdx)
+		}
+	}
+	for _, chunkIdx := range order {
+		if !isHot[chunkIdx] {
+			result = append(result, chunkIdx)
+		}
+	}
+	return result
+}
+
+// strategy returns the order of chunks in the data section.
+type strategy func(p *layoutProblem) []int
+
+var strategies = map[string]strategy{
+	"popularity": popularityStrategy,
+	"hilbert":    hilbertStrategy,
+	"pyramid":    pyramidStrategy,
+}
+
+var strategyNames = []string{"popularity", "hilbert", "pyramid"}
+
+func (p *layoutProblem) sortedChunks(compare func(a, b *dataChunk) int) []int {
+	order := make([]int, len(p.chunks))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Or(
+			compare(&p.chunks[a], &p.chunks[b]),
+			cmp.Compare(p.chunks[a].Location.Offset, p.chunks[b].Location.Offset),
+		)
+	})
+	return order
+}
+
+// Most requested chunks first.
+// Strategy is based on research from https://github.com/babanov1403/tiles
+func popularityStrategy(p *layoutProblem) []int {
+	return p.sortedChunks(func(a, b *dataChunk) int {
+		return -cmp.Compare(a.RequestCount, b.RequestCount)
+	})
+}
+
+// Chunks are split into popularity bands (by powers of 2 of the request count),
+// more popular bands first, chunks inside a band are in Hilbert order.
+func hilbertStrategy(p *layoutProblem) []int {
+	band := func(c *dataChunk) int {
+		return int(math.Log2(c.RequestCount + 1))
+	}
+	return p.sortedChunks(func(a, b *dataChunk) int {
+		return cmp.Or(
+			-cmp.Compare(band(a), band(b)),
+			cmp.Compare(a.TileCode, b.TileCode),
+		)
+	})
+}
+
+// Each requested tile (most popular first) is placed together with its
+// neighbours and pyramid parents which were not placed yet. Remaining chunks
+// follow in Hilbert order.
+func pyramidStrategy(p *layoutProblem) []int {
+	tileIDs := make([]tile.ID, 0, len(p.logsData))
+	for tileID, count := range p.logsData {
+		if _, found := p.tileChunks[tileID]; found && count > 0 {
+			tileIDs = append(tileIDs, tileID)
+		}
+	}
+	slices.SortFunc(tileIDs, func(a, b tile.ID) int {
+		return cmp.Or(
+			-cmp.Compare(p.logsData[a], p.logsData[b]),
+			cmp.Compare(a.Z, b.Z),
+			cmp.Compare(a.X, b.X),
+			cmp.Compare(a.Y, b.Y),
+		)
+	})
+
+	order := make([]int, 0, len(p.chunks))
+	placed := make([]bool, len(p.chunks))
+	group := make([]int, 0, 32)
+
+	addTile := func(tileID tile.ID) {
+		chunkIdx, found := p.tileChunks[tileID]
+		if found && !placed[chunkIdx] {
+			placed[chunkIdx] = true
+			group = append(group, chunkIdx)
+		}
+	}
+
+	for _, tileID := range tileIDs {
+		group = group[:0]
+
+		addTile(tileID)
+		for _, neighbour := range viewportTiles(tileID, 3, 3) {
+			addTile(neighbour)
+		}
+		for parentID := tileID; parentID.Z > 0; {
+			parentID = tile.ID{X: parentID.X / 2, Y: parentID.Y / 2, Z: parentID.Z - 1}
+			addTile(parentID)
+		}
+
+		slices.SortFunc(group, func(a, b int) int {
+			return cmp.Compare(p.chunks[a].TileCode, p.chunks[b].TileCode)
+		})
+		order = append(order, group...)
+	}
+
+	for _, chunkIdx := range hilbertStrategy(p) {
+		if !placed[chunkIdx] {
+			order = append(order, chunkIdx)
+		}
+	}
+
+	return order
+}
+
+// viewportTiles returns tiles of a width x height viewport centered at tileID.
+func viewportTiles(tileID tile.ID, width, height uint32) []tile.ID {
+	tilesCount := int64(1) << tileID.Z
+	minX := max(int64(tileID.X)-int64(width-1)/2, 0)
+	minY := max(int64(tileID.Y)-int64(height-1)/2, 0)
+	maxX := min(minX+int64(width), tilesCount)
+	maxY := min(minY+int64(height), tilesCount)
+
+	result := make([]tile.ID, 0, width*height)
+	for x := minX; x < maxX; x++ {
+		for y := minY; y < maxY; y++ {
+			result = append(result, tile.ID{X: uint32(x), Y: uint32(y), Z: tileID.Z})
+		}
+	}
+	return result
+}
+
+// viewportRanges returns the expected number of byte ranges needed to fetch
+// all tiles of a typical viewport: viewports are centered at the most requested
+// tiles and weighted by their request counts. Adjacent chunks are merged into
+// a single range.
+func (p *layoutProblem) viewportRanges(newOffsets []uint64, width, height uint32, maxViewports int) float64 {
+	centers := make([]tile.ID, 0, len(p.logsData))
+	for tileID, count := range p.logsData {
+		if count > 0 {
+			centers = append(centers, tileID)
+		}
+	}
+	// ties are broken by Hilbert code, so that the same viewports are selected in every run
+	slices.SortFunc(centers, func(a, b tile.ID) int {
+		return cmp.Or(
+			-cmp.Compare(p.logsData[a], p.logsData[b]),
+			cmp.Compare(spec.EncodeTileID(a), spec.EncodeTileID(b)),
+		)
+	})
+	centers = centers[:min(len(centers), maxViewports)]
+
+	var sumRanges, sumWeights float64
+	var locations []tile.Location
+
+	for _, center := range centers {
+		locations = locations[:0]
+		for _, tileID := range viewportTiles(center, width, height) {
+			if chunkIdx, found := p.tileChunks[tileID]; found {
+				locations = append(locations, tile.Location{
+					Offset: newOffsets[chunkIdx],
+					Length: p.chunks[chunkIdx].Location.Length,
+				})
+			}
+		}
+		slices.SortFunc(locations, func(a, b tile.Location) int {
+			return cmp.Compare(a.Offset, b.Offset)
+		})
+		locations = slices.Compact(locations)
+
+		ranges := 0
+		for i, location := range locations {
+			if i == 0 || locations[i-1].Offset+locations[i-1].Length != location.Offset {
+				ranges++
+			}
+		}
+
+		weight := p.logsData[center]
+		sumRanges += float64(ranges) * weight
+		sumWeights += weight
+	}
+
+	if sumWeights == 0 {
+		return 0
+	}
+	return sumRanges / sumWeights
+}
+
+func parseViewport(value string) (width, height uint32, err error) {
+	if _, err := fmt.Sscanf(value, "%dx%d", &width, &height); err != nil {
+		return 0, 0, fmt.Errorf("invalid viewport %q: %w", value, err)
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("invalid viewport %q", value)
+	}
+	return width, height, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/eak1mov/go-libtiles/cmd/internal"
+	"github.com/eak1mov/go-libtiles/wt"
+)
+
+var (
+	inputPath   = flag.String("i", "", "Input wtiles path, modified in place")
+	disableLogs = flag.Bool("q", false, "Disable debug logs")
+	indexFlags  = internal.RegisterIndexFlags()
+)
+
+var logger = log.Default()
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <path> -index <format> [-levels <zooms>] [-region <z/x/y>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *disableLogs {
+		logger = log.New(io.Discard, "", log.LstdFlags)
+	}
+
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run() error {
+	if indexFlags.Empty() {
+		return fmt.Errorf("index format is not specified")
+	}
+	indexOpts, err := indexFlags.WriterOptions()
+	if err != nil {
+		return err
+	}
+	return wt.Reindex(*inputPath, append(indexOpts, wt.WithLogger(logger))...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import "container/list"
+
+type cacheStats struct {
+	Reads           uint64 // read requests issued by the reader
+	StorageRequests uint64 // requests sent to the storage on cache misses
+	BytesRead       uint64 // bytes fetched from the storage
+	BlockHits       uint64
+	BlockMisses     uint64
+}
+
+func (s cacheStats) HitRate() float64 {
+	if s.BlockHits+s.BlockMisses == 0 {
+		return 0
+	}
+	return float64(s.BlockHits) / float64(s.BlockHits+s.BlockMisses)
+}
+
+// blockCache models a page cache: file is split into fixed-size blocks,
+// least recently used blocks are evicted first.
+type blockCache struct {
+	blockSize uint64
+	capacity  int
+	fileSize  uint64
+	coalesce  bool
+
+	lru   *list.List // front = most recently used block index
+	items map[uint64]*list.Element
+
+	stats cacheStats
+}
+
+func newBlockCache(cacheSize, blockSize, fileSize uint64, coalesce bool) *blockCache {
+	return &blockCache{
+		blockSize: blockSize,
+		capacity:  int(max(cacheSize/blockSize, 1)),
+		fileSize:  fileSize,
+		coalesce:  coalesce,
+		lru:       list.New(),
+		items:     make(map[uint64]*list.Element),
+	}
+}
+
+// Access simulates reading [offset, offset+length) through the cache.
+// Missing blocks are fetched from the storage: adjacent missing blocks are
+// fetched with a single request if coalescing is enabled.
+func (c *blockCache) Access(offset, length uint64) {
+	if length == 0 {
+		return
+	}
+	c.stats.Reads++
+
+	firstBlock := offset / c.blockSize
+	lastBlock := (offset + length - 1) / c.blockSize
+	prevMissing := false
+
+	for block := firstBlock; block <= lastBlock; block++ {
+		if elem, found := c.items[block]; found {
+			c.stats.BlockHits++
+			c.lru.MoveToFront(elem)
+			prevMissing = false
+			continue
+		}
+
+		c.stats.BlockMisses++
+		c.stats.BytesRead += c.blockLength(block)
+		if !c.coalesce || !prevMissing {
+			c.stats.StorageRequests++
+		}
+		prevMissing = true
+
+		c.items[block] = c.lru.PushFront(block)
+		if c.lru.Len() > c.capacity {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.items, oldest.Value.(uint64))
+		}
+	}
+}
+
+func (c *blockCache) blockLength(block uint64) uint64 {
+	blockOffset := block * c.blockSize
+	return min(c.blockSize, c.fileSize-min(blockOffset, c.fileSize))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/eak1mov/go-libtiles/cmd/internal"
+	"github.com/eak1mov/go-libtiles/pm"
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/eak1mov/go-libtiles/wt"
+)
+
+var (
+	inputFormat = flag.String("f", "", "Input format (pmtiles, wtiles)")
+	logsFormat  = flag.String("lf", "tilelog", "Logs format (tilelog, combined, csv)")
+	logsPattern = flag.String("lp", "/{z}/{x}/{y}.png", "URL pattern for combined logs, matched against the end of the request path")
+	cacheSize   = flag.Uint64("cache", 64, "Cache size (MiB)")
+	blockSize   = flag.Uint64("block", 64, "Cache block size (KiB)")
+	coalesce    = flag.Bool("coalesce", true, "Fetch adjacent missing blocks with a single request")
+	sampleCount = flag.Int("n", 1_000_000, "Number of requests sampled from aggregated logs, replay logs in order if zero")
+	sampleSeed  = flag.Uint64("seed", 1, "Random seed for sampling")
+
+	inputPaths   []string
+	logsPatterns []string
+)
+
+func init() {
+	flag.Func("i", "Input path, can be repeated to compare layouts (e.g. original and optimized)", func(s string) error {
+		inputPaths = append(inputPaths, s)
+		return nil
+	})
+	flag.Func("l", "Logs path or glob, can be repeated", func(s string) error {
+		logsPatterns = append(logsPatterns, s)
+		return nil
+	})
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <path> [-i <path>...] -l <path> [-f <format>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run() error {
+	if *blockSize == 0 {
+		return fmt.Errorf("invalid block size: %v", *blockSize)
+	}
+
+	logsParser, err := internal.NewLogParser(*logsFormat, *logsPattern)
+	if err != nil {
+		return err
+	}
+	logsPaths, err := internal.ExpandLogPaths(logsPatterns)
+	if err != nil {
+		return err
+	}
+
+	var replay func(fn func(tile.ID) error) error
+	if *sampleCount > 0 {
+		logsData, err := internal.ReadLogs(logsPaths, logsParser, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read logs: %w", err)
+		}
+		replay = sampleRequests(logsData, *sampleCount, *sampleSeed)
+	} else {
+		replay = func(fn func(tile.ID) error) error {
+			for _, logPath := range logsPaths {
+				err := internal.VisitLog(logPath, logsParser, func(record internal.LogRecord) error {
+					for range record.Count {
+						if err := fn(record.TileID); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "input\ttiles\treads\tstorage requests\tbytes read\thit rate\t")
+
+	for _, inputPath := range inputPaths {
+		tiles, stats, err := simulate(inputPath, replay)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inputPath, err)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.2f%%\t\n",
+			inputPath, tiles, stats.Reads, stats.StorageRequests, stats.BytesRead, stats.HitRate()*100)
+	}
+
+	return w.Flush()
+}
+
+// sampleRequests returns a replay function which yields n tiles randomly
+// sampled with probabilities proportional to their request counts.
+func sampleRequests(logsData map[tile.ID]float64, n int, seed uint64) func(fn func(tile.ID) error) error {
+	tileIDs := make([]tile.ID, 0, len(logsData))
+	for tileID, count := range logsData {
+		if count > 0 {
+			tileIDs = append(tileIDs, tileID)
+		}
+	}
+	slices.SortFunc(tileIDs, func(a, b tile.ID) int {
+		return cmp.Or(cmp.Compare(a.Z, b.Z), cmp.Compare(a.X, b.X), cmp.Compare(a.Y, b.Y))
+	})
+
+	cumulative := make([]float64, len(tileIDs))
+	sum := 0.0
+	for i, tileID := range tileIDs {
+		sum += logsData[tileID]
+		cumulative[i] = sum
+	}
+
+	return func(fn func(tile.ID) error) error {
+		if len(tileIDs) == 0 {
+			return nil
+		}
+		random := rand.New(rand.NewPCG(seed, seed))
+		for range n {
+			value := random.Float64() * sum
+			idx := sort.SearchFloat64s(cumulative, value)
+			if err := fn(tileIDs[min(idx, len(tileIDs)-1)]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func simulate(inputPath string, replay func(fn func(tile.ID) error) error) (uint64, cacheStats, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return 0, cacheStats{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, cacheStats{}, err
+	}
+
+	cache := newBlockCache(*cacheSize<<20, *blockSize<<10, uint64(fileInfo.Size()), *coalesce)
+
+	fileAccess := func(offset, length uint64) ([]byte, error) {
+		cache.Access(offset, length)
+		buffer := make([]byte, length)
+		if _, err := file.ReadAt(buffer, int64(offset)); err != nil {
+			return nil, err
+		}
+		return buffer, nil
+	}
+
+	var reader tile.Reader
+	switch format := internal.DeduceFormat(*inputFormat, inputPath); format {
+	case "pmtiles":
+		reader, err = pm.NewReader(fileAccess)
+	case "wtiles":
+		reader, err = wt.NewReader(fileAccess)
+	default:
+		return 0, cacheStats{}, fmt.Errorf("invalid input format of %q: %q", inputPath, format)
+	}
+	if err != nil {
+		return 0, cacheStats{}, err
+	}
+
+	tiles := uint64(0)
+	err = replay(func(tileID tile.ID) error {
+		tiles++
+		_, err := reader.ReadTile(tileID)
+		return err
+	})
+	if err != nil {
+		return 0, cacheStats{}, err
+	}
+
+	return tiles, cache.stats, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/eak1mov/go-libtiles/cmd/internal"
+	"github.com/eak1mov/go-libtiles/index"
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+var (
+	outputPath  = flag.String("o", "", "Output index file path")
+	sortOrder   = flag.String("sort", "hilbert", "Sort order (hilbert, morton, offset, zoom)")
+	mergeOnly   = flag.Bool("merge", false, "Inputs are already sorted, only merge them")
+	deduplicate = flag.Bool("d", false, "Remove identical items")
+	memoryLimit = flag.Uint64("m", index.DefaultMemoryLimit>>20, "Memory limit for sorting in MiB")
+	tempDir     = flag.String("tmp", "", "Directory for temporary files")
+	legacyIndex = flag.Bool("legacy", false, "Write index without header, for tools that don't support it")
+
+	inputPaths []string
+)
+
+func init() {
+	flag.Func("i", "Input index file path, can be repeated", func(s string) error {
+		inputPaths = append(inputPaths, s)
+		return nil
+	})
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i <path> [-i <path>...] -o <path> [-sort <order>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run() error {
+	order, err := index.ParseOrder(*sortOrder)
+	if err != nil {
+		return err
+	}
+
+	var inputs []tile.LocationVisitor
+	for _, inputPath := range inputPaths {
+		inputFile, err := os.Open(inputPath)
+		if err != nil {
+			return err
+		}
+		defer inputFile.Close()
+		inputs = append(inputs, internal.NewIndexDecoder(inputFile))
+	}
+
+	var items tile.LocationVisitor
+	if *mergeOnly {
+		items = index.Merge(order, inputs...)
+	} else {
+		sorter := index.NewSorter(
+			order,
+			index.WithMemoryLimit(*memoryLimit<<20),
+			index.WithTempDir(*tempDir),
+		)
+		defer sorter.Close()
+		for _, input := range inputs {
+			if err := sorter.AddFrom(input); err != nil {
+				return err
+			}
+		}
+		items = sorter
+	}
+	if *deduplicate {
+		items = index.Dedup(items)
+	}
+
+	outputFile, err := os.Create(*outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	outputEncoder, err := internal.NewIndexEncoder(outputFile, *legacyIndex)
+	if err != nil {
+		return err
+	}
+	if err := outputEncoder.EncodeFrom(items); err != nil {
+		return err
+	}
+	if err := outputEncoder.Finalize(); err != nil {
+		return err
+	}
+	return outputFile.Sync()
+}
@@ -0,0 +1,189 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+const (
+	ErrInvalidHeader tile.Error = "libtiles: invalid index header"
+	ErrInvalidLength tile.Error = "libtiles: invalid index length"
+	ErrChecksum      tile.Error = "libtiles: index checksum mismatch"
+)
+
+// HeaderMagic starts index files with Header. Its fourth byte is above 0x7F,
+// so it can't be the X of a valid tile in the first item of a legacy file.
+var HeaderMagic = [8]byte{'L', 'T', 'I', 0xFF, 'D', 'X', '\r', '\n'}
+
+const (
+	HeaderVersion = 1
+	HeaderLength  = 40
+)
+
+// LayoutItem is the record layout of Item: X, Y, Z, Length (uint32) and
+// Offset (uint64), little-endian.
+const LayoutItem = 1
+
+// Header is the optional header of index files, followed by Count items.
+// Files without header (legacy format) contain items only.
+type Header struct {
+	Magic    [8]byte
+	Version  uint32
+	Layout   uint32
+	ItemSize uint32 // size of a single item in bytes
+	Reserved uint32
+	Count    uint64 // number of items
+	Checksum uint32 // CRC-32C of all items
+	Padding  uint32
+}
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+func newHeader(count uint64, checksum uint32) Header {
+	return Header{
+		Magic:    HeaderMagic,
+		Version:  HeaderVersion,
+		Layout:   LayoutItem,
+		ItemSize: uint32(binary.Size(Item{})),
+		Count:    count,
+		Checksum: checksum,
+	}
+}
+
+func (h Header) valid() bool {
+	return h.Magic == HeaderMagic &&
+		h.Version == HeaderVersion &&
+		h.Layout == LayoutItem &&
+		h.ItemSize == uint32(binary.Size(Item{}))
+}
+
+func hasHeader(data []byte) bool {
+	return len(data) >= len(HeaderMagic) && bytes.Equal(data[:len(HeaderMagic)], HeaderMagic[:])
+}
+
+// FileEncoder writes an index file with Header. The header is written at the
+// beginning of the file by Finalize, when item count and checksum are known.
+type FileEncoder struct {
+	w        io.WriteSeeker
+	start    int64 // position of the header
+	buffer   *bufio.Writer
+	encoder  *Encoder
+	checksum hash.Hash32
+	count    uint64
+}
+
+// NewFileEncoder creates a FileEncoder writing to w from its current position.
+func NewFileEncoder(w io.WriteSeeker) (*FileEncoder, error) {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(make([]byte, HeaderLength)); err != nil {
+		return nil, err
+	}
+	checksum := crc32.New(checksumTable)
+	buffer := bufio.NewWriter(io.MultiWriter(w, checksum))
+	return &FileEncoder{
+		w:        w,
+		start:    start,
+		buffer:   buffer,
+		encoder:  NewEncoder(buffer),
+		checksum: checksum,
+	}, nil
+}
+
+func (e *FileEncoder) Encode(item Item) error {
+	e.count++
+	return e.encoder.Encode(item)
+}
+
+func (e *FileEncoder) EncodeAll(items []Item) error {
+	e.count += uint64(len(items))
+	return e.encoder.EncodeAll(items)
+}
+
+func (e *FileEncoder) EncodeFrom(src tile.LocationVisitor) error {
+	return src.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		return e.Encode(NewItem(tileID, location))
+	})
+}
+
+// Finalize flushes items and writes the header. Items can't be encoded after
+// Finalize.
+func (e *FileEncoder) Finalize() error {
+	if err := e.buffer.Flush(); err != nil {
+		return err
+	}
+	end, err := e.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Seek(e.start, io.SeekStart); err != nil {
+		return err
+	}
+	header := newHeader(e.count, e.checksum.Sum32())
+	if err := binary.Write(e.w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	_, err = e.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// headerReader validates items of a file with Header while they are read.
+type headerReader struct {
+	r        io.Reader
+	header   Header
+	checksum hash.Hash32
+	length   uint64 // remaining length of items
+}
+
+func newHeaderReader(r io.Reader) (*headerReader, error) {
+	var header Header
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !header.valid() {
+		return nil, ErrInvalidHeader
+	}
+	return &headerReader{
+		r:        r,
+		header:   header,
+		checksum: crc32.New(checksumTable),
+		length:   header.Count * uint64(header.ItemSize),
+	}, nil
+}
+
+func (h *headerReader) Read(p []byte) (int, error) {
+	if h.length == 0 {
+		// the file must end after the last item
+		var extra [1]byte
+		if n, _ := io.ReadAtLeast(h.r, extra[:], 1); n != 0 {
+			return 0, ErrInvalidLength
+		}
+		if h.checksum.Sum32() != h.header.Checksum {
+			return 0, ErrChecksum
+		}
+		return 0, io.EOF
+	}
+
+	p = p[:min(uint64(len(p)), h.length)]
+	n, err := h.r.Read(p)
+	h.checksum.Write(p[:n])
+	h.length -= uint64(n)
+	if err == io.EOF && h.length != 0 {
+		return n, ErrInvalidLength
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,98 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/index"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileEncoder(t *testing.T) {
+	items := randomItems(100)
+
+	filePath := filepath.Join(t.TempDir(), "items.index")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer file.Close()
+
+	encoder, err := index.NewFileEncoder(file)
+	if err != nil {
+		t.Fatalf("NewFileEncoder failed: %v", err)
+	}
+	if err := encoder.EncodeFrom(index.ItemsVisitor(items)); err != nil {
+		t.Fatalf("EncodeFrom failed: %v", err)
+	}
+	if err := encoder.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+
+	fileData, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	var legacyData bytes.Buffer
+	if err := index.NewEncoder(&legacyData).EncodeAll(items); err != nil {
+		t.Fatalf("EncodeAll failed: %v", err)
+	}
+
+	corruptedData := bytes.Clone(fileData)
+	corruptedData[index.HeaderLength] ^= 1
+
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		want    []index.Item
+		wantErr error
+	}{
+		{"Header", fileData, items, nil},
+		{"Legacy", legacyData.Bytes(), items, nil},
+		{"Truncated", fileData[:len(fileData)-1], nil, index.ErrInvalidLength},
+		{"Extended", append(bytes.Clone(fileData), 0), nil, index.ErrInvalidLength},
+		{"Corrupted", corruptedData, nil, index.ErrChecksum},
+		{"InvalidHeader", fileData[:index.HeaderLength-1], nil, index.ErrInvalidHeader},
+		{"TruncatedLegacy", legacyData.Bytes()[:legacyData.Len()-1], nil, index.ErrInvalidLength},
+	} {
+		got, err := index.DecodeAll(tc.data)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: DecodeAll() error = %v, want = %v", tc.name, err, tc.wantErr)
+		}
+		if diff := cmp.Diff(tc.want, got); err == nil && diff != "" {
+			t.Errorf("%s: DecodeAll() mismatch (-want +got):\n%s", tc.name, diff)
+		}
+
+		got, err = index.Collect(index.NewDecoder(bytes.NewReader(tc.data)))
+		if tc.wantErr == nil && err != nil {
+			t.Errorf("%s: Decoder error = %v", tc.name, err)
+		}
+		if tc.wantErr != nil && err == nil {
+			t.Errorf("%s: Decoder error = nil, want error", tc.name)
+		}
+		if diff := cmp.Diff(tc.want, got); err == nil && diff != "" {
+			t.Errorf("%s: Decoder mismatch (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestDecoderHeader(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := index.NewEncoder(&buffer).EncodeAll(randomItems(1)); err != nil {
+		t.Fatalf("EncodeAll failed: %v", err)
+	}
+	header, err := index.NewDecoder(&buffer).Header()
+	if err != nil || header != nil {
+		t.Errorf("Header() of legacy file = %v, %v, want = nil, nil", header, err)
+	}
+
+	header, err = index.NewDecoder(bytes.NewReader(index.HeaderMagic[:])).Header()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Header() of truncated file = %v, %v, want = %v", header, err, io.ErrUnexpectedEOF)
+	}
+}
@@ -2,9 +2,10 @@
 package index
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"hash/crc32"
 	"io"
 
 	"github.com/eak1mov/go-libtiles/tile"
@@ -14,11 +15,11 @@ import (
 // to its location (Offset, Length) in the tile storage file.
 // It is designed to be easily portable to other languages and utilities.
 type Item struct {
-	X      uint32
-	Y      uint32
-	Z      uint32
-	Length uint32
-	Offset uint64
+	X      uint32 `json:"x"`
+	Y      uint32 `json:"y"`
+	Z      uint32 `json:"z"`
+	Length uint32 `json:"length"`
+	Offset uint64 `json:"offset"`
 }
 
 func NewItem(tileID tile.ID, tileLocation tile.Location) Item {
@@ -61,36 +62,83 @@ func (e *Encoder) EncodeFrom(src tile.LocationVisitor) error {
 	})
 }
 
+// Decoder reads items of files with Header (validating item count and
+// checksum) and of legacy files without header, the format is detected on
+// the first read.
 type Decoder struct {
-	r io.Reader
+	r        io.Reader
+	header   *Header
+	err      error
+	prepared bool
 }
 
 func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{r: r}
 }
 
+func (d *Decoder) prepare() error {
+	if d.prepared {
+		return d.err
+	}
+	d.prepared = true
+
+	reader := bufio.NewReader(d.r)
+	d.r = reader
+	if magic, _ := reader.Peek(len(HeaderMagic)); hasHeader(magic) {
+		headerReader, err := newHeaderReader(reader)
+		if err != nil {
+			d.err = err
+			return err
+		}
+		d.r = headerReader
+		d.header = &headerReader.header
+	}
+	return nil
+}
+
+// Header returns the header of the file, or nil for legacy files.
+func (d *Decoder) Header() (*Header, error) {
+	if err := d.prepare(); err != nil {
+		return nil, err
+	}
+	return d.header, nil
+}
+
 func (d *Decoder) Decode() (item Item, err error) {
+	if err = d.prepare(); err != nil {
+		return
+	}
 	err = binary.Read(d.r, binary.LittleEndian, &item)
 	return
 }
 
 func (d *Decoder) VisitLocations(fn tile.LocationVisitFunc) error {
-	for {
-		item, err := d.Decode()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil
-			}
-			return err
+	return visitDecoded(d.Decode, fn)
+}
+
+// DecodeAll decodes items of a file with Header or of a legacy file.
+func DecodeAll(indexData []byte) ([]Item, error) {
+	itemSize := binary.Size(Item{})
+	if hasHeader(indexData) {
+		var header Header
+		if err := binary.Read(bytes.NewReader(indexData), binary.LittleEndian, &header); err != nil {
+			return nil, ErrInvalidHeader
 		}
-		if err := fn(item.TileID(), item.TileLocation()); err != nil {
-			return err
+		if !header.valid() {
+			return nil, ErrInvalidHeader
+		}
+		indexData = indexData[HeaderLength:]
+		if uint64(len(indexData)) != header.Count*uint64(itemSize) {
+			return nil, ErrInvalidLength
 		}
+		if crc32.Checksum(indexData, checksumTable) != header.Checksum {
+			return nil, ErrChecksum
+		}
+	} else if len(indexData)%itemSize != 0 {
+		return nil, ErrInvalidLength
 	}
-}
 
-func DecodeAll(indexData []byte) ([]Item, error) {
-	count := len(indexData) / binary.Size(Item{})
+	count := len(indexData) / itemSize
 	items := make([]Item, count)
 
 	err := binary.Read(bytes.NewReader(indexData), binary.LittleEndian, items)
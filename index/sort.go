@@ -0,0 +1,233 @@
+package index
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/eak1mov/go-libtiles/internal/extsort"
+	"github.com/eak1mov/go-libtiles/internal/morton"
+	"github.com/eak1mov/go-libtiles/pm/spec"
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// Order is a sort order of index items. All orders compare all fields of
+// items, so identical items are always adjacent (see Dedup).
+type Order int
+
+const (
+	OrderHilbert Order = iota // by TileCode of PMTiles (zoom, then Hilbert curve)
+	OrderMorton               // by zoom, then Morton code of X and Y
+	OrderOffset               // by offset, then length (order of tile data)
+	OrderZoom                 // by zoom, then X, then Y
+)
+
+var orderNames = map[Order]string{
+	OrderHilbert: "hilbert",
+	OrderMorton:  "morton",
+	OrderOffset:  "offset",
+	OrderZoom:    "zoom",
+}
+
+func (o Order) String() string {
+	if name, found := orderNames[o]; found {
+		return name
+	}
+	return fmt.Sprintf("Order(%d)", int(o))
+}
+
+// ParseOrder returns the order with given name (see Order.String).
+func ParseOrder(name string) (Order, error) {
+	for order, orderName := range orderNames {
+		if orderName == name {
+			return order, nil
+		}
+	}
+	return 0, fmt.Errorf("libtiles: invalid index order: %q", name)
+}
+
+// Compare returns -1, 0 or +1 depending on whether a is before, equal to or
+// after b in this order.
+func (o Order) Compare(a, b Item) int {
+	compareTiles := func() int {
+		return cmp.Compare(spec.EncodeTileID(a.TileID()), spec.EncodeTileID(b.TileID()))
+	}
+	compareLocations := func() int {
+		return cmp.Or(cmp.Compare(a.Offset, b.Offset), cmp.Compare(a.Length, b.Length))
+	}
+
+	switch o {
+	case OrderMorton:
+		return cmp.Or(
+			cmp.Compare(a.Z, b.Z),
+			cmp.Compare(morton.Encode64(a.X, a.Y), morton.Encode64(b.X, b.Y)),
+			compareLocations(),
+		)
+	case OrderOffset:
+		return cmp.Or(compareLocations(), compareTiles())
+	case OrderZoom:
+		return cmp.Or(
+			cmp.Compare(a.Z, b.Z),
+			cmp.Compare(a.X, b.X),
+			cmp.Compare(a.Y, b.Y),
+			compareLocations(),
+		)
+	default:
+		return cmp.Or(compareTiles(), compareLocations())
+	}
+}
+
+// DefaultMemoryLimit is the default size of the in-memory buffer of Sorter.
+const DefaultMemoryLimit = 1 << 30
+
+type sorterConfig struct {
+	MemoryLimit uint64
+	TempDir     string
+}
+
+type SorterOption func(*sorterConfig)
+
+// WithMemoryLimit sets the approximate memory limit in bytes for items kept
+// in memory (DefaultMemoryLimit by default).
+func WithMemoryLimit(memoryLimit uint64) SorterOption {
+	return func(c *sorterConfig) { c.MemoryLimit = memoryLimit }
+}
+
+// WithTempDir sets the directory for temporary files (os.TempDir by default).
+func WithTempDir(tempDir string) SorterOption {
+	return func(c *sorterConfig) { c.TempDir = tempDir }
+}
+
+// Sorter sorts any number of items with bounded memory: items are sorted in
+// runs limited by the memory limit, spilled to temporary files, and merged
+// when visited. Sorter implements tile.LocationVisitor, so sorted items can be
+// passed directly to Encoder.EncodeFrom, pm.Import or wt.Import.
+//
+// Close must be called to remove temporary files.
+type Sorter struct {
+	sorter *extsort.Sorter[Item]
+}
+
+func NewSorter(order Order, opts ...SorterOption) *Sorter {
+	config := sorterConfig{
+		MemoryLimit: DefaultMemoryLimit,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &Sorter{
+		sorter: extsort.New(order.Compare, itemCodec, config.MemoryLimit, config.TempDir),
+	}
+}
+
+// itemCodec encodes items as Encoder does.
+var itemCodec = extsort.Codec[Item]{
+	Size: binary.Size(Item{}),
+	Encode: func(buffer []byte, item Item) {
+		binary.LittleEndian.PutUint32(buffer[0:], item.X)
+		binary.LittleEndian.PutUint32(buffer[4:], item.Y)
+		binary.LittleEndian.PutUint32(buffer[8:], item.Z)
+		binary.LittleEndian.PutUint32(buffer[12:], item.Length)
+		binary.LittleEndian.PutUint64(buffer[16:], item.Offset)
+	},
+	Decode: func(buffer []byte) Item {
+		return Item{
+			X:      binary.LittleEndian.Uint32(buffer[0:]),
+			Y:      binary.LittleEndian.Uint32(buffer[4:]),
+			Z:      binary.LittleEndian.Uint32(buffer[8:]),
+			Length: binary.LittleEndian.Uint32(buffer[12:]),
+			Offset: binary.LittleEndian.Uint64(buffer[16:]),
+		}
+	},
+}
+
+func (s *Sorter) Add(item Item) error {
+	return s.sorter.Add(item)
+}
+
+// AddFrom adds all items of src.
+func (s *Sorter) AddFrom(src tile.LocationVisitor) error {
+	return src.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		return s.Add(NewItem(tileID, location))
+	})
+}
+
+// VisitLocations calls fn for all added items in the sort order. Items can't
+// be added after VisitLocations.
+func (s *Sorter) VisitLocations(fn tile.LocationVisitFunc) error {
+	return s.sorter.Visit(func(item Item) error {
+		return fn(item.TileID(), item.TileLocation())
+	})
+}
+
+// Close removes temporary files.
+func (s *Sorter) Close() error {
+	return s.sorter.Close()
+}
+
+// Merge returns a visitor of items of all sources merged in the given order,
+// each source must be sorted in this order. Sources are visited concurrently
+// and only once, so they can be Decoders of large files.
+func Merge(order Order, sources ...tile.LocationVisitor) tile.LocationVisitor {
+	return mergeVisitor{order: order, sources: sources}
+}
+
+type mergeVisitor struct {
+	order   Order
+	sources []tile.LocationVisitor
+}
+
+var errVisitStopped = errors.New("visit stopped")
+
+func (v mergeVisitor) VisitLocations(fn tile.LocationVisitFunc) error {
+	sources := make([]extsort.Source[Item], len(v.sources))
+	for i, src := range v.sources {
+		var visitErr error
+		next, stop := iter.Pull(func(yield func(Item) bool) {
+			visitErr = src.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+				if !yield(NewItem(tileID, location)) {
+					return errVisitStopped
+				}
+				return nil
+			})
+		})
+		defer stop()
+		sources[i] = func() (Item, bool, error) {
+			item, ok := next()
+			if !ok {
+				return item, false, visitErr
+			}
+			return item, true, nil
+		}
+	}
+
+	return extsort.Merge(v.order.Compare, sources, func(item Item) error {
+		return fn(item.TileID(), item.TileLocation())
+	})
+}
+
+// Dedup returns a visitor of items of src, skipping items identical to the
+// previous one. If src is sorted in any Order, all identical items are removed.
+func Dedup(src tile.LocationVisitor) tile.LocationVisitor {
+	return dedupVisitor{src: src}
+}
+
+type dedupVisitor struct {
+	src tile.LocationVisitor
+}
+
+func (v dedupVisitor) VisitLocations(fn tile.LocationVisitFunc) error {
+	var last Item
+	isFirst := true
+	return v.src.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		item := NewItem(tileID, location)
+		if !isFirst && item == last {
+			return nil
+		}
+		last = item
+		isFirst = false
+		return fn(tileID, location)
+	})
+}
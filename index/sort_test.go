@@ -0,0 +1,91 @@
+package index_test
+
+import (
+	"math/rand/v2"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/index"
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func randomItems(count int) []index.Item {
+	r := rand.New(rand.NewPCG(1, 2))
+	items := make([]index.Item, count)
+	for i := range items {
+		z := r.Uint32N(10)
+		items[i] = index.Item{
+			X:      r.Uint32N(1 << z),
+			Y:      r.Uint32N(1 << z),
+			Z:      z,
+			Length: r.Uint32N(4),
+			Offset: r.Uint64N(16),
+		}
+	}
+	return items
+}
+
+func TestSorter(t *testing.T) {
+	items := randomItems(1000)
+	for _, order := range []index.Order{
+		index.OrderHilbert,
+		index.OrderMorton,
+		index.OrderOffset,
+		index.OrderZoom,
+	} {
+		tempDir := t.TempDir()
+		sorter := index.NewSorter(
+			order,
+			index.WithMemoryLimit(24*100), // 100 items per run
+			index.WithTempDir(tempDir),
+		)
+		defer sorter.Close()
+
+		if err := sorter.AddFrom(index.ItemsVisitor(items)); err != nil {
+			t.Fatalf("AddFrom failed: %v", err)
+		}
+		got, err := index.Collect(sorter)
+		if err != nil {
+			t.Fatalf("Collect failed: %v", err)
+		}
+
+		want := slices.Clone(items)
+		slices.SortFunc(want, order.Compare)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Sorter(%v) mismatch (-want +got):\n%s", order, diff)
+		}
+
+		if err := sorter.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if tempFiles, _ := os.ReadDir(tempDir); len(tempFiles) != 0 {
+			t.Errorf("Sorter(%v): temporary files are not removed: %v", order, tempFiles)
+		}
+	}
+}
+
+func TestMergeDedup(t *testing.T) {
+	order := index.OrderHilbert
+	items := randomItems(1000)
+
+	var sources []tile.LocationVisitor
+	for part := range slices.Chunk(items, 300) {
+		part = slices.Clone(part)
+		slices.SortFunc(part, order.Compare)
+		sources = append(sources, index.ItemsVisitor(part))
+	}
+
+	want := slices.Clone(items)
+	slices.SortFunc(want, order.Compare)
+	want = slices.Compact(want)
+
+	got, err := index.Collect(index.Dedup(index.Merge(order, sources...)))
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Dedup(Merge()) mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,278 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+const ErrInvalidCSV tile.Error = "libtiles: invalid CSV index"
+const ErrInvalidJSON tile.Error = "libtiles: invalid JSON index"
+
+// csvColumns are names of CSV columns, in order of Item fields.
+var csvColumns = []string{"x", "y", "z", "length", "offset"}
+
+// CSVEncoder writes items as CSV with a header row (x, y, z, length, offset).
+// Flush must be called after the last item.
+type CSVEncoder struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func NewCSVEncoder(w io.Writer) *CSVEncoder {
+	return &CSVEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *CSVEncoder) Encode(item Item) error {
+	if !e.headerWritten {
+		if err := e.w.Write(csvColumns); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	return e.w.Write([]string{
+		strconv.FormatUint(uint64(item.X), 10),
+		strconv.FormatUint(uint64(item.Y), 10),
+		strconv.FormatUint(uint64(item.Z), 10),
+		strconv.FormatUint(uint64(item.Length), 10),
+		strconv.FormatUint(item.Offset, 10),
+	})
+}
+
+func (e *CSVEncoder) EncodeAll(items []Item) error {
+	for _, item := range items {
+		if err := e.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *CSVEncoder) EncodeFrom(src tile.LocationVisitor) error {
+	return src.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		return e.Encode(NewItem(tileID, location))
+	})
+}
+
+// Flush writes the header row if there were no items, and flushes buffered data.
+func (e *CSVEncoder) Flush() error {
+	if !e.headerWritten {
+		if err := e.w.Write(csvColumns); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// CSVDecoder reads items from CSV with a header row. Columns are matched by
+// names (see CSVEncoder) in any order, all of them are required and other
+// columns are not allowed.
+type CSVDecoder struct {
+	r       *csv.Reader
+	indices []int // column index of each Item field
+}
+
+func NewCSVDecoder(r io.Reader) *CSVDecoder {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	return &CSVDecoder{r: reader}
+}
+
+func (d *CSVDecoder) readHeader() error {
+	header, err := d.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return csvError(err)
+	}
+	// records must have the same number of fields (see csv.Reader.FieldsPerRecord)
+	line, _ := d.r.FieldPos(0)
+	if len(header) != len(csvColumns) {
+		return fmt.Errorf("%w: line %d: want %d columns, got %d", ErrInvalidCSV, line, len(csvColumns), len(header))
+	}
+	d.indices = make([]int, len(csvColumns))
+	for i, column := range csvColumns {
+		d.indices[i] = slices.Index(header, column)
+		if d.indices[i] < 0 {
+			return fmt.Errorf("%w: line %d: missing column %q", ErrInvalidCSV, line, column)
+		}
+	}
+	return nil
+}
+
+func (d *CSVDecoder) Decode() (item Item, err error) {
+	if d.indices == nil {
+		if err = d.readHeader(); err != nil {
+			return
+		}
+	}
+
+	record, err := d.r.Read()
+	if err != nil {
+		err = csvError(err)
+		return
+	}
+
+	var values [5]uint64
+	for i, index := range d.indices {
+		bitSize := 32
+		if i == len(values)-1 {
+			bitSize = 64 // offset
+		}
+		values[i], err = strconv.ParseUint(record[index], 10, bitSize)
+		if err != nil {
+			line, _ := d.r.FieldPos(index)
+			return Item{}, fmt.Errorf("%w: line %d: %w", ErrInvalidCSV, line, err)
+		}
+	}
+
+	return Item{
+		X:      uint32(values[0]),
+		Y:      uint32(values[1]),
+		Z:      uint32(values[2]),
+		Length: uint32(values[3]),
+		Offset: values[4],
+	}, nil
+}
+
+// csvError adds ErrInvalidCSV to parse errors, which name the line.
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%w: line %d: %w", ErrInvalidCSV, parseErr.Line, parseErr.Err)
+	}
+	return err
+}
+
+func (d *CSVDecoder) VisitLocations(fn tile.LocationVisitFunc) error {
+	return visitDecoded(d.Decode, fn)
+}
+
+// JSONEncoder writes items as newline-delimited JSON objects
+// ({"x":0,"y":0,"z":0,"length":0,"offset":0}).
+type JSONEncoder struct {
+	e *json.Encoder
+}
+
+func NewJSONEncoder(w io.Writer) *JSONEncoder {
+	return &JSONEncoder{e: json.NewEncoder(w)}
+}
+
+func (e *JSONEncoder) Encode(item Item) error {
+	return e.e.Encode(item)
+}
+
+func (e *JSONEncoder) EncodeAll(items []Item) error {
+	for _, item := range items {
+		if err := e.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *JSONEncoder) EncodeFrom(src tile.LocationVisitor) error {
+	return src.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		return e.Encode(NewItem(tileID, location))
+	})
+}
+
+// JSONDecoder reads items from newline-delimited JSON objects, one per line.
+// All fields of items are required and other fields are not allowed, empty
+// lines are skipped.
+type JSONDecoder struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func NewJSONDecoder(r io.Reader) *JSONDecoder {
+	return &JSONDecoder{s: bufio.NewScanner(r)}
+}
+
+// jsonItem is Item with required fields.
+type jsonItem struct {
+	X      *uint32 `json:"x"`
+	Y      *uint32 `json:"y"`
+	Z      *uint32 `json:"z"`
+	Length *uint32 `json:"length"`
+	Offset *uint64 `json:"offset"`
+}
+
+func (d *JSONDecoder) Decode() (Item, error) {
+	var line []byte
+	for len(line) == 0 {
+		if !d.s.Scan() {
+			if err := d.s.Err(); err != nil {
+				return Item{}, err
+			}
+			return Item{}, io.EOF
+		}
+		d.line++
+		line = bytes.TrimSpace(d.s.Bytes())
+	}
+
+	item, err := decodeJSONItem(line)
+	if err != nil {
+		return Item{}, fmt.Errorf("%w: line %d: %w", ErrInvalidJSON, d.line, err)
+	}
+	return item, nil
+}
+
+func decodeJSONItem(data []byte) (Item, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var item jsonItem
+	if err := decoder.Decode(&item); err != nil {
+		return Item{}, err
+	}
+	if decoder.More() {
+		return Item{}, errors.New("unexpected data after object")
+	}
+
+	fields := []struct {
+		name  string
+		found bool
+	}{
+		{"x", item.X != nil},
+		{"y", item.Y != nil},
+		{"z", item.Z != nil},
+		{"length", item.Length != nil},
+		{"offset", item.Offset != nil},
+	}
+	for _, field := range fields {
+		if !field.found {
+			return Item{}, fmt.Errorf("missing field %q", field.name)
+		}
+	}
+	return Item{X: *item.X, Y: *item.Y, Z: *item.Z, Length: *item.Length, Offset: *item.Offset}, nil
+}
+
+func (d *JSONDecoder) VisitLocations(fn tile.LocationVisitFunc) error {
+	return visitDecoded(d.Decode, fn)
+}
+
+func visitDecoded(decode func() (Item, error), fn tile.LocationVisitFunc) error {
+	for {
+		item, err := decode()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := fn(item.TileID(), item.TileLocation()); err != nil {
+			return err
+		}
+	}
+}
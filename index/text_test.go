@@ -0,0 +1,97 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/index"
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTextEncodings(t *testing.T) {
+	items := randomItems(100)
+	items[0].Offset = 1 << 40
+
+	for _, tc := range []struct {
+		name       string
+		encode     func(buffer *bytes.Buffer) error
+		newDecoder func(data []byte) tile.LocationVisitor
+	}{
+		{
+			"CSV",
+			func(buffer *bytes.Buffer) error {
+				encoder := index.NewCSVEncoder(buffer)
+				if err := encoder.EncodeAll(items); err != nil {
+					return err
+				}
+				return encoder.Flush()
+			},
+			func(data []byte) tile.LocationVisitor { return index.NewCSVDecoder(bytes.NewReader(data)) },
+		},
+		{
+			"NDJSON",
+			func(buffer *bytes.Buffer) error {
+				return index.NewJSONEncoder(buffer).EncodeAll(items)
+			},
+			func(data []byte) tile.LocationVisitor { return index.NewJSONDecoder(bytes.NewReader(data)) },
+		},
+	} {
+		var buffer bytes.Buffer
+		if err := tc.encode(&buffer); err != nil {
+			t.Fatalf("%s: encode failed: %v", tc.name, err)
+		}
+		got, err := index.Collect(tc.newDecoder(buffer.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: Collect failed: %v", tc.name, err)
+		}
+		if diff := cmp.Diff(items, got); diff != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestTextDecoders(t *testing.T) {
+	want := []index.Item{{X: 1, Y: 2, Z: 3, Length: 4, Offset: 5}}
+
+	for _, tc := range []struct {
+		name    string
+		decoder tile.LocationVisitor
+		want    []index.Item
+		wantErr error
+	}{
+		{"CSVColumnOrder", index.NewCSVDecoder(strings.NewReader("offset,z,y,x,length\n5,3,2,1,4\n")), want, nil},
+		{"CSVMissingColumn", index.NewCSVDecoder(strings.NewReader("x,y,z,length\n1,2,3,4\n")), nil, index.ErrInvalidCSV},
+		{"CSVExtraColumn", index.NewCSVDecoder(strings.NewReader("x,y,z,length,offset,extra\n1,2,3,4,5,a\n")), nil, index.ErrInvalidCSV},
+		{"CSVDuplicateColumn", index.NewCSVDecoder(strings.NewReader("x,y,z,length,x\n1,2,3,4,1\n")), nil, index.ErrInvalidCSV},
+		{"CSVInvalidValue", index.NewCSVDecoder(strings.NewReader("x,y,z,length,offset\n1,2,-3,4,5\n")), nil, index.ErrInvalidCSV},
+		{"CSVShortRecord", index.NewCSVDecoder(strings.NewReader("x,y,z,length,offset\n1,2,3\n")), nil, index.ErrInvalidCSV},
+		{"CSVLongRecord", index.NewCSVDecoder(strings.NewReader("x,y,z,length,offset\n1,2,3,4,5,6\n")), nil, index.ErrInvalidCSV},
+		{"CSVEmpty", index.NewCSVDecoder(strings.NewReader("x,y,z,length,offset\n")), nil, nil},
+		{"NDJSONFieldOrder", index.NewJSONDecoder(strings.NewReader(`{"offset":5,"z":3,"y":2,"x":1,"length":4}` + "\n\n")), want, nil},
+		{"NDJSONExtraField", index.NewJSONDecoder(strings.NewReader(`{"offset":5,"extra":"a","z":3,"y":2,"x":1,"length":4}` + "\n")), nil, index.ErrInvalidJSON},
+		{"NDJSONMissingField", index.NewJSONDecoder(strings.NewReader(`{"offset":5,"z":3,"y":2,"x":1}` + "\n")), nil, index.ErrInvalidJSON},
+		{"NDJSONInvalidValue", index.NewJSONDecoder(strings.NewReader(`{"offset":5,"z":-3,"y":2,"x":1,"length":4}` + "\n")), nil, index.ErrInvalidJSON},
+		{"NDJSONTwoObjects", index.NewJSONDecoder(strings.NewReader(`{"offset":5,"z":3,"y":2,"x":1,"length":4} {}` + "\n")), nil, index.ErrInvalidJSON},
+	} {
+		got, err := index.Collect(tc.decoder)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: error = %v, want = %v", tc.name, err, tc.wantErr)
+		}
+		if diff := cmp.Diff(tc.want, got); err == nil && diff != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.name, diff)
+		}
+	}
+
+	// errors name the line
+	_, err := index.Collect(index.NewJSONDecoder(strings.NewReader(`{"x":1,"y":2,"z":3,"length":4,"offset":5}` + "\n\n{}\n")))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("JSON error = %v, want line 3", err)
+	}
+	_, err = index.Collect(index.NewCSVDecoder(strings.NewReader("x,y,z,length,offset\n1,2,3,4,5\n1,2,3,4,a\n")))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("CSV error = %v, want line 3", err)
+	}
+}
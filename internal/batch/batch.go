@@ -0,0 +1,120 @@
+// Package batch implements batched reads for tile archives: location lookups
+// of many tiles share index fetches, and nearby byte ranges are merged into
+// a few file access calls.
+package batch
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// FileAccessFunc has the same contract as pm.FileAccessFunc and wt.FileAccessFunc.
+type FileAccessFunc = func(offset, length uint64) ([]byte, error)
+
+// QueryFunc resolves the location of the i-th tile, reading index data with indexAccess.
+type QueryFunc = func(i int, indexAccess FileAccessFunc) (tile.Location, error)
+
+const errPending tile.Error = "libtiles: index data is pending"
+
+// ReadRanges reads all locations, merging ranges separated by at most maxGap
+// bytes into a single fileAccess call. Result slices are aligned with
+// locations and may share memory, they must not be modified.
+func ReadRanges(fileAccess FileAccessFunc, locations []tile.Location, maxGap uint64) ([][]byte, error) {
+	order := make([]int, 0, len(locations))
+	for i, location := range locations {
+		if location.Length > 0 {
+			order = append(order, i)
+		}
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(locations[a].Offset, locations[b].Offset)
+	})
+
+	result := make([][]byte, len(locations))
+	for i := range result {
+		result[i] = []byte{}
+	}
+
+	for begin := 0; begin < len(order); {
+		first := locations[order[begin]]
+		rangeEnd := first.Offset + first.Length
+		end := begin + 1
+		for ; end < len(order); end++ {
+			next := locations[order[end]]
+			if next.Offset > rangeEnd+maxGap {
+				break
+			}
+			rangeEnd = max(rangeEnd, next.Offset+next.Length)
+		}
+
+		data, err := fileAccess(first.Offset, rangeEnd-first.Offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range order[begin:end] {
+			offset := locations[i].Offset - first.Offset
+			result[i] = data[offset : offset+locations[i].Length : offset+locations[i].Length]
+		}
+		begin = end
+	}
+
+	return result, nil
+}
+
+// Resolve finds locations of n tiles. Queries are run in rounds: index reads
+// missing after a round are fetched together (see ReadRanges) and pending
+// queries are restarted, so each index block is fetched at most once.
+func Resolve(n int, query QueryFunc, fileAccess FileAccessFunc, maxGap uint64) ([]tile.Location, error) {
+	cache := make(map[tile.Location][]byte)
+	var missing []tile.Location
+
+	indexAccess := func(offset, length uint64) ([]byte, error) {
+		location := tile.Location{Offset: offset, Length: length}
+		if data, found := cache[location]; found {
+			return data, nil
+		}
+		missing = append(missing, location)
+		return nil, errPending
+	}
+
+	result := make([]tile.Location, n)
+	pending := make([]int, n)
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for len(pending) > 0 {
+		missing = missing[:0]
+		nextPending := pending[:0]
+		for _, i := range pending {
+			location, err := query(i, indexAccess)
+			if errors.Is(err, errPending) {
+				nextPending = append(nextPending, i)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			result[i] = location
+		}
+		pending = nextPending
+
+		slices.SortFunc(missing, func(a, b tile.Location) int {
+			return cmp.Or(cmp.Compare(a.Offset, b.Offset), cmp.Compare(a.Length, b.Length))
+		})
+		missing = slices.Compact(missing)
+
+		data, err := ReadRanges(fileAccess, missing, maxGap)
+		if err != nil {
+			return nil, err
+		}
+		for i, location := range missing {
+			cache[location] = data[i]
+		}
+	}
+
+	return result, nil
+}
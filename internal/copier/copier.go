@@ -155,3 +155,48 @@ func read(ctx context.Context, requests []readRequest, src io.ReaderAt, dstBuf [
 
 	return group.Wait()
 }
+
+// Queue collects locations and copies them in batches of the buffer size, so
+// that the list of all locations is not kept in memory.
+type Queue struct {
+	ctx       context.Context
+	copier    *Copier
+	dst       io.Writer
+	src       io.ReaderAt
+	locations []tile.Location
+	length    uint64
+}
+
+func (c *Copier) NewQueue(ctx context.Context, dst io.Writer, src io.ReaderAt) *Queue {
+	return &Queue{ctx: ctx, copier: c, dst: dst, src: src}
+}
+
+// Add appends the location, locations are written to dst in order of Add.
+func (q *Queue) Add(location tile.Location) error {
+	bufferLen := uint64(len(q.copier.readBuffer))
+	if q.length+location.Length > bufferLen {
+		if err := q.Flush(); err != nil {
+			return err
+		}
+	}
+	if location.Length > bufferLen {
+		reader := io.NewSectionReader(q.src, int64(location.Offset), int64(location.Length))
+		if _, err := io.Copy(q.dst, reader); err != nil {
+			return fmt.Errorf("copy failed: %w", err)
+		}
+		return nil
+	}
+	q.locations = append(q.locations, location)
+	q.length += location.Length
+	return nil
+}
+
+// Flush copies all queued locations.
+func (q *Queue) Flush() error {
+	if err := q.copier.Copy(q.ctx, q.dst, q.src, q.locations); err != nil {
+		return err
+	}
+	q.locations = q.locations[:0]
+	q.length = 0
+	return nil
+}
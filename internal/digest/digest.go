@@ -0,0 +1,197 @@
+// Package digest implements a table of tile data digests for deduplication,
+// with bounded memory: entries are kept in memory up to a limit, then sorted
+// and spilled to temporary files. Each spilled run keeps a Bloom filter and
+// first keys of its blocks in memory, so that a lookup of an absent key
+// rarely reads files, and a lookup of a present key reads one block.
+package digest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/maphash"
+	"os"
+	"slices"
+	"sort"
+)
+
+// TempPattern is the name pattern of temporary files (see os.CreateTemp).
+const TempPattern = "libtiles-digest-*"
+
+const (
+	memoryEntrySize = 64  // approximate size of an in-memory entry, with map overhead
+	recordSize      = 24  // key and value of a spilled entry
+	blockRecords    = 128 // records of a block read by lookups
+	filterBits      = 10  // bits of Bloom filters per entry, about 1% false positives
+	filterHashes    = 7
+)
+
+// Key is a digest of tile data, or another identifier of it.
+type Key [16]byte
+
+// Table maps keys to values, e.g. digests of tile data to its locations.
+// Spilled entries take less than 2 bytes of memory each.
+//
+// Close must be called to remove temporary files.
+type Table struct {
+	maxEntries int
+	tempDir    string
+	seed       maphash.Seed
+
+	entries map[Key]uint64
+	runs    []*run
+	block   []byte
+}
+
+// run is a file of entries sorted by key.
+type run struct {
+	file   *os.File
+	count  int
+	fences []Key // first keys of blocks
+	filter []uint64
+}
+
+// New creates a Table which keeps up to memoryLimit bytes of entries in memory
+// (at least one entry), temporary files are created in tempDir (os.TempDir if
+// empty).
+func New(memoryLimit uint64, tempDir string) *Table {
+	return &Table{
+		maxEntries: int(max(memoryLimit/memoryEntrySize, 1)),
+		tempDir:    tempDir,
+		seed:       maphash.MakeSeed(),
+		entries:    make(map[Key]uint64),
+	}
+}
+
+// Get returns the value of the key, or false if it wasn't added.
+func (t *Table) Get(key Key) (uint64, bool, error) {
+	if value, found := t.entries[key]; found {
+		return value, true, nil
+	}
+	if len(t.runs) == 0 {
+		return 0, false, nil
+	}
+	hash := maphash.Bytes(t.seed, key[:])
+	for _, r := range t.runs {
+		if !r.mayContain(hash) {
+			continue
+		}
+		value, found, err := t.lookup(r, key)
+		if err != nil || found {
+			return value, found, err
+		}
+	}
+	return 0, false, nil
+}
+
+// Add adds the key, which must be absent (see Get).
+func (t *Table) Add(key Key, value uint64) error {
+	if len(t.entries) >= t.maxEntries {
+		if err := t.spill(); err != nil {
+			return err
+		}
+	}
+	t.entries[key] = value
+	return nil
+}
+
+func (t *Table) spill() error {
+	keys := make([]Key, 0, len(t.entries))
+	for key := range t.entries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b Key) int { return bytes.Compare(a[:], b[:]) })
+
+	file, err := os.CreateTemp(t.tempDir, TempPattern)
+	if err != nil {
+		return err
+	}
+	r := &run{
+		file:   file,
+		count:  len(keys),
+		fences: make([]Key, 0, (len(keys)+blockRecords-1)/blockRecords),
+		filter: make([]uint64, (len(keys)*filterBits+63)/64),
+	}
+	t.runs = append(t.runs, r)
+
+	writer := bufio.NewWriter(file)
+	record := make([]byte, recordSize)
+	for i, key := range keys {
+		if i%blockRecords == 0 {
+			r.fences = append(r.fences, key)
+		}
+		r.add(maphash.Bytes(t.seed, key[:]))
+		copy(record, key[:])
+		binary.LittleEndian.PutUint64(record[16:], t.entries[key])
+		if _, err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	clear(t.entries)
+	return nil
+}
+
+// lookup finds the key in the only block of the run which may contain it.
+func (t *Table) lookup(r *run, key Key) (uint64, bool, error) {
+	i := sort.Search(len(r.fences), func(i int) bool { return bytes.Compare(r.fences[i][:], key[:]) > 0 }) - 1
+	if i < 0 {
+		return 0, false, nil
+	}
+	records := min(blockRecords, r.count-i*blockRecords)
+	if cap(t.block) < blockRecords*recordSize {
+		t.block = make([]byte, blockRecords*recordSize)
+	}
+	block := t.block[:records*recordSize]
+	if _, err := r.file.ReadAt(block, int64(i*blockRecords*recordSize)); err != nil {
+		return 0, false, err
+	}
+
+	j, found := sort.Find(records, func(j int) int { return bytes.Compare(key[:], block[j*recordSize:][:16]) })
+	if !found {
+		return 0, false, nil
+	}
+	return binary.LittleEndian.Uint64(block[j*recordSize+16:]), true, nil
+}
+
+// bits calls fn for positions of filter bits of the hash (double hashing),
+// until fn returns false.
+func (r *run) bits(hash uint64, fn func(bit uint64) bool) bool {
+	size := uint64(len(r.filter)) * 64
+	h1, h2 := hash&0xffffffff, hash>>32|1
+	for i := range uint64(filterHashes) {
+		if !fn((h1 + i*h2) % size) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *run) add(hash uint64) {
+	r.bits(hash, func(bit uint64) bool {
+		r.filter[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (r *run) mayContain(hash uint64) bool {
+	return r.bits(hash, func(bit uint64) bool { return r.filter[bit/64]&(1<<(bit%64)) != 0 })
+}
+
+// Close removes temporary files.
+func (t *Table) Close() error {
+	var result error
+	for _, r := range t.runs {
+		if err := r.file.Close(); err != nil && result == nil {
+			result = err
+		}
+		if err := os.Remove(r.file.Name()); err != nil && result == nil {
+			result = err
+		}
+	}
+	t.runs = nil
+	t.entries = nil
+	return result
+}
@@ -0,0 +1,44 @@
+package digest_test
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/internal/digest"
+)
+
+func TestTable(t *testing.T) {
+	md5Key := func(i uint64) digest.Key { return md5.Sum(binary.LittleEndian.AppendUint64(nil, i)) }
+	counterKey := func(i uint64) digest.Key {
+		var key digest.Key
+		binary.BigEndian.PutUint64(key[:], i)
+		return key
+	}
+
+	for _, memoryLimit := range []uint64{1, 64 * 100, 1 << 20} {
+		for name, key := range map[string]func(uint64) digest.Key{"md5": md5Key, "counter": counterKey} {
+			table := digest.New(memoryLimit, t.TempDir())
+			defer table.Close()
+
+			// even keys are added, odd ones are absent
+			for i := uint64(0); i < 2000; i += 2 {
+				if _, found, err := table.Get(key(i)); err != nil || found {
+					t.Fatalf("%s(memoryLimit=%v): Get(%v) before Add = %v, %v", name, memoryLimit, i, found, err)
+				}
+				if err := table.Add(key(i), i*10); err != nil {
+					t.Fatalf("%s(memoryLimit=%v): Add failed: %v", name, memoryLimit, err)
+				}
+			}
+			for i := range uint64(2000) {
+				value, found, err := table.Get(key(i))
+				if err != nil {
+					t.Fatalf("%s(memoryLimit=%v): Get failed: %v", name, memoryLimit, err)
+				}
+				if wantFound := i%2 == 0; found != wantFound || (found && value != i*10) {
+					t.Errorf("%s(memoryLimit=%v): Get(%v) = %v, %v, want = %v, %v", name, memoryLimit, i, value, found, i*10, wantFound)
+				}
+			}
+		}
+	}
+}
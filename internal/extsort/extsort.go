@@ -0,0 +1,269 @@
+// Package extsort implements external sorting: items are sorted in runs
+// limited by memory, spilled to temporary files, and merged when visited.
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"io"
+	"os"
+	"slices"
+)
+
+// TempPattern is the name pattern of temporary files (see os.CreateTemp).
+const TempPattern = "libtiles-sort-*"
+
+// Codec encodes items of temporary files, each item takes Size bytes.
+type Codec[T any] struct {
+	Size   int
+	Encode func(buffer []byte, item T)
+	Decode func(buffer []byte) T
+}
+
+// Sorter sorts any number of items with bounded memory. Items equal by the
+// compare function are visited in order of addition.
+//
+// Close must be called to remove temporary files.
+type Sorter[T any] struct {
+	compare  func(a, b T) int
+	codec    Codec[T]
+	maxItems int
+	tempDir  string
+
+	items []T
+	runs  []*os.File
+}
+
+// New creates a Sorter which keeps up to memoryLimit bytes of items in memory
+// (at least one item), temporary files are created in tempDir (os.TempDir if
+// empty).
+func New[T any](compare func(a, b T) int, codec Codec[T], memoryLimit uint64, tempDir string) *Sorter[T] {
+	return &Sorter[T]{
+		compare:  compare,
+		codec:    codec,
+		maxItems: int(max(memoryLimit/uint64(codec.Size), 1)),
+		tempDir:  tempDir,
+	}
+}
+
+func (s *Sorter[T]) Add(item T) error {
+	if len(s.items) >= s.maxItems {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	s.items = append(s.items, item)
+	return nil
+}
+
+// AddRun adds items already sorted by the compare function, they are written
+// to a temporary file directly. visit must call add for each item.
+func (s *Sorter[T]) AddRun(visit func(add func(item T) error) error) error {
+	if len(s.items) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	return s.writeRun(visit)
+}
+
+func (s *Sorter[T]) spill() error {
+	slices.SortStableFunc(s.items, s.compare)
+	err := s.writeRun(func(add func(item T) error) error {
+		for _, item := range s.items {
+			if err := add(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.items = s.items[:0]
+	return err
+}
+
+func (s *Sorter[T]) writeRun(visit func(add func(item T) error) error) error {
+	file, err := os.CreateTemp(s.tempDir, TempPattern)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, file)
+
+	writer := bufio.NewWriter(file)
+	buffer := make([]byte, s.codec.Size)
+	err = visit(func(item T) error {
+		s.codec.Encode(buffer, item)
+		_, err := writer.Write(buffer)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// Visit calls fn for all added items in sorted order. Items can't be added
+// after Visit.
+func (s *Sorter[T]) Visit(fn func(item T) error) error {
+	slices.SortStableFunc(s.items, s.compare)
+	if len(s.runs) == 0 {
+		for _, item := range s.items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// runs are merged in order of creation, the in-memory buffer is the last run
+	sources := make([]Source[T], 0, len(s.runs)+1)
+	for _, file := range s.runs {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		sources = append(sources, s.fileSource(bufio.NewReader(file)))
+	}
+	sources = append(sources, SliceSource(s.items))
+	return Merge(s.compare, sources, fn)
+}
+
+// VisitLast is like Visit, but of several equal items only the last added one
+// is visited.
+func (s *Sorter[T]) VisitLast(fn func(item T) error) error {
+	var last T
+	found := false
+	err := s.Visit(func(item T) error {
+		if found && s.compare(last, item) != 0 {
+			if err := fn(last); err != nil {
+				return err
+			}
+		}
+		last, found = item, true
+		return nil
+	})
+	if err != nil || !found {
+		return err
+	}
+	return fn(last)
+}
+
+func (s *Sorter[T]) fileSource(reader io.Reader) Source[T] {
+	buffer := make([]byte, s.codec.Size)
+	return func() (T, bool, error) {
+		var item T
+		if _, err := io.ReadFull(reader, buffer); err != nil {
+			if errors.Is(err, io.EOF) {
+				return item, false, nil
+			}
+			return item, false, err
+		}
+		return s.codec.Decode(buffer), true, nil
+	}
+}
+
+// Close removes temporary files.
+func (s *Sorter[T]) Close() error {
+	var result error
+	for _, file := range s.runs {
+		if err := file.Close(); err != nil && result == nil {
+			result = err
+		}
+		if err := os.Remove(file.Name()); err != nil && result == nil {
+			result = err
+		}
+	}
+	s.runs = nil
+	s.items = nil
+	return result
+}
+
+// Source returns the next item of a sorted sequence, or false at its end.
+type Source[T any] func() (T, bool, error)
+
+// SliceSource returns a source of items of a sorted slice.
+func SliceSource[T any](items []T) Source[T] {
+	return func() (T, bool, error) {
+		var item T
+		if len(items) == 0 {
+			return item, false, nil
+		}
+		item, items = items[0], items[1:]
+		return item, true, nil
+	}
+}
+
+// Merge calls fn for items of all sources in sorted order, each source must be
+// sorted by the compare function. Equal items are visited in order of sources.
+func Merge[T any](compare func(a, b T) int, sources []Source[T], fn func(item T) error) error {
+	m := merger[T]{compare: compare}
+	for i, next := range sources {
+		if err := m.push(&mergeSource[T]{next: next, index: i}); err != nil {
+			return err
+		}
+	}
+
+	for m.Len() > 0 {
+		if err := fn(m.sources[0].item); err != nil {
+			return err
+		}
+		if err := m.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mergeSource[T any] struct {
+	next  Source[T]
+	index int
+	item  T
+}
+
+// merger is a min-heap of sources by (item, source index).
+type merger[T any] struct {
+	compare func(a, b T) int
+	sources []*mergeSource[T]
+}
+
+func (m *merger[T]) Len() int { return len(m.sources) }
+
+func (m *merger[T]) Less(i, j int) bool {
+	a, b := m.sources[i], m.sources[j]
+	c := m.compare(a.item, b.item)
+	return c < 0 || (c == 0 && a.index < b.index)
+}
+
+func (m *merger[T]) Swap(i, j int) { m.sources[i], m.sources[j] = m.sources[j], m.sources[i] }
+
+func (m *merger[T]) Push(x any) { m.sources = append(m.sources, x.(*mergeSource[T])) }
+
+func (m *merger[T]) Pop() any {
+	last := m.sources[len(m.sources)-1]
+	m.sources = m.sources[:len(m.sources)-1]
+	return last
+}
+
+func (m *merger[T]) push(source *mergeSource[T]) error {
+	item, ok, err := source.next()
+	if ok {
+		source.item = item
+		heap.Push(m, source)
+	}
+	return err
+}
+
+// advance moves the smallest source to its next item.
+func (m *merger[T]) advance() error {
+	source := m.sources[0]
+	item, ok, err := source.next()
+	if err != nil {
+		return err
+	}
+	if ok {
+		source.item = item
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return nil
+}
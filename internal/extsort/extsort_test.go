@@ -0,0 +1,72 @@
+package extsort_test
+
+import (
+	"cmp"
+	"encoding/binary"
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/internal/extsort"
+	gcmp "github.com/google/go-cmp/cmp"
+)
+
+type pair struct {
+	Key   uint32
+	Value uint32
+}
+
+var pairCodec = extsort.Codec[pair]{
+	Size: 8,
+	Encode: func(buffer []byte, p pair) {
+		binary.LittleEndian.PutUint32(buffer[0:], p.Key)
+		binary.LittleEndian.PutUint32(buffer[4:], p.Value)
+	},
+	Decode: func(buffer []byte) pair {
+		return pair{Key: binary.LittleEndian.Uint32(buffer[0:]), Value: binary.LittleEndian.Uint32(buffer[4:])}
+	},
+}
+
+func comparePairs(a, b pair) int {
+	return cmp.Compare(a.Key, b.Key)
+}
+
+func TestSorter(t *testing.T) {
+	// values are the order of addition, keys repeat across runs
+	var input []pair
+	for i := range uint32(100) {
+		input = append(input, pair{Key: (i * 37) % 10, Value: i})
+	}
+
+	for _, memoryLimit := range []uint64{1, 8 * 7, 1 << 20} {
+		sorter := extsort.New(comparePairs, pairCodec, memoryLimit, t.TempDir())
+		defer sorter.Close()
+		for _, p := range input {
+			if err := sorter.Add(p); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+
+		var all, last []pair
+		if err := sorter.Visit(func(p pair) error { all = append(all, p); return nil }); err != nil {
+			t.Fatalf("Visit failed: %v", err)
+		}
+		if err := sorter.VisitLast(func(p pair) error { last = append(last, p); return nil }); err != nil {
+			t.Fatalf("VisitLast failed: %v", err)
+		}
+
+		var wantAll, wantLast []pair
+		for key := range uint32(10) {
+			for _, p := range input {
+				if p.Key == key {
+					wantAll = append(wantAll, p)
+				}
+			}
+			wantLast = append(wantLast, wantAll[len(wantAll)-1])
+		}
+		if diff := gcmp.Diff(wantAll, all); diff != "" {
+			t.Errorf("Visit(memoryLimit=%v) mismatch (-want+got):\n%v", memoryLimit, diff)
+		}
+		if diff := gcmp.Diff(wantLast, last); diff != "" {
+			t.Errorf("VisitLast(memoryLimit=%v) mismatch (-want+got):\n%v", memoryLimit, diff)
+		}
+	}
+}
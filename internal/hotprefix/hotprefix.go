@@ -0,0 +1,112 @@
+// Package hotprefix implements the hot-tile prefix: the most requested tiles
+// placed at the start of the data section, so that clients can prefetch them
+// with a single range request. Length of the prefix is stored in JSON metadata.
+package hotprefix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"unicode"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// MetadataKey is the JSON metadata key storing the length (in bytes) of the
+// hot prefix, which always starts at the beginning of the data section.
+const MetadataKey = "hot_prefix_length"
+
+// Length returns the largest prefix of locations (in data section order)
+// with total length not exceeding maxLength.
+func Length(locations []tile.Location, maxLength uint64) uint64 {
+	length := uint64(0)
+	for _, location := range locations {
+		if length+location.Length > maxLength {
+			break
+		}
+		length += location.Length
+	}
+	return length
+}
+
+// SetMetadata returns a copy of JSON metadata with the prefix length set.
+// The length is inserted into the object as is, without reordering other
+// keys. Metadata which isn't a JSON object is returned unchanged, as the
+// length can't be recorded there.
+func SetMetadata(metadata []byte, length uint64) []byte {
+	value := strconv.AppendUint(nil, length, 10)
+	trimmed := bytes.TrimSpace(metadata)
+	if len(trimmed) == 0 {
+		return fmt.Appendf(nil, "{%q:%s}", MetadataKey, value)
+	}
+	if trimmed[0] != '{' || !json.Valid(trimmed) {
+		return metadata
+	}
+
+	if start, end, found := findValue(trimmed, MetadataKey); found {
+		return slices.Concat(trimmed[:start], value, trimmed[end:])
+	}
+	body := bytes.TrimSpace(trimmed[1 : len(trimmed)-1])
+	separator := ","
+	if len(body) == 0 {
+		separator = ""
+	}
+	head := bytes.TrimRightFunc(trimmed[:len(trimmed)-1], unicode.IsSpace)
+	return slices.Concat(head, []byte(separator), fmt.Appendf(nil, "%q:%s}", MetadataKey, value))
+}
+
+// findValue returns the range of the value of the key in a valid JSON object.
+func findValue(object []byte, key string) (int, int, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	if _, err := decoder.Token(); err != nil { // '{'
+		return 0, 0, false
+	}
+	for decoder.More() {
+		name, err := decoder.Token()
+		if err != nil {
+			return 0, 0, false
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return 0, 0, false
+		}
+		if name == key {
+			end := int(decoder.InputOffset())
+			return end - len(value), end, true
+		}
+	}
+	return 0, 0, false
+}
+
+// ParseMetadata returns the prefix length from JSON metadata, or zero if it is absent.
+func ParseMetadata(metadata []byte) uint64 {
+	var values struct {
+		Length uint64 `json:"hot_prefix_length"`
+	}
+	if err := json.Unmarshal(metadata, &values); err != nil {
+		return 0
+	}
+	return values.Length
+}
+
+// Pin wraps fileAccess to serve reads inside the pinned region from memory.
+// The region is read once, slices returned for it share the same memory
+// and must not be modified.
+func Pin(fileAccess func(offset, length uint64) ([]byte, error), region tile.Location) (func(offset, length uint64) ([]byte, error), error) {
+	if region.Length == 0 {
+		return fileAccess, nil
+	}
+	regionData, err := fileAccess(region.Offset, region.Length)
+	if err != nil {
+		return nil, err
+	}
+	return func(offset, length uint64) ([]byte, error) {
+		if offset >= region.Offset && offset+length <= region.Offset+region.Length {
+			begin := offset - region.Offset
+			return regionData[begin : begin+length : begin+length], nil
+		}
+		return fileAccess(offset, length)
+	}, nil
+}
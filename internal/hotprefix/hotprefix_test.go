@@ -0,0 +1,31 @@
+package hotprefix_test
+
+import (
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/internal/hotprefix"
+)
+
+func TestSetMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		metadata string
+		want     string
+	}{
+		{``, `{"hot_prefix_length":42}`},
+		{`{}`, `{"hot_prefix_length":42}`},
+		{`{ }`, `{"hot_prefix_length":42}`},
+		{`{"z":1,"a":{"b":[2]}}`, `{"z":1,"a":{"b":[2]},"hot_prefix_length":42}`},
+		{"{\"z\": 1\n}\n", `{"z": 1,"hot_prefix_length":42}`},
+		{`{"z":1,"hot_prefix_length": 7,"a":2}`, `{"z":1,"hot_prefix_length": 42,"a":2}`},
+		{`[1,2]`, `[1,2]`}, // not an object, left unchanged
+		{`{"z":`, `{"z":`},
+	} {
+		got := hotprefix.SetMetadata([]byte(tc.metadata), 42)
+		if string(got) != tc.want {
+			t.Errorf("SetMetadata(%q) = %q, want = %q", tc.metadata, got, tc.want)
+		}
+		if tc.want != tc.metadata && hotprefix.ParseMetadata(got) != 42 {
+			t.Errorf("ParseMetadata(%q) = %v, want = 42", got, hotprefix.ParseMetadata(got))
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package mmap provides read-only memory-mapped access to local files.
+//
+// Slices returned by Mapping.Access point directly into the mapping: they
+// must not be modified (pages are mapped read-only, writes crash the process)
+// and must not be used after Mapping.Close.
+package mmap
+
+import "github.com/eak1mov/go-libtiles/tile"
+
+const ErrOutOfRange tile.Error = "libtiles: read out of mapped range"
+
+// Mapping is a read-only memory mapping of a whole file.
+type Mapping struct {
+	data []byte
+}
+
+// Access returns the slice [offset, offset+length) of the mapping without copying.
+func (m *Mapping) Access(offset, length uint64) ([]byte, error) {
+	size := uint64(len(m.data))
+	if offset > size || length > size-offset {
+		return nil, ErrOutOfRange
+	}
+	return m.data[offset : offset+length : offset+length], nil
+}
+
+// Len returns the length of the mapped file.
+func (m *Mapping) Len() uint64 {
+	return uint64(len(m.data))
+}
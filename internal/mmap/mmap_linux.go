@@ -0,0 +1,45 @@
+package mmap
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Open maps the whole file into memory. The file descriptor is not needed
+// after mapping and is closed before Open returns.
+func Open(filePath string) (*Mapping, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fileInfo.Size()
+	if size == 0 {
+		return &Mapping{}, nil // zero-length mappings are not allowed
+	}
+	if size != int64(int(size)) {
+		return nil, fmt.Errorf("file is too large to map: %v bytes", size)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: filePath, Err: err}
+	}
+	return &Mapping{data: data}, nil
+}
+
+// Close unmaps the file. Slices returned by Access become invalid.
+func (m *Mapping) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package mmap
+
+import "errors"
+
+// Open is only supported on Linux.
+func Open(filePath string) (*Mapping, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Close releases the mapping.
+func (m *Mapping) Close() error {
+	m.data = nil
+	return nil
+}
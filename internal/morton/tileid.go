@@ -0,0 +1,62 @@
+// Package morton encodes tile coordinates as Morton codes (Z-order curve).
+package morton
+
+import "github.com/eak1mov/go-libtiles/tile"
+
+func interleave(x uint32) uint32 {
+	x = (x | (x << 8)) & 0x00FF00FF
+	x = (x | (x << 4)) & 0x0F0F0F0F
+	x = (x | (x << 2)) & 0x33333333
+	x = (x | (x << 1)) & 0x55555555
+	return x
+}
+
+func deinterleave(x uint32) uint32 {
+	x = x & 0x55555555
+	x = (x | (x >> 1)) & 0x33333333
+	x = (x | (x >> 2)) & 0x0F0F0F0F
+	x = (x | (x >> 4)) & 0x00FF00FF
+	x = (x | (x >> 8)) & 0x0000FFFF
+	return x
+}
+
+func Encode(tileID tile.ID) uint32 {
+	return interleave(tileID.X) | (interleave(tileID.Y) << 1)
+}
+
+func Decode(tileCode, z uint32) tile.ID {
+	return tile.ID{
+		X: deinterleave(tileCode),
+		Y: deinterleave(tileCode >> 1),
+		Z: z,
+	}
+}
+
+func interleave64(x uint32) uint64 {
+	v := uint64(x)
+	v = (v | (v << 16)) & 0x0000FFFF0000FFFF
+	v = (v | (v << 8)) & 0x00FF00FF00FF00FF
+	v = (v | (v << 4)) & 0x0F0F0F0F0F0F0F0F
+	v = (v | (v << 2)) & 0x3333333333333333
+	v = (v | (v << 1)) & 0x5555555555555555
+	return v
+}
+
+func deinterleave64(v uint64) uint32 {
+	v = v & 0x5555555555555555
+	v = (v | (v >> 1)) & 0x3333333333333333
+	v = (v | (v >> 2)) & 0x0F0F0F0F0F0F0F0F
+	v = (v | (v >> 4)) & 0x00FF00FF00FF00FF
+	v = (v | (v >> 8)) & 0x0000FFFF0000FFFF
+	v = (v | (v >> 16)) & 0x00000000FFFFFFFF
+	return uint32(v)
+}
+
+// Encode64 is like Encode, but supports full 32-bit coordinates.
+func Encode64(x, y uint32) uint64 {
+	return interleave64(x) | (interleave64(y) << 1)
+}
+
+func Decode64(code uint64) (x, y uint32) {
+	return deinterleave64(code), deinterleave64(code >> 1)
+}
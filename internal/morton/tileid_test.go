@@ -3,8 +3,8 @@ package morton_test
 import (
 	"testing"
 
+	"github.com/eak1mov/go-libtiles/internal/morton"
 	"github.com/eak1mov/go-libtiles/tile"
-	"github.com/eak1mov/go-libtiles/wt/index/internal/morton"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -26,3 +26,22 @@ func TestEncodeDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeDecode64(t *testing.T) {
+	for _, tc := range [][2]uint32{{0, 0}, {1, 0}, {0, 1}, {12345, 54321}, {1<<24 - 1, 1<<24 - 1}, {1<<32 - 1, 1<<32 - 1}} {
+		x, y := morton.Decode64(morton.Encode64(tc[0], tc[1]))
+		if x != tc[0] || y != tc[1] {
+			t.Errorf("Decode64(Encode64(%v, %v)) = %v, %v", tc[0], tc[1], x, y)
+		}
+	}
+	for z := range 8 {
+		for x := range uint32(1 << z) {
+			for y := range uint32(1 << z) {
+				tileID := tile.ID{X: x, Y: y, Z: uint32(z)}
+				if got, want := morton.Encode64(x, y), uint64(morton.Encode(tileID)); got != want {
+					t.Fatalf("Encode64(%v, %v) = %v, want = %v", x, y, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package mb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// Grid is a UTFGrid of a tile (interactivity data of MBTiles 1.3).
+type Grid struct {
+	Grid []byte            // compressed UTFGrid JSON without "data", as stored in the grids table
+	Data map[string]string // JSON value of each key of the grid, as stored in the grid_data table
+}
+
+// UTFGrid returns the complete UTFGrid JSON: the decompressed grid with its
+// "data" object.
+func (g Grid) UTFGrid() ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	if bytes.HasPrefix(g.Grid, []byte{0x1f, 0x8b}) {
+		r, err = gzip.NewReader(bytes.NewReader(g.Grid))
+	} else {
+		r, err = zlib.NewReader(bytes.NewReader(g.Grid))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var utfGrid map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&utfGrid); err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]json.RawMessage, len(g.Data))
+	for key, value := range g.Data {
+		data[key] = json.RawMessage(value)
+	}
+	if utfGrid["data"], err = json.Marshal(data); err != nil {
+		return nil, err
+	}
+	return json.Marshal(utfGrid)
+}
+
+// ParseUTFGrid splits the complete UTFGrid JSON into the zlib-compressed grid
+// and its data (see Grid.UTFGrid).
+func ParseUTFGrid(utfGridData []byte) (Grid, error) {
+	var utfGrid map[string]json.RawMessage
+	if err := json.Unmarshal(utfGridData, &utfGrid); err != nil {
+		return Grid{}, err
+	}
+
+	var data map[string]json.RawMessage
+	if dataValue, found := utfGrid["data"]; found {
+		if err := json.Unmarshal(dataValue, &data); err != nil {
+			return Grid{}, err
+		}
+		delete(utfGrid, "data")
+	}
+
+	gridJSON, err := json.Marshal(utfGrid)
+	if err != nil {
+		return Grid{}, err
+	}
+	var buffer bytes.Buffer
+	w := zlib.NewWriter(&buffer)
+	if _, err := w.Write(gridJSON); err != nil {
+		return Grid{}, err
+	}
+	if err := w.Close(); err != nil {
+		return Grid{}, err
+	}
+
+	grid := Grid{Grid: buffer.Bytes(), Data: make(map[string]string, len(data))}
+	for key, value := range data {
+		grid.Data[key] = string(value)
+	}
+	return grid, nil
+}
+
+// HasGrids reports whether the file has UTFGrid tables (grids and grid_data).
+func (r *Reader) HasGrids() bool {
+	return r.hasGrids
+}
+
+// ReadGrid reads the UTFGrid of a single tile. It returns nil if the tile
+// doesn't have a grid.
+func (r *Reader) ReadGrid(tileID tile.ID) (*Grid, error) {
+	if !r.hasGrids {
+		return nil, nil
+	}
+
+	x, y, z := tileID.X, tileID.Y, tileID.Z
+	y = (1 << z) - 1 - y // XYZ -> TMS
+
+	grid := Grid{Data: make(map[string]string)}
+	err := r.db.QueryRow("SELECT grid FROM grids WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", z, x, y).Scan(&grid.Grid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := r.db.Query("SELECT key_name, key_json FROM grid_data WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		grid.Data[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &grid, nil
+}
+
+// GridVisitFunc is a callback function for Reader.VisitGrids.
+type GridVisitFunc func(tileID tile.ID, grid Grid) error
+
+// VisitGrids visits UTFGrids of all tiles, ordered by zoom, column and TMS row.
+func (r *Reader) VisitGrids(fn GridVisitFunc) error {
+	if !r.hasGrids {
+		return nil
+	}
+
+	rows, err := r.db.Query(`
+		SELECT zoom_level, tile_column, tile_row, grid, key_name, key_json
+		FROM grids LEFT JOIN grid_data USING (zoom_level, tile_column, tile_row)
+		ORDER BY zoom_level, tile_column, tile_row
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var tileID tile.ID
+	var grid *Grid
+	for rows.Next() {
+		var x, y, z uint32
+		var gridData []byte
+		var key, value sql.NullString
+
+		if err := rows.Scan(&z, &x, &y, &gridData, &key, &value); err != nil {
+			return err
+		}
+
+		y = (1 << z) - 1 - y // TMS -> XYZ
+
+		// rows of a tile are adjacent, one row for each key of the grid
+		if grid == nil || tileID != (tile.ID{X: x, Y: y, Z: z}) {
+			if grid != nil {
+				if err := fn(tileID, *grid); err != nil {
+					return err
+				}
+			}
+			tileID = tile.ID{X: x, Y: y, Z: z}
+			grid = &Grid{Grid: gridData, Data: make(map[string]string)}
+		}
+		if key.Valid {
+			grid.Data[key.String] = value.String
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if grid != nil {
+		return fn(tileID, *grid)
+	}
+	return nil
+}
+
+// gridWriter writes UTFGrids to the grids and grid_data tables, which are
+// created with the first grid.
+type gridWriter struct {
+	db       *sql.DB
+	batch    *txBatch
+	gridStmt *sql.Stmt // nil until the first grid is written
+	dataStmt *sql.Stmt
+}
+
+// WriteGrid writes the UTFGrid of a single tile.
+func (w *gridWriter) WriteGrid(tileID tile.ID, grid Grid) error {
+	if w.gridStmt == nil {
+		if err := w.createTables(); err != nil {
+			return err
+		}
+	}
+
+	x, y, z := tileID.X, tileID.Y, tileID.Z
+	y = (1 << z) - 1 - y // XYZ -> TMS
+
+	gridStmt, err := w.batch.stmt(w.db, w.gridStmt)
+	if err != nil {
+		return err
+	}
+	if _, err := gridStmt.Exec(z, x, y, grid.Grid); err != nil {
+		return err
+	}
+
+	dataLength := len(grid.Grid)
+	if len(grid.Data) > 0 {
+		dataStmt, err := w.batch.stmt(w.db, w.dataStmt)
+		if err != nil {
+			return err
+		}
+		for key, value := range grid.Data {
+			if _, err := dataStmt.Exec(z, x, y, key, value); err != nil {
+				return err
+			}
+			dataLength += len(value)
+		}
+	}
+
+	return w.batch.add(dataLength)
+}
+
+func (w *gridWriter) createTables() error {
+	// the only connection is held by the current transaction
+	if err := w.batch.commit(); err != nil {
+		return err
+	}
+
+	_, err := w.db.Exec(`
+		CREATE TABLE grids (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, grid BLOB);
+		CREATE TABLE grid_data (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, key_name TEXT, key_json TEXT);
+	`)
+	if err != nil {
+		return err
+	}
+
+	gridStmt, err := w.db.Prepare("INSERT INTO grids (zoom_level, tile_column, tile_row, grid) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	dataStmt, err := w.db.Prepare("INSERT INTO grid_data (zoom_level, tile_column, tile_row, key_name, key_json) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		gridStmt.Close()
+		return err
+	}
+	w.gridStmt, w.dataStmt = gridStmt, dataStmt
+	return nil
+}
+
+// finalize creates indexes of grid tables, the current transaction must be
+// committed before.
+func (w *gridWriter) finalize() error {
+	if w.gridStmt == nil {
+		return nil
+	}
+	_, err := w.db.Exec(`
+		CREATE UNIQUE INDEX grid_index ON grids (zoom_level, tile_column, tile_row);
+		CREATE UNIQUE INDEX grid_data_index ON grid_data (zoom_level, tile_column, tile_row, key_name);
+	`)
+	return err
+}
+
+func (w *gridWriter) close() error {
+	if w.gridStmt == nil {
+		return nil
+	}
+	return errors.Join(w.gridStmt.Close(), w.dataStmt.Close())
+}
@@ -0,0 +1,372 @@
+package mb_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/mb"
+	"github.com/eak1mov/go-libtiles/pm/spec"
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/google/go-cmp/cmp"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// writeTestFile writes all tiles of zoom levels 0-maxZoom, data of tiles is
+// shared by every 3 tiles of a row.
+func writeTestFile(t *testing.T, maxZoom uint32, opts ...mb.WriterOption) (string, map[tile.ID][]byte) {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "tiles.mbtiles")
+	writer, err := mb.NewWriter(filePath, opts...)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	defer writer.Close()
+
+	testTiles := make(map[tile.ID][]byte)
+	for z := range maxZoom + 1 {
+		for x := range uint32(1) << z {
+			for y := range uint32(1) << z {
+				tileID := tile.ID{X: x, Y: y, Z: z}
+				testTiles[tileID] = fmt.Appendf(nil, "%v-%v-%v", z, x, y/3)
+				if err := writer.WriteTile(tileID, testTiles[tileID]); err != nil {
+					t.Fatalf("WriteTile failed: %v", err)
+				}
+			}
+		}
+	}
+	if err := writer.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+	return filePath, testTiles
+}
+
+func TestWriterReader(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		filePath, testTiles := writeTestFile(t, 4, mb.WithDeduplication(dedup), mb.WithBatchSize(10, 0))
+
+		reader, err := mb.NewReader(filePath)
+		if err != nil {
+			t.Fatalf("NewReader failed: %v", err)
+		}
+		defer reader.Close()
+
+		if reader.Deduplicated() != dedup {
+			t.Errorf("Deduplicated() = %v, want = %v", reader.Deduplicated(), dedup)
+		}
+
+		got := make(map[tile.ID][]byte)
+		contents := make(map[uint64][]byte)
+		contentIDs := make(map[tile.ID]uint64)
+		err = reader.VisitContents(func(tileID tile.ID, contentID uint64, tileData []byte) error {
+			if data, found := contents[contentID]; found && !slices.Equal(data, tileData) {
+				return fmt.Errorf("different data of content %v", contentID)
+			}
+			contents[contentID] = tileData
+			contentIDs[tileID] = contentID
+			got[tileID] = tileData
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("VisitContents failed: %v", err)
+		}
+		if diff := cmp.Diff(testTiles, got); diff != "" {
+			t.Errorf("dedup=%v: VisitContents mismatch (-want +got):\n%s", dedup, diff)
+		}
+		if dedup && len(contents) == len(testTiles) {
+			t.Errorf("VisitContents returned %d unique contents of %d tiles", len(contents), len(testTiles))
+		}
+
+		gotIDs := make(map[tile.ID]uint64)
+		err = reader.VisitContentIDs(func(tileID tile.ID, contentID uint64) error {
+			gotIDs[tileID] = contentID
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("VisitContentIDs failed: %v", err)
+		}
+		if diff := cmp.Diff(contentIDs, gotIDs); diff != "" {
+			t.Errorf("dedup=%v: VisitContentIDs mismatch (-want +got):\n%s", dedup, diff)
+		}
+	}
+}
+
+func TestWriteContent(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		filePath := filepath.Join(t.TempDir(), "tiles.mbtiles")
+		writer, err := mb.NewWriter(filePath, mb.WithDeduplication(dedup))
+		if err != nil {
+			t.Fatalf("NewWriter failed: %v", err)
+		}
+		defer writer.Close()
+
+		// tiles of a row share data, identified by the row instead of hashing
+		testTiles := make(map[tile.ID][]byte)
+		for x := range uint32(4) {
+			for y := range uint32(4) {
+				tileID := tile.ID{X: x, Y: y, Z: 2}
+				testTiles[tileID] = fmt.Appendf(nil, "row-%v", y)
+				if err := writer.WriteContent(tileID, uint64(y), testTiles[tileID]); err != nil {
+					t.Fatalf("WriteContent failed: %v", err)
+				}
+			}
+		}
+		if err := writer.Finalize(); err != nil {
+			t.Fatalf("Finalize failed: %v", err)
+		}
+
+		reader, err := mb.NewReader(filePath)
+		if err != nil {
+			t.Fatalf("NewReader failed: %v", err)
+		}
+		defer reader.Close()
+
+		got := make(map[tile.ID][]byte)
+		contents := make(map[uint64]bool)
+		err = reader.VisitContents(func(tileID tile.ID, contentID uint64, tileData []byte) error {
+			got[tileID] = tileData
+			contents[contentID] = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("VisitContents failed: %v", err)
+		}
+		if diff := cmp.Diff(testTiles, got); diff != "" {
+			t.Errorf("dedup=%v: VisitContents mismatch (-want +got):\n%s", dedup, diff)
+		}
+		if want := map[bool]int{false: 16, true: 4}[dedup]; len(contents) != want {
+			t.Errorf("dedup=%v: VisitContents returned %d unique contents, want = %d", dedup, len(contents), want)
+		}
+	}
+}
+
+func TestCloseWithoutFinalize(t *testing.T) {
+	// batches are committed after 2 tiles of 1 byte, the last tile is rolled back
+	for _, opt := range []mb.WriterOption{mb.WithBatchSize(2, 0), mb.WithBatchSize(0, 2)} {
+		filePath := filepath.Join(t.TempDir(), "tiles.mbtiles")
+		writer, err := mb.NewWriter(filePath, opt)
+		if err != nil {
+			t.Fatalf("NewWriter failed: %v", err)
+		}
+		for x := range uint32(3) {
+			if err := writer.WriteTile(tile.ID{X: x, Y: 0, Z: 2}, []byte{byte(x)}); err != nil {
+				t.Fatalf("WriteTile failed: %v", err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		reader, err := mb.NewReader(filePath)
+		if err != nil {
+			t.Fatalf("NewReader failed: %v", err)
+		}
+		defer reader.Close()
+
+		count := 0
+		if err := reader.VisitTiles(func(tile.ID, []byte) error { count++; return nil }); err != nil {
+			t.Fatalf("VisitTiles failed: %v", err)
+		}
+		if count != 2 {
+			t.Errorf("VisitTiles visited %d tiles, want = 2 (tiles of the committed transaction)", count)
+		}
+	}
+}
+
+func TestVisit(t *testing.T) {
+	filePath, testTiles := writeTestFile(t, 5)
+
+	reader, err := mb.NewReader(filePath)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	bounds := tile.Bounds{Z: 3, MinX: 1, MinY: 2, MaxX: 4, MaxY: 2}
+	var want []tile.ID
+	for tileID := range testTiles {
+		if tileID.Z >= 2 && tileID.Z <= 4 && bounds.Contains(tileID) {
+			want = append(want, tileID)
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		order mb.VisitOrder
+		key   func(tileID tile.ID) uint64
+	}{
+		{"OrderZoom", mb.OrderZoom, func(tileID tile.ID) uint64 {
+			row := (1 << tileID.Z) - 1 - tileID.Y // XYZ -> TMS
+			return uint64(tileID.Z)<<40 | uint64(tileID.X)<<20 | uint64(row)
+		}},
+		{"OrderHilbert", mb.OrderHilbert, spec.EncodeTileID},
+	} {
+		var got []tile.ID
+		err := reader.Visit(func(tileID tile.ID, tileData []byte) error {
+			if !slices.Equal(tileData, testTiles[tileID]) {
+				return fmt.Errorf("invalid data of tile %v", tileID)
+			}
+			got = append(got, tileID)
+			return nil
+		}, mb.WithZoomRange(2, 4), mb.WithBounds(bounds), mb.WithOrder(tc.order))
+		if err != nil {
+			t.Fatalf("%s: Visit failed: %v", tc.name, err)
+		}
+		slices.SortFunc(want, func(a, b tile.ID) int { return int(tc.key(a)) - int(tc.key(b)) })
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: Visit mismatch (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestVisitHilbertBlocks(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "tiles.mbtiles")
+	writer, err := mb.NewWriter(filePath)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	defer writer.Close()
+
+	// sparse tiles of zoom level 9, in many blocks of visitHilbert
+	var want []tile.ID
+	for i := range uint32(300) {
+		tileID := tile.ID{X: i * 97 % 512, Y: i * 193 % 512, Z: 9}
+		if err := writer.WriteTile(tileID, fmt.Appendf(nil, "%v", tileID)); err != nil {
+			t.Fatalf("WriteTile failed: %v", err)
+		}
+		want = append(want, tileID)
+	}
+	if err := writer.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+
+	reader, err := mb.NewReader(filePath)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	var got []tile.ID
+	err = reader.Visit(func(tileID tile.ID, tileData []byte) error {
+		if string(tileData) != fmt.Sprint(tileID) {
+			return fmt.Errorf("invalid data of tile %v", tileID)
+		}
+		got = append(got, tileID)
+		return nil
+	}, mb.WithOrder(mb.OrderHilbert))
+	if err != nil {
+		t.Fatalf("Visit failed: %v", err)
+	}
+	slices.SortFunc(want, func(a, b tile.ID) int { return int(spec.EncodeTileID(a)) - int(spec.EncodeTileID(b)) })
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Visit mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestConcurrentReadTile(t *testing.T) {
+	filePath, testTiles := writeTestFile(t, 5)
+
+	reader, err := mb.NewReader(
+		filePath,
+		mb.WithMaxOpenConns(4),
+		mb.WithMmapSize(1<<20),
+		mb.WithCacheSize(1024),
+		mb.WithImmutable(true),
+	)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for range 16 {
+		wg.Go(func() {
+			for tileID, tileData := range testTiles {
+				got, err := reader.ReadTile(tileID)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !slices.Equal(got, tileData) {
+					errs <- fmt.Errorf("ReadTile(%v) = %q, want = %q", tileID, got, tileData)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestGrids(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "tiles.mbtiles")
+	writer, err := mb.NewWriter(filePath)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	defer writer.Close()
+
+	utfGrids := map[tile.ID]string{
+		{X: 0, Y: 0, Z: 1}: `{"data":{"1":{"name":"a"},"2":{"name":"b"}},"grid":["  ","!#"],"keys":["","1","2"]}`,
+		{X: 1, Y: 0, Z: 1}: `{"data":{},"grid":["  ","  "],"keys":[""]}`,
+	}
+	grids := make(map[tile.ID]mb.Grid)
+	for tileID, utfGrid := range utfGrids {
+		grid, err := mb.ParseUTFGrid([]byte(utfGrid))
+		if err != nil {
+			t.Fatalf("ParseUTFGrid failed: %v", err)
+		}
+		if err := writer.WriteGrid(tileID, grid); err != nil {
+			t.Fatalf("WriteGrid failed: %v", err)
+		}
+		grids[tileID] = grid
+	}
+	if err := writer.WriteTile(tile.ID{X: 0, Y: 0, Z: 1}, []byte{1}); err != nil {
+		t.Fatalf("WriteTile failed: %v", err)
+	}
+	if err := writer.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+
+	reader, err := mb.NewReader(filePath)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	if !reader.HasGrids() {
+		t.Errorf("HasGrids() = false, want = true")
+	}
+
+	got := make(map[tile.ID]mb.Grid)
+	if err := reader.VisitGrids(func(tileID tile.ID, grid mb.Grid) error { got[tileID] = grid; return nil }); err != nil {
+		t.Fatalf("VisitGrids failed: %v", err)
+	}
+	if diff := cmp.Diff(grids, got); diff != "" {
+		t.Errorf("VisitGrids mismatch (-want +got):\n%s", diff)
+	}
+
+	for tileID, utfGrid := range utfGrids {
+		grid, err := reader.ReadGrid(tileID)
+		if err != nil || grid == nil {
+			t.Fatalf("ReadGrid(%v) = %v, %v", tileID, grid, err)
+		}
+		gotUTFGrid, err := grid.UTFGrid()
+		if err != nil {
+			t.Fatalf("UTFGrid failed: %v", err)
+		}
+		if string(gotUTFGrid) != utfGrid {
+			t.Errorf("UTFGrid() = %s, want = %s", gotUTFGrid, utfGrid)
+		}
+	}
+
+	if grid, err := reader.ReadGrid(tile.ID{X: 0, Y: 1, Z: 1}); grid != nil || err != nil {
+		t.Errorf("ReadGrid() of tile without grid = %v, %v, want = nil, nil", grid, err)
+	}
+}
@@ -5,25 +5,111 @@
 package mb
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"runtime"
+	"slices"
 
+	"github.com/eak1mov/go-libtiles/pm/spec"
 	"github.com/eak1mov/go-libtiles/tile"
 )
 
 // Reader implements tile.Reader interface for MBTiles format.
+//
+// ReadTile and visiting methods are safe for concurrent use by multiple
+// goroutines, they are served by a pool of connections (see WithMaxOpenConns).
 type Reader struct {
-	db   *sql.DB
-	stmt *sql.Stmt
+	db           *sql.DB
+	stmt         *sql.Stmt
+	deduplicated bool
+	hasGrids     bool
+}
+
+type readerConfig struct {
+	MaxOpenConns int
+	MmapSize     int64
+	CacheSize    int64
+	Immutable    bool
+	NoLock       bool
+}
+
+type ReaderOption func(*readerConfig)
+
+// WithMaxOpenConns sets the maximum number of open database connections, all
+// of them are kept open while the Reader is open. Default is 0: unlimited,
+// or runtime.NumCPU() if pragmas are set (see WithMmapSize, WithCacheSize).
+// Visiting methods hold a connection until they return.
+func WithMaxOpenConns(maxOpenConns int) ReaderOption {
+	return func(c *readerConfig) { c.MaxOpenConns = maxOpenConns }
+}
+
+// WithMmapSize sets the mmap_size pragma of SQLite: the maximum number of
+// bytes of the file accessed with memory-mapped I/O.
+func WithMmapSize(mmapSize int64) ReaderOption {
+	return func(c *readerConfig) { c.MmapSize = mmapSize }
+}
+
+// WithCacheSize sets the cache_size pragma of SQLite: the maximum size of
+// the page cache of each connection in KiB.
+func WithCacheSize(cacheSize int64) ReaderOption {
+	return func(c *readerConfig) { c.CacheSize = cacheSize }
+}
+
+// WithImmutable sets the immutable flag of the database URI: SQLite assumes
+// that the file can't be changed, and doesn't use any locking. It must be
+// enabled only for files which are not modified while being read.
+func WithImmutable(enable bool) ReaderOption {
+	return func(c *readerConfig) { c.Immutable = enable }
+}
+
+// WithNoLock sets the nolock flag of the database URI: SQLite doesn't use
+// file locking, e.g. for files on file systems without locks support.
+func WithNoLock(enable bool) ReaderOption {
+	return func(c *readerConfig) { c.NoLock = enable }
 }
 
 // NewReader creates a new Reader for the given MBTiles file path.
 //
 // The returned Reader must be closed after use to release database resources.
-func NewReader(filePath string) (*Reader, error) {
-	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", filePath))
+func NewReader(filePath string, opts ...ReaderOption) (*Reader, error) {
+	config := readerConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	uri := fmt.Sprintf("file:%s?mode=ro", filePath)
+	if config.Immutable {
+		uri += "&immutable=1"
+	}
+	if config.NoLock {
+		uri += "&nolock=1"
+	}
+
+	db, err := sql.Open("sqlite3", uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := initConns(db, &config); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// the schema of deduplicated files: tiles view over map and images tables
+	var dedupTables int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('map', 'images')").Scan(&dedupTables)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var gridTables int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type IN ('table', 'view') AND name IN ('grids', 'grid_data')").Scan(&gridTables)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -33,7 +119,54 @@ func NewReader(filePath string) (*Reader, error) {
 		return nil, err
 	}
 
-	return &Reader{db: db, stmt: stmt}, nil
+	return &Reader{db: db, stmt: stmt, deduplicated: dedupTables == 2, hasGrids: gridTables == 2}, nil
+}
+
+// initConns limits the pool of connections and applies pragmas to all of
+// them. Pragmas apply to a single connection, so the pool must be limited, and
+// its connections must never be closed.
+func initConns(db *sql.DB, config *readerConfig) error {
+	var pragmas []string
+	if config.MmapSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA mmap_size = %d", config.MmapSize))
+	}
+	if config.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = %d", -config.CacheSize)) // negative value is in KiB
+	}
+
+	maxOpenConns := config.MaxOpenConns
+	if maxOpenConns == 0 && len(pragmas) > 0 {
+		maxOpenConns = runtime.NumCPU()
+	}
+	if maxOpenConns == 0 {
+		return nil
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
+	if len(pragmas) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	conns := make([]*sql.Conn, 0, maxOpenConns)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close() // returns connection to the pool
+		}
+	}()
+	for range maxOpenConns {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		for _, pragma := range pragmas {
+			if _, err := conn.ExecContext(ctx, pragma); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (r *Reader) Close() error {
@@ -79,8 +212,183 @@ func (r *Reader) ReadTile(tileID tile.ID) ([]byte, error) {
 	return tileData, nil
 }
 
+// Deduplicated reports whether the file has the schema of deduplicated tiles
+// (the tiles view over map and images tables), so that content identifiers
+// of VisitContents are equal for tiles sharing data.
+func (r *Reader) Deduplicated() bool {
+	return r.deduplicated
+}
+
+// VisitContents visits all tiles, passing rowid of the images table as
+// contentID for deduplicated files (see Deduplicated), or rowid of the tiles
+// table otherwise.
+func (r *Reader) VisitContents(fn tile.ContentVisitFunc) error {
+	query := "SELECT zoom_level, tile_column, tile_row, rowid, tile_data FROM tiles"
+	if r.deduplicated {
+		query = "SELECT map.zoom_level, map.tile_column, map.tile_row, images.rowid, images.tile_data FROM map JOIN images ON images.tile_id = map.tile_id"
+	}
+	return r.visitContents(query, fn)
+}
+
+// ContentIDVisitFunc is a callback function for Reader.VisitContentIDs.
+type ContentIDVisitFunc func(tileID tile.ID, contentID uint64) error
+
+// VisitContentIDs visits all tiles like VisitContents, without reading tile
+// data, e.g. to count unique contents.
+func (r *Reader) VisitContentIDs(fn ContentIDVisitFunc) error {
+	query := "SELECT zoom_level, tile_column, tile_row, rowid FROM tiles"
+	if r.deduplicated {
+		query = "SELECT map.zoom_level, map.tile_column, map.tile_row, images.rowid FROM map JOIN images ON images.tile_id = map.tile_id"
+	}
+	return r.visitContents(query, func(tileID tile.ID, contentID uint64, _ []byte) error {
+		return fn(tileID, contentID)
+	})
+}
+
+// visitContents runs query selecting zoom level, column, row, content
+// identifier and optionally tile data.
+func (r *Reader) visitContents(query string, fn tile.ContentVisitFunc) error {
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var x, y, z uint32
+		var contentID uint64
+		var tileData []byte
+
+		dest := []any{&z, &x, &y, &contentID, &tileData}
+		if err := rows.Scan(dest[:len(columns)]...); err != nil {
+			return err
+		}
+
+		y = (1 << z) - 1 - y // TMS -> XYZ
+
+		if err := fn(tile.ID{X: x, Y: y, Z: z}, contentID, tileData); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// VisitOrder is an order of tiles in Reader.Visit.
+type VisitOrder int
+
+const (
+	OrderNone    VisitOrder = iota // order of SQLite, the fastest one
+	OrderZoom                      // by zoom, column and TMS row (order of the unique tile index)
+	OrderHilbert                   // by TileCode of PMTiles (zoom, then Hilbert curve), see Reader.Visit
+)
+
+type visitConfig struct {
+	MinZoom uint32
+	MaxZoom uint32
+	Bounds  *tile.Bounds
+	Order   VisitOrder
+}
+
+type VisitOption func(*visitConfig)
+
+// WithZoomRange limits visited tiles to zoom levels from minZoom to maxZoom inclusive.
+func WithZoomRange(minZoom, maxZoom uint32) VisitOption {
+	return func(c *visitConfig) {
+		c.MinZoom = minZoom
+		c.MaxZoom = maxZoom
+	}
+}
+
+// WithBounds limits visited tiles to the bounds, scaled to each zoom level.
+func WithBounds(bounds tile.Bounds) VisitOption {
+	return func(c *visitConfig) { c.Bounds = &bounds }
+}
+
+func WithOrder(order VisitOrder) VisitOption {
+	return func(c *visitConfig) { c.Order = order }
+}
+
 func (r *Reader) VisitTiles(fn tile.VisitFunc) error {
-	rows, err := r.db.Query("SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles")
+	return r.Visit(fn)
+}
+
+// Visit visits tiles selected by options, calling fn for each.
+//
+// Filters and orders are evaluated by SQLite using the unique tile index,
+// except OrderHilbert: tiles of each zoom level are read in blocks of 64x64
+// tiles, which are sorted in memory. It keeps in memory tiles of one block
+// and coordinates of all non-empty blocks of the zoom level.
+func (r *Reader) Visit(fn tile.VisitFunc, opts ...VisitOption) error {
+	config := visitConfig{
+		MinZoom: 0,
+		MaxZoom: 31,
+		Order:   OrderNone,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if config.Bounds == nil && config.Order != OrderHilbert {
+		query := "SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles"
+		var args []any
+		if config.MinZoom > 0 || config.MaxZoom < 31 {
+			query += " WHERE zoom_level BETWEEN ? AND ?"
+			args = append(args, config.MinZoom, config.MaxZoom)
+		}
+		if config.Order == OrderZoom {
+			query += " ORDER BY zoom_level, tile_column, tile_row"
+		}
+		return r.visitQuery(fn, query, args...)
+	}
+
+	// visit zoom levels one by one, as bounds are different for each of them
+	var minZoom, maxZoom sql.NullInt64
+	err := r.db.QueryRow(
+		"SELECT MIN(zoom_level), MAX(zoom_level) FROM tiles WHERE zoom_level BETWEEN ? AND ?",
+		config.MinZoom, config.MaxZoom,
+	).Scan(&minZoom, &maxZoom)
+	if err != nil || !minZoom.Valid {
+		return err
+	}
+
+	for z := uint32(minZoom.Int64); z <= uint32(maxZoom.Int64); z++ {
+		where, args := zoomCondition(z, config.Bounds)
+		if config.Order == OrderHilbert {
+			err = r.visitHilbert(fn, z, where, args...)
+		} else {
+			query := "SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles WHERE " + where
+			if config.Order == OrderZoom {
+				query += " ORDER BY tile_column, tile_row"
+			}
+			err = r.visitQuery(fn, query, args...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// zoomCondition returns SQL condition selecting tiles of zoom level z inside bounds.
+func zoomCondition(z uint32, bounds *tile.Bounds) (string, []any) {
+	if bounds == nil {
+		return "zoom_level = ?", []any{z}
+	}
+	b := bounds.At(z)
+	maxRow := uint32(1)<<z - 1
+	return "zoom_level = ? AND tile_column BETWEEN ? AND ? AND tile_row BETWEEN ? AND ?",
+		[]any{z, b.MinX, b.MaxX, maxRow - min(b.MaxY, maxRow), maxRow - min(b.MinY, maxRow)} // XYZ -> TMS
+}
+
+func (r *Reader) visitQuery(fn tile.VisitFunc, query string, args ...any) error {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return err
 	}
@@ -107,3 +415,84 @@ func (r *Reader) VisitTiles(fn tile.VisitFunc) error {
 
 	return nil
 }
+
+// hilbertBlockZoom is the size of blocks of visitHilbert (64x64 tiles), tiles
+// of a block are a contiguous range of Hilbert codes.
+const hilbertBlockZoom = 6
+
+// visitHilbert visits tiles of zoom level z in Hilbert order: non-empty blocks
+// of tiles are sorted by Hilbert codes of their root tiles, and tiles of each
+// block are read by a single query and sorted in memory.
+func (r *Reader) visitHilbert(fn tile.VisitFunc, z uint32, where string, args ...any) error {
+	blockZoom := min(z, hilbertBlockZoom)
+	rows, err := r.db.Query(
+		"SELECT DISTINCT tile_column >> ?, tile_row >> ? FROM tiles WHERE "+where,
+		append([]any{blockZoom, blockZoom}, args...)...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var blockCodes []uint64
+	for rows.Next() {
+		var x, y uint32
+		if err := rows.Scan(&x, &y); err != nil {
+			return err
+		}
+		y = (1 << (z - blockZoom)) - 1 - y // TMS -> XYZ
+		blockCodes = append(blockCodes, spec.EncodeTileID(tile.ID{X: x, Y: y, Z: z - blockZoom}))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	slices.Sort(blockCodes)
+	for _, blockCode := range blockCodes {
+		if err := r.visitHilbertBlock(fn, z, spec.DecodeTileID(blockCode), where, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) visitHilbertBlock(fn tile.VisitFunc, z uint32, block tile.ID, where string, args ...any) error {
+	b := tile.Bounds{MinX: block.X, MinY: block.Y, MaxX: block.X, MaxY: block.Y, Z: block.Z}.At(z)
+	blockWhere, blockArgs := zoomCondition(z, &b)
+	rows, err := r.db.Query(
+		"SELECT tile_column, tile_row, tile_data FROM tiles WHERE "+where+" AND "+blockWhere,
+		append(slices.Clip(args), blockArgs...)...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type codeTile struct {
+		tileCode uint64
+		tileData []byte
+	}
+	var tiles []codeTile
+	for rows.Next() {
+		var x, y uint32
+		var tileData []byte
+		if err := rows.Scan(&x, &y, &tileData); err != nil {
+			return err
+		}
+		y = (1 << z) - 1 - y // TMS -> XYZ
+		tiles = append(tiles, codeTile{spec.EncodeTileID(tile.ID{X: x, Y: y, Z: z}), tileData})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	slices.SortFunc(tiles, func(a, b codeTile) int { return cmp.Compare(a.tileCode, b.tileCode) })
+	for _, t := range tiles {
+		if err := fn(spec.DecodeTileID(t.tileCode), t.tileData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
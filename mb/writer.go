@@ -15,6 +15,10 @@ import (
 type Writer interface {
 	io.Closer
 	tile.Writer
+	tile.ContentWriter
+
+	// WriteGrid writes the UTFGrid of a single tile.
+	WriteGrid(tileID tile.ID, grid Grid) error
 }
 
 type writerConfig struct {
@@ -22,8 +26,15 @@ type writerConfig struct {
 	Logger        *log.Logger
 	Optimizations bool
 	Deduplication bool
+	BatchTiles    int
+	BatchBytes    int
 }
 
+const (
+	DefaultBatchTiles = 10000
+	DefaultBatchBytes = 64 << 20
+)
+
 type WriterOption func(*writerConfig)
 
 func WithMetadata(metadata map[string]string) WriterOption {
@@ -41,18 +52,33 @@ func WithDeduplication(enable bool) WriterOption {
 	return func(c *writerConfig) { c.Deduplication = enable }
 }
 
+// WithBatchSize sets the size of transactions: tiles are inserted in a
+// transaction which is committed after maxTiles tiles or maxBytes bytes of
+// tile data, whichever comes first (DefaultBatchTiles and DefaultBatchBytes by
+// default). Zero means no limit.
+func WithBatchSize(maxTiles, maxBytes int) WriterOption {
+	return func(c *writerConfig) {
+		c.BatchTiles = maxTiles
+		c.BatchBytes = maxBytes
+	}
+}
+
 // NewWriter creates a new Writer for writing to a MBTiles file.
 // It always creates a new file and does not support appending to an existing one.
 //
 // Finalize() must be called to complete writing, otherwise the output file
 // will be left in an invalid state.
 //
-// Close() should always be called to release database resources.
+// Close() should always be called to release database resources. Close without
+// Finalize rolls back the current transaction (see WithBatchSize), tiles of
+// already committed transactions are kept.
 func NewWriter(filePath string, opts ...WriterOption) (writer Writer, err error) {
 	config := writerConfig{
 		Logger:        log.New(io.Discard, "", log.LstdFlags),
 		Optimizations: true,
 		Deduplication: true,
+		BatchTiles:    DefaultBatchTiles,
+		BatchBytes:    DefaultBatchBytes,
 	}
 	for _, opt := range opts {
 		opt(&config)
@@ -66,6 +92,9 @@ func NewWriter(filePath string, opts ...WriterOption) (writer Writer, err error)
 	if err != nil {
 		return nil, err
 	}
+	// single connection, so that pragmas apply to all statements and
+	// transactions don't lock each other
+	db.SetMaxOpenConns(1)
 	defer func() {
 		if err != nil {
 			db.Close()
@@ -97,10 +126,11 @@ func NewWriter(filePath string, opts ...WriterOption) (writer Writer, err error)
 		}
 	}
 
+	batch := &txBatch{maxTiles: config.BatchTiles, maxBytes: config.BatchBytes}
 	if config.Deduplication {
-		writer, err = newDedupWriter(db)
+		writer, err = newDedupWriter(db, batch)
 	} else {
-		writer, err = newFlatWriter(db)
+		writer, err = newFlatWriter(db, batch)
 	}
 	if err != nil {
 		return nil, err
@@ -109,12 +139,83 @@ func NewWriter(filePath string, opts ...WriterOption) (writer Writer, err error)
 	return writer, nil
 }
 
+// txBatch executes statements in transactions committed every maxTiles tiles
+// or maxBytes bytes.
+type txBatch struct {
+	maxTiles int
+	maxBytes int
+
+	tx    *sql.Tx
+	stmts map[*sql.Stmt]*sql.Stmt // prepared statement -> statement of tx
+	tiles int
+	bytes int
+}
+
+// stmt returns the statement bound to the current transaction, it begins a
+// new transaction if needed. Statements are bound once per transaction.
+func (b *txBatch) stmt(db *sql.DB, stmt *sql.Stmt) (*sql.Stmt, error) {
+	if b.tx == nil {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		b.tx = tx
+		b.stmts = make(map[*sql.Stmt]*sql.Stmt)
+	}
+	txStmt, found := b.stmts[stmt]
+	if !found {
+		txStmt = b.tx.Stmt(stmt)
+		b.stmts[stmt] = txStmt
+	}
+	return txStmt, nil
+}
+
+// add counts the written tile, and commits the transaction if it is full.
+func (b *txBatch) add(tileLength int) error {
+	b.tiles++
+	b.bytes += tileLength
+	if (b.maxTiles > 0 && b.tiles >= b.maxTiles) || (b.maxBytes > 0 && b.bytes >= b.maxBytes) {
+		return b.commit()
+	}
+	return nil
+}
+
+func (b *txBatch) commit() error {
+	if b.tx == nil {
+		return nil
+	}
+	tx, err := b.end()
+	return errors.Join(err, tx.Commit())
+}
+
+func (b *txBatch) rollback() error {
+	if b.tx == nil {
+		return nil
+	}
+	tx, err := b.end()
+	return errors.Join(err, tx.Rollback())
+}
+
+// end closes statements of the current transaction and resets the batch, it
+// returns the transaction to commit or roll back.
+func (b *txBatch) end() (*sql.Tx, error) {
+	var err error
+	for _, txStmt := range b.stmts {
+		err = errors.Join(err, txStmt.Close())
+	}
+	tx := b.tx
+	b.tx, b.stmts, b.tiles, b.bytes = nil, nil, 0, 0
+	return tx, err
+}
+
 type flatWriter struct {
-	db   *sql.DB
-	stmt *sql.Stmt
+	*gridWriter
+	db    *sql.DB
+	stmt  *sql.Stmt
+	batch *txBatch
 }
 
-func newFlatWriter(db *sql.DB) (*flatWriter, error) {
+func newFlatWriter(db *sql.DB, batch *txBatch) (*flatWriter, error) {
 	_, err := db.Exec(`
 		CREATE TABLE tiles (
 			zoom_level INTEGER,
@@ -132,34 +233,58 @@ func newFlatWriter(db *sql.DB) (*flatWriter, error) {
 		return nil, err
 	}
 
-	return &flatWriter{db: db, stmt: stmt}, nil
+	return &flatWriter{
+		gridWriter: &gridWriter{db: db, batch: batch},
+		db:         db,
+		stmt:       stmt,
+		batch:      batch,
+	}, nil
 }
 
 func (w *flatWriter) Close() error {
-	return errors.Join(w.stmt.Close(), w.db.Close())
+	return errors.Join(w.batch.rollback(), w.gridWriter.close(), w.stmt.Close(), w.db.Close())
 }
 
 func (w *flatWriter) WriteTile(tileID tile.ID, tileData []byte) error {
 	x, y, z := tileID.X, tileID.Y, tileID.Z
 	y = (1 << z) - 1 - y // XYZ -> TMS
 
-	_, err := w.stmt.Exec(z, x, y, tileData)
-	return err
+	stmt, err := w.batch.stmt(w.db, w.stmt)
+	if err != nil {
+		return err
+	}
+	if _, err := stmt.Exec(z, x, y, tileData); err != nil {
+		return err
+	}
+	return w.batch.add(len(tileData))
+}
+
+func (w *flatWriter) WriteContent(tileID tile.ID, _ uint64, tileData []byte) error {
+	return w.WriteTile(tileID, tileData)
 }
 
 func (w *flatWriter) Finalize() error {
-	_, err := w.db.Exec("CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row)")
-	return err
+	if err := w.batch.commit(); err != nil {
+		return err
+	}
+	if _, err := w.db.Exec("CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row)"); err != nil {
+		return err
+	}
+	return w.gridWriter.finalize()
 }
 
 type dedupWriter struct {
+	*gridWriter
 	db        *sql.DB
 	dataStmt  *sql.Stmt
 	indexStmt *sql.Stmt
 	dataIDs   map[[16]byte]uint32 // hash -> id
+	contents  map[uint64]uint32   // content id -> id, see WriteContent
+	nextID    uint32
+	batch     *txBatch
 }
 
-func newDedupWriter(db *sql.DB) (*dedupWriter, error) {
+func newDedupWriter(db *sql.DB, batch *txBatch) (*dedupWriter, error) {
 	var err error
 
 	_, err = db.Exec(`
@@ -193,37 +318,72 @@ func newDedupWriter(db *sql.DB) (*dedupWriter, error) {
 	}
 
 	return &dedupWriter{
-		db:        db,
-		dataStmt:  dataStmt,
-		indexStmt: indexStmt,
-		dataIDs:   make(map[[16]byte]uint32),
+		gridWriter: &gridWriter{db: db, batch: batch},
+		db:         db,
+		dataStmt:   dataStmt,
+		indexStmt:  indexStmt,
+		dataIDs:    make(map[[16]byte]uint32),
+		contents:   make(map[uint64]uint32),
+		batch:      batch,
 	}, nil
 }
 
 func (w *dedupWriter) Close() error {
-	return errors.Join(w.indexStmt.Close(), w.dataStmt.Close(), w.db.Close())
+	return errors.Join(w.batch.rollback(), w.gridWriter.close(), w.indexStmt.Close(), w.dataStmt.Close(), w.db.Close())
 }
 
 func (w *dedupWriter) WriteTile(tileID tile.ID, tileData []byte) error {
-	x, y, z := tileID.X, tileID.Y, tileID.Z
-	y = (1 << z) - 1 - y // XYZ -> TMS
-
 	digest := md5.Sum(tileData)
 	tileDataID, exists := w.dataIDs[digest]
-
 	if !exists {
-		tileDataID = uint32(len(w.dataIDs))
+		tileDataID = w.nextID
 		w.dataIDs[digest] = tileDataID
+	}
+	return w.writeTile(tileID, tileData, tileDataID, exists)
+}
+
+func (w *dedupWriter) WriteContent(tileID tile.ID, contentID uint64, tileData []byte) error {
+	tileDataID, exists := w.contents[contentID]
+	if !exists {
+		tileDataID = w.nextID
+		w.contents[contentID] = tileDataID
+	}
+	return w.writeTile(tileID, tileData, tileDataID, exists)
+}
+
+// writeTile inserts the tile into the map table, and its data into the images
+// table unless it exists already.
+func (w *dedupWriter) writeTile(tileID tile.ID, tileData []byte, tileDataID uint32, exists bool) error {
+	x, y, z := tileID.X, tileID.Y, tileID.Z
+	y = (1 << z) - 1 - y // XYZ -> TMS
+
+	dataLength := 0
+	if !exists {
+		w.nextID++
 
-		if _, err := w.dataStmt.Exec(tileDataID, tileData); err != nil {
+		dataStmt, err := w.batch.stmt(w.db, w.dataStmt)
+		if err != nil {
 			return err
 		}
+		if _, err := dataStmt.Exec(tileDataID, tileData); err != nil {
+			return err
+		}
+		dataLength = len(tileData)
 	}
 
-	_, err := w.indexStmt.Exec(z, x, y, tileDataID)
-	return err
+	indexStmt, err := w.batch.stmt(w.db, w.indexStmt)
+	if err != nil {
+		return err
+	}
+	if _, err := indexStmt.Exec(z, x, y, tileDataID); err != nil {
+		return err
+	}
+	return w.batch.add(dataLength)
 }
 
 func (w *dedupWriter) Finalize() error {
-	return nil
+	if err := w.batch.commit(); err != nil {
+		return err
+	}
+	return w.gridWriter.finalize()
 }
@@ -0,0 +1,292 @@
+package pm
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/eak1mov/go-libtiles/internal/extsort"
+	"github.com/eak1mov/go-libtiles/pm/spec"
+)
+
+// defaultMemoryLimit is the default size of the in-memory entries buffer of Import.
+const defaultMemoryLimit = 1 << 30
+
+// leafLength is the max number of entries in a leaf directory.
+const leafLength = 4096
+
+// directoryWriter builds directories from entries sorted by TileCode. Entries
+// are compacted on the fly, leaf directories are written to a temporary file as
+// soon as they are full, so only the current leaf and root entries of written
+// leaves are kept in memory.
+type directoryWriter struct {
+	compression spec.Compression
+	tempDir     string
+
+	leaves       *os.File
+	leavesWriter *bufio.Writer
+	leavesLength uint64
+
+	leafEntries []spec.Entry // current leaf, compacted
+	rootEntries []spec.Entry // entries of written leaves
+}
+
+func newDirectoryWriter(compression spec.Compression, tempDir string) *directoryWriter {
+	return &directoryWriter{
+		compression: compression,
+		tempDir:     tempDir,
+	}
+}
+
+// add adds the entry, its TileCode must be greater than of all added entries.
+func (d *directoryWriter) add(entry spec.Entry) error {
+	if n := len(d.leafEntries); n > 0 {
+		last := &d.leafEntries[n-1]
+		if entry.Offset == last.Offset && entry.TileCode == last.TileCode+uint64(last.RunLength) {
+			last.RunLength++
+			return nil
+		}
+		if n == leafLength {
+			if err := d.flushLeaf(); err != nil {
+				return err
+			}
+		}
+	}
+	d.leafEntries = append(d.leafEntries, entry)
+	return nil
+}
+
+// writeDirectory appends the directory to the leaves, and returns its entry.
+func (d *directoryWriter) writeDirectory(entries []spec.Entry) (spec.Entry, error) {
+	if d.leaves == nil {
+		file, err := os.CreateTemp(d.tempDir, "libtiles-leaves-*")
+		if err != nil {
+			return spec.Entry{}, err
+		}
+		d.leaves = file
+		d.leavesWriter = bufio.NewWriter(file)
+	}
+
+	data, err := spec.Compress(spec.SerializeDirectory(entries), d.compression)
+	if err != nil {
+		return spec.Entry{}, err
+	}
+	if _, err := d.leavesWriter.Write(data); err != nil {
+		return spec.Entry{}, err
+	}
+
+	entry := spec.Entry{
+		TileCode:  entries[0].TileCode,
+		Offset:    d.leavesLength,
+		Length:    uint32(len(data)),
+		RunLength: 0,
+	}
+	d.leavesLength += uint64(len(data))
+	return entry, nil
+}
+
+func (d *directoryWriter) flushLeaf() error {
+	entry, err := d.writeDirectory(d.leafEntries)
+	if err != nil {
+		return err
+	}
+	d.rootEntries = append(d.rootEntries, entry)
+	d.leafEntries = d.leafEntries[:0]
+	return nil
+}
+
+// finish writes remaining leaves and returns the root directory. If root
+// entries of leaves don't fit in the root, they are grouped in intermediate
+// leaf directories, as many levels as needed.
+func (d *directoryWriter) finish() ([]byte, error) {
+	if len(d.rootEntries) == 0 {
+		rootData, err := spec.Compress(spec.SerializeDirectory(d.leafEntries), d.compression)
+		if err != nil || len(rootData) <= spec.RootDirMaxLength {
+			return rootData, err
+		}
+	}
+	if len(d.leafEntries) > 0 {
+		if err := d.flushLeaf(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		rootData, err := spec.Compress(spec.SerializeDirectory(d.rootEntries), d.compression)
+		if err != nil || len(rootData) <= spec.RootDirMaxLength {
+			return rootData, err
+		}
+
+		var rootEntries []spec.Entry
+		for entries := range slices.Chunk(d.rootEntries, leafLength) {
+			entry, err := d.writeDirectory(entries)
+			if err != nil {
+				return nil, err
+			}
+			rootEntries = append(rootEntries, entry)
+		}
+		d.rootEntries = rootEntries
+	}
+}
+
+// visit calls fn for all added entries with RunLength expanded, it must be
+// called before finish.
+func (d *directoryWriter) visit(fn func(entry spec.Entry) error) error {
+	visitEntries := func(entries []spec.Entry) error {
+		for _, entry := range entries {
+			for i := range uint64(entry.RunLength) {
+				tileEntry := entry
+				tileEntry.TileCode += i
+				tileEntry.RunLength = 1
+				if err := fn(tileEntry); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if d.leaves != nil {
+		if err := d.leavesWriter.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, rootEntry := range d.rootEntries {
+		data := make([]byte, rootEntry.Length)
+		if _, err := d.leaves.ReadAt(data, int64(rootEntry.Offset)); err != nil {
+			return err
+		}
+		data, err := spec.Decompress(data, d.compression)
+		if err != nil {
+			return err
+		}
+		entries, err := spec.DeserializeDirectory(data)
+		if err != nil {
+			return err
+		}
+		if err := visitEntries(entries); err != nil {
+			return err
+		}
+	}
+	return visitEntries(d.leafEntries)
+}
+
+// writeLeaves copies the leaves to w, it must be called after finish.
+func (d *directoryWriter) writeLeaves(w io.Writer) error {
+	if d.leaves == nil {
+		return nil
+	}
+	if err := d.leavesWriter.Flush(); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, io.NewSectionReader(d.leaves, 0, int64(d.leavesLength)))
+	return err
+}
+
+// Close removes the temporary file.
+func (d *directoryWriter) Close() error {
+	if d.leaves == nil {
+		return nil
+	}
+	err := d.leaves.Close()
+	if removeErr := os.Remove(d.leaves.Name()); err == nil {
+		err = removeErr
+	}
+	d.leaves = nil
+	return err
+}
+
+// entrySorter passes entries to the directory writer. While entries are
+// sorted by TileCode, they are written to directories immediately. On the
+// first entry out of order, it falls back to external sort: entries are
+// sorted in runs limited by memory, spilled to temporary files and merged by
+// finish. Of several entries of the same tile, the last one wins.
+type entrySorter struct {
+	directories *directoryWriter
+	sorter      *extsort.Sorter[spec.Entry]
+
+	sorted   bool
+	lastCode uint64
+	count    uint64
+}
+
+func newEntrySorter(directories *directoryWriter, memoryLimit uint64, tempDir string) *entrySorter {
+	if memoryLimit == 0 {
+		memoryLimit = defaultMemoryLimit
+	}
+	return &entrySorter{
+		directories: directories,
+		sorter:      extsort.New(compareEntries, entryCodec, memoryLimit, tempDir),
+		sorted:      true,
+	}
+}
+
+func compareEntries(a, b spec.Entry) int {
+	return cmp.Compare(a.TileCode, b.TileCode)
+}
+
+// entryCodec encodes TileCode, Offset and Length of entries with RunLength 1.
+var entryCodec = extsort.Codec[spec.Entry]{
+	Size: 20,
+	Encode: func(buffer []byte, entry spec.Entry) {
+		binary.LittleEndian.PutUint64(buffer[0:], entry.TileCode)
+		binary.LittleEndian.PutUint64(buffer[8:], entry.Offset)
+		binary.LittleEndian.PutUint32(buffer[16:], entry.Length)
+	},
+	Decode: func(buffer []byte) spec.Entry {
+		return spec.Entry{
+			TileCode:  binary.LittleEndian.Uint64(buffer[0:]),
+			Offset:    binary.LittleEndian.Uint64(buffer[8:]),
+			Length:    binary.LittleEndian.Uint32(buffer[16:]),
+			RunLength: 1,
+		}
+	},
+}
+
+func (s *entrySorter) add(entry spec.Entry) error {
+	if s.sorted {
+		if s.count == 0 || entry.TileCode > s.lastCode {
+			s.lastCode = entry.TileCode
+			s.count++
+			return s.directories.add(entry)
+		}
+		if err := s.fallback(); err != nil {
+			return err
+		}
+	}
+	return s.sorter.Add(entry)
+}
+
+// fallback moves entries from directories to the first run.
+func (s *entrySorter) fallback() error {
+	s.sorted = false
+
+	err := s.sorter.AddRun(func(add func(entry spec.Entry) error) error {
+		return s.directories.visit(add)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.directories.Close(); err != nil {
+		return err
+	}
+	*s.directories = *newDirectoryWriter(s.directories.compression, s.directories.tempDir)
+	return nil
+}
+
+// finish merges runs into directories, if entries were not sorted.
+func (s *entrySorter) finish() error {
+	if s.sorted {
+		return nil
+	}
+	return s.sorter.VisitLast(s.directories.add)
+}
+
+// Close removes temporary files.
+func (s *entrySorter) Close() error {
+	return s.sorter.Close()
+}
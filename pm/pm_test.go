@@ -2,14 +2,18 @@ package pm_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"maps"
+	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/eak1mov/go-libtiles/index"
 	"github.com/eak1mov/go-libtiles/internal/testdata"
 	"github.com/eak1mov/go-libtiles/pm"
+	"github.com/eak1mov/go-libtiles/pm/spec"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/google/go-cmp/cmp"
 )
@@ -125,3 +129,211 @@ func TestImport(t *testing.T) {
 		}
 	}
 }
+
+func TestImportHotPrefix(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 0, Y: 0, Z: 2, Length: 3, Offset: 5},
+	}
+
+	testDataReader := bytes.NewReader(testData)
+	testItemsVisitor := index.ItemsVisitor(testItems)
+
+	filePath := filepath.Join(t.TempDir(), "tiles.pmtiles")
+	err := pm.Import(
+		filePath,
+		testItemsVisitor,
+		testDataReader,
+		pm.WithMetadata([]byte(`{"foo":"bar"}`)),
+		pm.WithHotPrefix(4),
+	)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	reader, err := pm.NewFileReader(filePath, pm.WithPinnedHotPrefix(true))
+	if err != nil {
+		t.Fatalf("NewFileReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	hotPrefix, err := reader.HotPrefix()
+	if err != nil {
+		t.Fatalf("HotPrefix failed: %v", err)
+	}
+	if got, want := hotPrefix.Length, uint64(3); got != want {
+		t.Errorf("HotPrefix().Length = %v, want = %v", got, want)
+	}
+
+	for _, item := range testItems {
+		want := testData[item.Offset:][:item.Length]
+		got, err := reader.ReadTile(item.TileID())
+		if err != nil {
+			t.Fatalf("ReadTile(%v) failed: %v", item.TileID(), err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Fatalf("ReadTile(%v) = %s, want = %s", item.TileID(), got, want)
+		}
+	}
+}
+
+func TestMmapReader(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 0, Y: 0, Z: 2, Length: 3, Offset: 5},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "tiles.pmtiles")
+	err := pm.Import(
+		filePath,
+		index.ItemsVisitor(testItems),
+		bytes.NewReader(testData),
+		pm.WithMetadata([]byte(`{"foo":"bar"}`)),
+	)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	reader, err := pm.NewMmapReader(filePath)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("mmap is not supported")
+	}
+	if err != nil {
+		t.Fatalf("NewMmapReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	for _, item := range testItems {
+		want := testData[item.Offset:][:item.Length]
+		got, err := reader.ReadTile(item.TileID())
+		if err != nil {
+			t.Fatalf("ReadTile(%v) failed: %v", item.TileID(), err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Fatalf("ReadTile(%v) = %s, want = %s", item.TileID(), got, want)
+		}
+	}
+
+	got, err := reader.ReadTile(tile.ID{X: 1, Y: 1, Z: 1})
+	if err != nil || len(got) != 0 {
+		t.Errorf("ReadTile(missing) = %v, %v, want empty", got, err)
+	}
+}
+
+func TestReadTiles(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 0, Y: 0, Z: 2, Length: 3, Offset: 5},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "tiles.pmtiles")
+	err := pm.Import(
+		filePath,
+		index.ItemsVisitor(testItems),
+		bytes.NewReader(testData),
+		pm.WithMetadata([]byte(`{"foo":"bar"}`)),
+	)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	fileData, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	accessCount := 0
+	fileAccess := func(offset, length uint64) ([]byte, error) {
+		accessCount++
+		return fileData[offset:][:length], nil
+	}
+	reader, err := pm.NewReader(fileAccess)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+
+	tileIDs := []tile.ID{{X: 0, Y: 0, Z: 2}, {X: 1, Y: 1, Z: 1}, {X: 0, Y: 0, Z: 0}, {X: 0, Y: 0, Z: 1}}
+	want := [][]byte{[]byte("222"), {}, []byte("0"), []byte("11")}
+
+	accessCount = 0
+	got, err := reader.ReadTiles(tileIDs)
+	if err != nil {
+		t.Fatalf("ReadTiles failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReadTiles mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := accessCount, 2; got != want { // index root + tile data
+		t.Errorf("ReadTiles file accesses = %v, want = %v", got, want)
+	}
+}
+
+func TestImportStreaming(t *testing.T) {
+	// z0-z7 in TileCode order, more than one leaf directory
+	var testData []byte
+	var sortedItems []index.Item
+	for tileCode := range uint64(21845) {
+		tileID := spec.DecodeTileID(tileCode)
+		tileData := fmt.Appendf(nil, "%d/%d/%d", tileID.Z, tileID.X, tileID.Y)
+		sortedItems = append(sortedItems, index.NewItem(tileID, tile.Location{
+			Offset: uint64(len(testData)),
+			Length: uint64(len(tileData)),
+		}))
+		testData = append(testData, tileData...)
+	}
+
+	reversedItems := slices.Clone(sortedItems)
+	slices.Reverse(reversedItems)
+
+	// the first tile is written twice, the last location wins
+	overwrittenItems := slices.Concat([]index.Item{sortedItems[0]}, sortedItems)
+	overwrittenItems[0].Offset = sortedItems[1].Offset
+	overwrittenItems[0].Length = sortedItems[1].Length
+
+	for _, tc := range []struct {
+		name  string
+		items []index.Item
+	}{
+		{"Sorted", sortedItems},
+		{"Reversed", reversedItems},
+		{"Overwritten", overwrittenItems},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			filePath := filepath.Join(t.TempDir(), "tiles.pmtiles")
+			err := pm.Import(
+				filePath,
+				index.ItemsVisitor(tc.items),
+				bytes.NewReader(testData),
+				pm.WithMemoryLimit(20000), // 1000 entries per run
+				pm.WithTempDir(tempDir),
+			)
+			if err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if tempFiles, _ := os.ReadDir(tempDir); len(tempFiles) != 0 {
+				t.Errorf("temporary files are not removed: %v", tempFiles)
+			}
+
+			reader, err := pm.NewFileReader(filePath)
+			if err != nil {
+				t.Fatalf("NewFileReader failed: %v", err)
+			}
+			defer reader.Close()
+
+			want := make(map[tile.ID][]byte)
+			for _, item := range sortedItems {
+				want[item.TileID()] = testData[item.Offset:][:item.Length]
+			}
+			got := maps.Collect(tile.IterTiles(reader))
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("IterTiles mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
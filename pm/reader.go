@@ -3,6 +3,9 @@ package pm
 import (
 	"os"
 
+	"github.com/eak1mov/go-libtiles/internal/batch"
+	"github.com/eak1mov/go-libtiles/internal/hotprefix"
+	"github.com/eak1mov/go-libtiles/internal/mmap"
 	"github.com/eak1mov/go-libtiles/pm/spec"
 	"github.com/eak1mov/go-libtiles/tile"
 )
@@ -15,6 +18,7 @@ type FileAccessFunc func(offset, length uint64) ([]byte, error)
 type Reader struct {
 	fileAccess FileAccessFunc
 	header     *spec.Header
+	maxGap     uint64
 }
 
 type FileReader struct {
@@ -22,10 +26,37 @@ type FileReader struct {
 	file *os.File
 }
 
+// MmapReader is a Reader backed by a read-only memory mapping of a local file.
+type MmapReader struct {
+	Reader
+	mapping *mmap.Mapping
+}
+
+type readerConfig struct {
+	PinHotPrefix bool
+	MaxGap       uint64
+}
+
+type ReaderOption func(*readerConfig)
+
+// WithPinnedHotPrefix enables prefetching of the hot-tile prefix (see
+// WithHotPrefix) at open time. Reads inside the prefix are served from memory,
+// returned slices share this memory and must not be modified.
+func WithPinnedHotPrefix(enable bool) ReaderOption {
+	return func(c *readerConfig) { c.PinHotPrefix = enable }
+}
+
+// WithMaxGap sets the largest gap (in bytes) between byte ranges which are
+// merged into a single file access by ReadTiles. Default is 0: only adjacent
+// ranges are merged. Larger values trade extra bytes for fewer requests.
+func WithMaxGap(maxGap uint64) ReaderOption {
+	return func(c *readerConfig) { c.MaxGap = maxGap }
+}
+
 // NewFileReader opens a local PMTiles file and returns a Reader for it.
 //
 // The returned Reader must be closed after use to release file resources.
-func NewFileReader(filePath string) (*FileReader, error) {
+func NewFileReader(filePath string, opts ...ReaderOption) (*FileReader, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
@@ -37,7 +68,7 @@ func NewFileReader(filePath string) (*FileReader, error) {
 		}
 		return buffer, nil
 	}
-	reader, err := NewReader(fileAccess)
+	reader, err := NewReader(fileAccess, opts...)
 	if err != nil {
 		file.Close()
 		return nil, err
@@ -49,9 +80,38 @@ func (r *FileReader) Close() error {
 	return r.file.Close()
 }
 
+// NewMmapReader maps a local PMTiles file into memory and returns a Reader for it.
+// It is only supported on Linux.
+//
+// Tile data, metadata and index are returned as slices of the mapping without
+// copying or extra syscalls. These slices must not be modified and must not
+// be used after Close: the memory is unmapped and accessing it crashes the process.
+func NewMmapReader(filePath string, opts ...ReaderOption) (*MmapReader, error) {
+	mapping, err := mmap.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(mapping.Access, opts...)
+	if err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return &MmapReader{*reader, mapping}, nil
+}
+
+// Close unmaps the file, all slices returned by the Reader become invalid.
+func (r *MmapReader) Close() error {
+	return r.mapping.Close()
+}
+
 // NewReader creates a Reader using a custom file access function.
 // This is useful for remote or in-memory access.
-func NewReader(fileAccess FileAccessFunc) (*Reader, error) {
+func NewReader(fileAccess FileAccessFunc, opts ...ReaderOption) (*Reader, error) {
+	config := readerConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
 	headerData, err := fileAccess(0, spec.HeaderLength)
 	if err != nil {
 		return nil, err
@@ -60,7 +120,21 @@ func NewReader(fileAccess FileAccessFunc) (*Reader, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Reader{fileAccess: fileAccess, header: header}, nil
+	reader := &Reader{fileAccess: fileAccess, header: header, maxGap: config.MaxGap}
+
+	if config.PinHotPrefix {
+		hotPrefix, err := reader.HotPrefix()
+		if err != nil {
+			return nil, err
+		}
+		pinnedAccess, err := hotprefix.Pin(fileAccess, hotPrefix)
+		if err != nil {
+			return nil, err
+		}
+		reader.fileAccess = pinnedAccess
+	}
+
+	return reader, nil
 }
 
 // TODO(eak1mov): add directory cache (offset -> []Entry) and reader with cache
@@ -83,8 +157,25 @@ func (r *Reader) ReadMetadata() ([]byte, error) {
 	return spec.Decompress(metadata, r.header.InternalCompression)
 }
 
-func (r *Reader) readDirectory(dirOffset, dirLength uint64) ([]spec.Entry, error) {
-	dirCompressed, err := r.fileAccess(dirOffset, dirLength)
+// HotPrefix returns the absolute location of the hot-tile prefix: the most
+// requested tiles placed at the start of the data section (see WithHotPrefix).
+// It returns an empty location if the file has no hot prefix.
+func (r *Reader) HotPrefix() (tile.Location, error) {
+	if r.header.MetadataLength == 0 {
+		return tile.Location{}, nil
+	}
+	metadata, err := r.ReadMetadata()
+	if err != nil {
+		return tile.Location{}, err
+	}
+	return tile.Location{
+		Offset: r.header.TileDataOffset,
+		Length: min(hotprefix.ParseMetadata(metadata), r.header.TileDataLength),
+	}, nil
+}
+
+func (r *Reader) readDirectory(fileAccess FileAccessFunc, dirOffset, dirLength uint64) ([]spec.Entry, error) {
+	dirCompressed, err := fileAccess(dirOffset, dirLength)
 	if err != nil {
 		return nil, err
 	}
@@ -100,10 +191,14 @@ func (r *Reader) readDirectory(dirOffset, dirLength uint64) ([]spec.Entry, error
 }
 
 func (r *Reader) ReadLocation(tileID tile.ID) (tile.Location, error) {
+	return r.readLocation(r.fileAccess, tileID)
+}
+
+func (r *Reader) readLocation(fileAccess FileAccessFunc, tileID tile.ID) (tile.Location, error) {
 	dirOffset := r.header.RootOffset
 	dirLength := r.header.RootLength
 	for {
-		dirEntries, err := r.readDirectory(dirOffset, dirLength)
+		dirEntries, err := r.readDirectory(fileAccess, dirOffset, dirLength)
 		if err != nil {
 			return tile.Location{}, err
 		}
@@ -137,10 +232,33 @@ func (r *Reader) ReadTile(tileID tile.ID) ([]byte, error) {
 	return r.fileAccess(location.Offset, location.Length)
 }
 
+// ReadLocations returns locations of multiple tiles. Directories are fetched
+// once per batch, nearby directories are fetched together (see WithMaxGap).
+func (r *Reader) ReadLocations(tileIDs []tile.ID) ([]tile.Location, error) {
+	query := func(i int, fileAccess batch.FileAccessFunc) (tile.Location, error) {
+		return r.readLocation(fileAccess, tileIDs[i])
+	}
+	return batch.Resolve(len(tileIDs), query, r.fileAccess, r.maxGap)
+}
+
+// ReadTiles reads multiple tiles with as few file accesses as possible: all
+// locations are resolved first (see ReadLocations), then adjacent or nearby
+// tiles are fetched with a single file access (see WithMaxGap).
+//
+// The result is aligned with tileIDs, missing tiles are empty slices.
+// Returned slices may share memory and must not be modified.
+func (r *Reader) ReadTiles(tileIDs []tile.ID) ([][]byte, error) {
+	locations, err := r.ReadLocations(tileIDs)
+	if err != nil {
+		return nil, err
+	}
+	return batch.ReadRanges(r.fileAccess, locations, r.maxGap)
+}
+
 func (r *Reader) VisitLocations(fn tile.LocationVisitFunc) error {
 	var traverse func(uint64, uint64) error
 	traverse = func(dirOffset, dirLength uint64) error {
-		dirEntries, err := r.readDirectory(dirOffset, dirLength)
+		dirEntries, err := r.readDirectory(r.fileAccess, dirOffset, dirLength)
 		if err != nil {
 			return err
 		}
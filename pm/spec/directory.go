@@ -1,8 +1,8 @@
 package spec
 
 import (
-	"bytes"
 	"encoding/binary"
+	"io"
 	"math"
 	"slices"
 	"sort"
@@ -47,20 +47,32 @@ func SerializeDirectory(entries []Entry) []byte {
 	return buffer
 }
 
+// DeserializeDirectory decodes directory entries directly from data,
+// without intermediate buffers.
 func DeserializeDirectory(data []byte) ([]Entry, error) {
-	byteReader := bytes.NewReader(data)
-
 	var err error
 	readUvarint := func() uint64 {
 		if err != nil {
 			return 0
 		}
-		var value uint64
-		value, err = binary.ReadUvarint(byteReader)
+		value, n := binary.Uvarint(data)
+		switch {
+		case n == 0 && len(data) == 0:
+			err = io.EOF
+		case n == 0:
+			err = io.ErrUnexpectedEOF
+		case n < 0:
+			err = ErrInvalidDirectory
+		default:
+			data = data[n:]
+		}
 		return value
 	}
 
 	numEntries := readUvarint()
+	if numEntries > uint64(len(data))/4 {
+		return nil, ErrInvalidDirectory // each entry takes at least 4 bytes
+	}
 	entries := make([]Entry, numEntries)
 
 	lastCode := uint64(0)
@@ -74,8 +74,9 @@ const (
 )
 
 const (
-	ErrInvalidHeader  tile.Error = "libtiles: invalid file header"
-	ErrInvalidVersion tile.Error = "libtiles: invalid version"
+	ErrInvalidHeader    tile.Error = "libtiles: invalid file header"
+	ErrInvalidVersion   tile.Error = "libtiles: invalid version"
+	ErrInvalidDirectory tile.Error = "libtiles: invalid directory"
 )
 
 func SerializeHeader(header *Header) []byte {
@@ -12,6 +12,7 @@ import (
 	"slices"
 
 	"github.com/eak1mov/go-libtiles/internal/copier"
+	"github.com/eak1mov/go-libtiles/internal/hotprefix"
 	"github.com/eak1mov/go-libtiles/pm/spec"
 	"github.com/eak1mov/go-libtiles/tile"
 )
@@ -27,11 +28,15 @@ type Writer struct {
 
 	entries   []spec.Entry
 	locations map[[16]byte]uint32 // hash -> entry index
+	contents  map[uint64]uint32   // content id -> entry index, see WriteContent
 }
 
 type writerConfig struct {
 	Metadata       []byte
 	HeaderMetadata HeaderMetadata
+	HotPrefix      uint64
+	MemoryLimit    uint64
+	TempDir        string
 	Logger         *log.Logger
 }
 
@@ -45,6 +50,29 @@ func WithMetadata(metadata []byte) WriterOption {
 	return func(c *writerConfig) { c.Metadata = metadata }
 }
 
+// WithHotPrefix sets maximum length of the hot-tile prefix for Import: tiles
+// from the beginning of the index (the most requested ones) are placed in one
+// contiguous region at the start of the data section, and its actual length is
+// recorded in the JSON metadata (see Reader.HotPrefix). The length is added to
+// the metadata object keeping its other keys as is, metadata which isn't a JSON
+// object is left unchanged, and readers don't see the prefix then.
+func WithHotPrefix(maxLength uint64) WriterOption {
+	return func(c *writerConfig) { c.HotPrefix = maxLength }
+}
+
+// WithMemoryLimit sets the approximate memory limit in bytes for index entries
+// of Import (1 GiB by default), used only if the index is not sorted by
+// TileCode. Above the limit, sorted runs of entries are spilled to temporary
+// files.
+func WithMemoryLimit(memoryLimit uint64) WriterOption {
+	return func(c *writerConfig) { c.MemoryLimit = memoryLimit }
+}
+
+// WithTempDir sets the directory for temporary files (os.TempDir by default).
+func WithTempDir(tempDir string) WriterOption {
+	return func(c *writerConfig) { c.TempDir = tempDir }
+}
+
 // WithLogger sets custom logger, otherwise log messages are discarded.
 func WithLogger(logger *log.Logger) WriterOption {
 	return func(c *writerConfig) { c.Logger = logger }
@@ -108,6 +136,7 @@ func NewWriter(filePath string, opts ...WriterOption) (*Writer, error) {
 		tileWriter: bufio.NewWriter(file),
 		tileOffset: 0,
 		locations:  make(map[[16]byte]uint32),
+		contents:   make(map[uint64]uint32),
 	}, nil
 }
 
@@ -129,7 +158,35 @@ func (w *Writer) WriteTile(tileID tile.ID, tileData []byte) error {
 
 	digest := md5.Sum(tileData)
 	entryIdx, exists := w.locations[digest]
+	if !exists {
+		w.locations[digest] = uint32(len(w.entries))
+	}
+
+	return w.writeEntry(tileID, tileData, entryIdx, exists)
+}
+
+// WriteContent writes a single tile like WriteTile, deduplicating tiles by
+// contentID instead of hashing (see tile.ContentWriter).
+func (w *Writer) WriteContent(tileID tile.ID, contentID uint64, tileData []byte) error {
+	if w.tileWriter == nil {
+		return fmt.Errorf("libtiles: write called after finalize")
+	}
 
+	if len(tileData) == 0 {
+		return nil
+	}
+
+	entryIdx, exists := w.contents[contentID]
+	if !exists {
+		w.contents[contentID] = uint32(len(w.entries))
+	}
+
+	return w.writeEntry(tileID, tileData, entryIdx, exists)
+}
+
+// writeEntry appends an entry of the tile, which refers to data of the entry
+// entryIdx if exists, or to the written tileData otherwise.
+func (w *Writer) writeEntry(tileID tile.ID, tileData []byte, entryIdx uint32, exists bool) error {
 	if exists {
 		entry := spec.Entry{
 			TileCode:  spec.EncodeTileID(tileID),
@@ -154,7 +211,6 @@ func (w *Writer) WriteTile(tileID tile.ID, tileData []byte) error {
 
 	w.tileOffset += uint64(len(tileData))
 
-	w.locations[digest] = uint32(len(w.entries))
 	w.entries = append(w.entries, entry)
 
 	return nil
@@ -227,6 +283,31 @@ func (w *Writer) Finalize() error {
 	return nil
 }
 
+// importBufferSize is the size of the tile data buffer of Import, if the size
+// of tile data is unknown (see dataSize).
+const importBufferSize = 256 << 20
+
+// dataSize returns the size of tile data, if the reader provides it.
+func dataSize(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }: // bytes.Reader, io.SectionReader
+		return r.Size(), true
+	case interface{ Stat() (os.FileInfo, error) }: // os.File
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size(), true
+		}
+	}
+	return 0, false
+}
+
+// Import creates a new PMTiles tileset at the specified filePath using
+// provided tile index and metadata, copying tile data from tileDataReader in
+// the order of the index.
+//
+// The index is processed in a single pass: if it is sorted by TileCode (see
+// spec.EncodeTileID), leaf directories are built and written incrementally,
+// so memory usage does not depend on the size of the index. Otherwise, entries
+// are sorted externally (see WithMemoryLimit and WithTempDir).
 func Import(filePath string, tileIndex tile.LocationVisitor, tileDataReader io.ReaderAt, opts ...WriterOption) error {
 	cfg := writerConfig{
 		Logger: log.New(io.Discard, "", log.LstdFlags),
@@ -235,121 +316,116 @@ func Import(filePath string, tileIndex tile.LocationVisitor, tileDataReader io.R
 		opt(&cfg)
 	}
 
-	cfg.Logger.Println("libtiles: prepare entries")
-	var dataLocations []tile.Location
+	cfg.Logger.Println("libtiles: create file")
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := spec.Header{
+		HeaderMagic:         spec.HeaderMagicV3,
+		Clustered:           true,
+		InternalCompression: spec.CompressionGzip,
+	}
+	cfg.HeaderMetadata.CopyToHeader(&header)
+
+	header.TileDataOffset = uint64(spec.HeaderRootDirMaxLength)
+	if _, err := file.Seek(int64(header.TileDataOffset), io.SeekStart); err != nil {
+		return err
+	}
+
+	directories := newDirectoryWriter(header.InternalCompression, cfg.TempDir)
+	defer directories.Close()
+	sorter := newEntrySorter(directories, cfg.MemoryLimit, cfg.TempDir)
+	defer sorter.Close()
+
+	bufferSize := importBufferSize
+	if size, ok := dataSize(tileDataReader); ok {
+		bufferSize = int(min(copier.DefaultBufferSize, size))
+	}
+	c := copier.New(copier.BufferSize(bufferSize))
+	tileQueue := c.NewQueue(context.Background(), file, tileDataReader)
+
+	cfg.Logger.Println("libtiles: write tiles")
 	var dataLength uint64
-	var entries []spec.Entry
+	var hotPrefixLength uint64
+	hotPrefixDone := false
 
 	var lastOldOffset uint64
 	var lastNewOffset uint64
 	isFirst := true
 
-	err := tileIndex.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+	err = tileIndex.VisitLocations(func(tileID tile.ID, location tile.Location) error {
 		newOffset := lastNewOffset
 		if isFirst || location.Offset != lastOldOffset {
 			newOffset = dataLength
-			dataLocations = append(dataLocations, location)
+			if err := tileQueue.Add(location); err != nil {
+				return err
+			}
 			dataLength += location.Length
 
+			if !hotPrefixDone && hotPrefixLength+location.Length <= cfg.HotPrefix {
+				hotPrefixLength += location.Length
+			} else {
+				hotPrefixDone = true
+			}
+
 			lastOldOffset = location.Offset
 			lastNewOffset = newOffset
 			isFirst = false
 		}
-		entries = append(entries, spec.Entry{
+		return sorter.add(spec.Entry{
 			TileCode:  spec.EncodeTileID(tileID),
 			Offset:    newOffset,
 			Length:    uint32(location.Length),
 			RunLength: 1,
 		})
-		return nil
 	})
 	if err != nil {
 		return err
 	}
-
-	cfg.Logger.Println("libtiles: sort entries")
-	slices.SortFunc(entries, func(a, b spec.Entry) int {
-		return cmp.Compare(a.TileCode, b.TileCode)
-	})
-
-	cfg.Logger.Println("libtiles: compact entries")
-	entries = spec.CompactEntries(entries)
-
-	cfg.Logger.Println("libtiles: serialize entries")
-	rootBytes, leavesBytes := spec.SerializeAll(entries, spec.CompressionGzip)
-
-	cfg.Logger.Println("libtiles: prepare metadata")
-	var metadata []byte
-	if cfg.Metadata != nil {
-		metadata, _ = spec.Compress(cfg.Metadata, spec.CompressionGzip)
-	}
-
-	cfg.Logger.Println("libtiles: prepare header")
-	header := spec.Header{
-		HeaderMagic:         spec.HeaderMagicV3,
-		Clustered:           true,
-		InternalCompression: spec.CompressionGzip,
-	}
-	cfg.HeaderMetadata.CopyToHeader(&header)
-
-	offset := uint64(spec.RootDirOffset)
-
-	header.RootOffset = offset
-	header.RootLength = uint64(len(rootBytes))
-	offset += header.RootLength
-
-	header.LeafDirectoryOffset = offset
-	header.LeafDirectoryLength = uint64(len(leavesBytes))
-	offset += header.LeafDirectoryLength
-
-	if metadata != nil {
-		header.MetadataOffset = offset
-		header.MetadataLength = uint64(len(metadata))
-		offset += header.MetadataLength
-	}
-
-	header.TileDataOffset = offset
-	header.TileDataLength = dataLength
-	offset += header.TileDataLength
-
-	headerData := spec.SerializeHeader(&header)
-
-	cfg.Logger.Println("libtiles: create file")
-	file, err := os.Create(filePath)
-	if err != nil {
+	if err := tileQueue.Flush(); err != nil {
 		return err
 	}
-	defer file.Close()
+	header.TileDataLength = dataLength
 
-	if err := file.Truncate(int64(offset)); err != nil {
+	cfg.Logger.Println("libtiles: write leaves")
+	if err := sorter.finish(); err != nil {
 		return err
 	}
-
-	cfg.Logger.Println("libtiles: write header")
-	if _, err := file.Write(headerData); err != nil {
+	rootBytes, err := directories.finish()
+	if err != nil {
 		return err
 	}
-
-	cfg.Logger.Println("libtiles: write root")
-	if _, err := file.Write(rootBytes); err != nil {
-		return err
-	}
-
-	cfg.Logger.Println("libtiles: write leaves")
-	if _, err := file.Write(leavesBytes); err != nil {
+	header.LeafDirectoryOffset = header.TileDataOffset + header.TileDataLength
+	header.LeafDirectoryLength = directories.leavesLength
+	if err := directories.writeLeaves(file); err != nil {
 		return err
 	}
 
 	cfg.Logger.Println("libtiles: write metadata")
-	if metadata != nil {
+	if cfg.HotPrefix > 0 {
+		cfg.Metadata = hotprefix.SetMetadata(cfg.Metadata, hotPrefixLength)
+	}
+	if cfg.Metadata != nil {
+		metadata, _ := spec.Compress(cfg.Metadata, header.InternalCompression)
 		if _, err := file.Write(metadata); err != nil {
 			return err
 		}
+		header.MetadataOffset = header.LeafDirectoryOffset + header.LeafDirectoryLength
+		header.MetadataLength = uint64(len(metadata))
 	}
 
-	cfg.Logger.Println("libtiles: write tiles")
-	c := copier.New(copier.BufferSize(min(copier.DefaultBufferSize, int(dataLength))))
-	if err := c.Copy(context.Background(), file, tileDataReader, dataLocations); err != nil {
+	cfg.Logger.Println("libtiles: write root")
+	if _, err := file.WriteAt(rootBytes, spec.RootDirOffset); err != nil {
+		return err
+	}
+	header.RootOffset = spec.RootDirOffset
+	header.RootLength = uint64(len(rootBytes))
+
+	cfg.Logger.Println("libtiles: write header")
+	if _, err := file.WriteAt(spec.SerializeHeader(&header), 0); err != nil {
 		return err
 	}
 
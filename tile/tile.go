@@ -1,6 +1,8 @@
 // Package tile provides common tile interfaces and types.
 package tile
 
+import "math"
+
 // ID represents tile coordinates in the XYZ scheme (Tiled web map).
 type ID struct {
 	X uint32
@@ -12,6 +14,52 @@ func (t ID) Valid() bool {
 	return t.Z < 32 && t.X < (1<<t.Z) && t.Y < (1<<t.Z)
 }
 
+// Bounds is an inclusive range of tiles at zoom level Z. At other zoom levels
+// it covers the tiles intersecting the same area (see At).
+type Bounds struct {
+	Z    uint32
+	MinX uint32
+	MinY uint32
+	MaxX uint32
+	MaxY uint32
+}
+
+// At returns the bounds scaled to zoom level z.
+func (b Bounds) At(z uint32) Bounds {
+	if z >= b.Z {
+		// saturated to the max uint32, e.g. for zoom levels above 31
+		shift := min(z-b.Z, 32)
+		limit := uint64(1) << 32 >> shift // v<<shift overflows uint32 if v >= limit
+		scaleMin := func(v uint32) uint32 {
+			if uint64(v) >= limit {
+				return math.MaxUint32
+			}
+			return uint32(uint64(v) << shift)
+		}
+		scaleMax := func(v uint32) uint32 {
+			if uint64(v)+1 > limit {
+				return math.MaxUint32
+			}
+			return uint32((uint64(v)+1)<<shift - 1)
+		}
+		return Bounds{
+			Z:    z,
+			MinX: scaleMin(b.MinX),
+			MinY: scaleMin(b.MinY),
+			MaxX: scaleMax(b.MaxX),
+			MaxY: scaleMax(b.MaxY),
+		}
+	}
+	shift := b.Z - z
+	return Bounds{Z: z, MinX: b.MinX >> shift, MinY: b.MinY >> shift, MaxX: b.MaxX >> shift, MaxY: b.MaxY >> shift}
+}
+
+// Contains reports whether the tile is inside the bounds scaled to its zoom level.
+func (b Bounds) Contains(tileID ID) bool {
+	s := b.At(tileID.Z)
+	return tileID.X >= s.MinX && tileID.X <= s.MaxX && tileID.Y >= s.MinY && tileID.Y <= s.MaxY
+}
+
 // Writer defines an interface for writing tiles to a tileset.
 type Writer interface {
 	// WriteTile writes a single tile to the tileset.
@@ -39,6 +87,24 @@ type Visitor interface {
 // VisitFunc is a callback function for processing a single tile.
 type VisitFunc func(tileID ID, tileData []byte) error
 
+// ContentVisitor is implemented by tilesets which identify tile data without
+// hashing it, e.g. deduplicated MBTiles files.
+type ContentVisitor interface {
+	// VisitContents visits all tiles like VisitTiles, also passing an
+	// identifier of tile data. Tiles with equal contentID have equal data.
+	VisitContents(fn ContentVisitFunc) error
+}
+
+type ContentVisitFunc func(tileID ID, contentID uint64, tileData []byte) error
+
+// ContentWriter is implemented by writers which can deduplicate tiles by
+// identifiers of their data (see ContentVisitor) instead of hashing it.
+type ContentWriter interface {
+	// WriteContent writes a single tile like WriteTile. Tiles written with
+	// equal contentID must have equal data.
+	WriteContent(tileID ID, contentID uint64, tileData []byte) error
+}
+
 // Location represents the absolute location of tile data inside a tileset file.
 type Location struct {
 	Offset uint64
@@ -0,0 +1,56 @@
+package tile_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBoundsAt(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		bounds tile.Bounds
+		z      uint32
+		want   tile.Bounds
+	}{
+		{"Same", tile.Bounds{Z: 3, MinX: 1, MinY: 2, MaxX: 4, MaxY: 5}, 3, tile.Bounds{Z: 3, MinX: 1, MinY: 2, MaxX: 4, MaxY: 5}},
+		{"Up", tile.Bounds{Z: 3, MinX: 1, MinY: 2, MaxX: 4, MaxY: 5}, 5, tile.Bounds{Z: 5, MinX: 4, MinY: 8, MaxX: 19, MaxY: 23}},
+		{"Down", tile.Bounds{Z: 3, MinX: 1, MinY: 2, MaxX: 4, MaxY: 5}, 1, tile.Bounds{Z: 1, MinX: 0, MinY: 0, MaxX: 1, MaxY: 1}},
+		{"World31", tile.Bounds{Z: 0}, 31, tile.Bounds{Z: 31, MaxX: 1<<31 - 1, MaxY: 1<<31 - 1}},
+		{"Edge31", tile.Bounds{Z: 3, MinX: 7, MinY: 7, MaxX: 7, MaxY: 7}, 31, tile.Bounds{Z: 31, MinX: 7 << 28, MinY: 7 << 28, MaxX: 1<<31 - 1, MaxY: 1<<31 - 1}},
+		{"World32", tile.Bounds{Z: 0}, 32, tile.Bounds{Z: 32, MaxX: math.MaxUint32, MaxY: math.MaxUint32}},
+		{"Edge32", tile.Bounds{Z: 1, MinX: 1, MinY: 0, MaxX: 1, MaxY: 1}, 32, tile.Bounds{Z: 32, MinX: 1 << 31, MaxX: math.MaxUint32, MaxY: math.MaxUint32}},
+		{"Overflow", tile.Bounds{Z: 30, MinX: 1 << 29, MaxX: 1<<30 - 1, MaxY: 0}, 40, tile.Bounds{Z: 40, MinX: math.MaxUint32, MaxX: math.MaxUint32, MaxY: 1<<10 - 1}},
+	} {
+		if diff := cmp.Diff(tc.want, tc.bounds.At(tc.z)); diff != "" {
+			t.Errorf("%s: At(%v) mismatch (-want +got):\n%s", tc.name, tc.z, diff)
+		}
+	}
+}
+
+func TestBoundsContains(t *testing.T) {
+	bounds := tile.Bounds{Z: 3, MinX: 1, MinY: 2, MaxX: 4, MaxY: 5}
+	for _, tc := range []struct {
+		tileID tile.ID
+		want   bool
+	}{
+		{tile.ID{X: 1, Y: 2, Z: 3}, true},
+		{tile.ID{X: 4, Y: 5, Z: 3}, true},
+		{tile.ID{X: 0, Y: 2, Z: 3}, false},
+		{tile.ID{X: 4, Y: 6, Z: 3}, false},
+		{tile.ID{X: 0, Y: 0, Z: 0}, true},
+		{tile.ID{X: 0, Y: 1, Z: 1}, true},
+		{tile.ID{X: 3, Y: 1, Z: 2}, false},
+		{tile.ID{X: 19, Y: 23, Z: 5}, true},
+		{tile.ID{X: 20, Y: 23, Z: 5}, false},
+		{tile.ID{X: 5<<28 - 1, Y: 6<<28 - 1, Z: 31}, true},
+		{tile.ID{X: 5 << 28, Y: 6<<28 - 1, Z: 31}, false},
+		{tile.ID{X: 1 << 28, Y: 2<<28 - 1, Z: 31}, false},
+	} {
+		if got := bounds.Contains(tc.tileID); got != tc.want {
+			t.Errorf("Contains(%v) = %v, want = %v", tc.tileID, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package wt
+
+import (
+	"container/list"
+	"sync"
+)
+
+// indexCache is a cache of index blocks keyed by offset inside the index
+// section. Blocks are evicted in LRU order when the total size exceeds the
+// capacity, pinned blocks are never evicted and do not count towards it.
+// It is safe for concurrent use.
+type indexCache struct {
+	mu       sync.Mutex
+	capacity uint64
+	size     uint64
+	lru      *list.List // front = most recently used *cacheEntry
+	items    map[uint64]*list.Element
+	pinned   map[uint64][]byte
+}
+
+type cacheEntry struct {
+	offset uint64
+	data   []byte
+}
+
+func newIndexCache(capacity uint64) *indexCache {
+	return &indexCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[uint64]*list.Element),
+		pinned:   make(map[uint64][]byte),
+	}
+}
+
+func (c *indexCache) get(offset, length uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if data, found := c.pinned[offset]; found && uint64(len(data)) == length {
+		return data, true
+	}
+	if elem, found := c.items[offset]; found {
+		entry := elem.Value.(*cacheEntry)
+		if uint64(len(entry.data)) == length {
+			c.lru.MoveToFront(elem)
+			return entry.data, true
+		}
+	}
+	return nil, false
+}
+
+func (c *indexCache) add(offset uint64, data []byte) {
+	length := uint64(len(data))
+	if length > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, found := c.pinned[offset]; found {
+		return
+	}
+	if elem, found := c.items[offset]; found {
+		entry := elem.Value.(*cacheEntry)
+		c.size -= uint64(len(entry.data))
+		entry.data = data
+		c.size += length
+		c.lru.MoveToFront(elem)
+	} else {
+		c.items[offset] = c.lru.PushFront(&cacheEntry{offset: offset, data: data})
+		c.size += length
+	}
+
+	for c.size > c.capacity {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.items, entry.offset)
+		c.size -= uint64(len(entry.data))
+	}
+}
+
+func (c *indexCache) pin(offset uint64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, found := c.items[offset]; found {
+		c.lru.Remove(elem)
+		delete(c.items, offset)
+		c.size -= uint64(len(elem.Value.(*cacheEntry).data))
+	}
+	c.pinned[offset] = data
+}
@@ -60,6 +60,20 @@ func (m LevelsMask) Ranges() iter.Seq[ZoomRange] {
 	}
 }
 
+// Clip returns the mask for an index with tiles up to maxZoom: level starts
+// above maxZoom are dropped, the last level ends at maxZoom+1.
+func (m LevelsMask) Clip(maxZoom uint32) LevelsMask {
+	end := LevelsMask(1) << (maxZoom + 1)
+	return m&(end-1) | end
+}
+
+// Valid reports whether the mask describes block levels of an index with
+// tiles up to maxZoom: the first level starts at zoom 0, the last one ends
+// at maxZoom+1.
+func (m LevelsMask) Valid(maxZoom uint32) bool {
+	return maxZoom < 31 && m&1 == 1 && m>>(maxZoom+1) == 1
+}
+
 func (m LevelsMask) FindRange(zoom uint32) ZoomRange {
 	for r := range m.Ranges() {
 		if r.Start <= zoom && zoom < r.End() {
@@ -43,3 +43,46 @@ func TestLevelsMaskFindRange(t *testing.T) {
 		}
 	}
 }
+
+func TestLevelsMaskClip(t *testing.T) {
+	testCases := []struct {
+		Levels  []uint32
+		MaxZoom uint32
+		Want    []uint32
+	}{
+		{Levels: []uint32{0, 6, 12}, MaxZoom: 14, Want: []uint32{0, 6, 12, 15}},
+		{Levels: []uint32{0, 6, 12}, MaxZoom: 11, Want: []uint32{0, 6, 12}},
+		{Levels: []uint32{0, 6, 12}, MaxZoom: 8, Want: []uint32{0, 6, 9}},
+		{Levels: []uint32{0, 6, 12}, MaxZoom: 5, Want: []uint32{0, 6}},
+	}
+	for _, tc := range testCases {
+		got := block.NewLevelsMask(tc.Levels...).Clip(tc.MaxZoom)
+		if want := block.NewLevelsMask(tc.Want...); got != want {
+			t.Errorf("Clip(%v, %v) = %b, want = %b", tc.Levels, tc.MaxZoom, got, want)
+		}
+		if !got.Valid(tc.MaxZoom) {
+			t.Errorf("Clip(%v, %v).Valid() = false, want = true", tc.Levels, tc.MaxZoom)
+		}
+	}
+}
+
+func TestLevelsMaskValid(t *testing.T) {
+	testCases := []struct {
+		Levels  []uint32
+		MaxZoom uint32
+		Want    bool
+	}{
+		{Levels: []uint32{0, 15}, MaxZoom: 14, Want: true},
+		{Levels: []uint32{0, 5, 10, 15}, MaxZoom: 14, Want: true},
+		{Levels: []uint32{5, 15}, MaxZoom: 14, Want: false},
+		{Levels: []uint32{0, 14}, MaxZoom: 14, Want: false},
+		{Levels: []uint32{0, 15, 16}, MaxZoom: 14, Want: false},
+		{Levels: []uint32{0}, MaxZoom: 14, Want: false},
+	}
+	for _, tc := range testCases {
+		mask := block.NewLevelsMask(tc.Levels...)
+		if got := mask.Valid(tc.MaxZoom); got != tc.Want {
+			t.Errorf("Valid(%v, %v) = %v, want = %v", tc.Levels, tc.MaxZoom, got, tc.Want)
+		}
+	}
+}
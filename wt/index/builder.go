@@ -0,0 +1,77 @@
+package index
+
+import (
+	"encoding/binary"
+
+	"github.com/eak1mov/go-libtiles/internal/extsort"
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/eak1mov/go-libtiles/wt/index/packed"
+)
+
+// DefaultMemoryLimit is the default size of the in-memory buffer of Builder.
+const DefaultMemoryLimit = 1 << 30
+
+// Builder accumulates index entries in compact form (16 bytes per tile)
+// and produces a Stream. When the in-memory buffer exceeds the memory limit,
+// it is sorted and spilled to a temporary file, runs are merged on Visit.
+//
+// If a tile is added several times, the last location wins (as in Map).
+// Close must be called to remove temporary files.
+type Builder struct {
+	sorter  *extsort.Sorter[Entry]
+	maxZoom uint32
+}
+
+// NewBuilder creates a Builder with memoryLimit bytes of in-memory buffer
+// (DefaultMemoryLimit if zero), temporary files are created in tempDir
+// (os.TempDir if empty).
+func NewBuilder(memoryLimit uint64, tempDir string) *Builder {
+	if memoryLimit == 0 {
+		memoryLimit = DefaultMemoryLimit
+	}
+	return &Builder{
+		sorter: extsort.New(compareEntries, entryCodec, memoryLimit, tempDir),
+	}
+}
+
+var entryCodec = extsort.Codec[Entry]{
+	Size: 16,
+	Encode: func(buffer []byte, entry Entry) {
+		binary.LittleEndian.PutUint64(buffer[0:], entry.Key)
+		binary.LittleEndian.PutUint64(buffer[8:], uint64(entry.Location))
+	},
+	Decode: func(buffer []byte) Entry {
+		return Entry{
+			Key:      binary.LittleEndian.Uint64(buffer[0:]),
+			Location: packed.Location(binary.LittleEndian.Uint64(buffer[8:])),
+		}
+	},
+}
+
+func (b *Builder) Add(tileID tile.ID, location packed.Location) error {
+	if tileID.Z > maxKeyZoom {
+		return ErrInvalidIndex
+	}
+	if err := b.sorter.Add(Entry{Key: PyramidKey(tileID), Location: location}); err != nil {
+		return err
+	}
+	b.maxZoom = max(b.maxZoom, tileID.Z)
+	return nil
+}
+
+func (b *Builder) MaxZoom() uint32 {
+	return b.maxZoom
+}
+
+// Visit calls fn for all entries in pyramid order. No entries can be added
+// after Visit.
+func (b *Builder) Visit(fn VisitFunc) error {
+	return b.sorter.VisitLast(func(entry Entry) error {
+		return fn(DecodePyramidKey(entry.Key), entry.Location)
+	})
+}
+
+// Close removes temporary files.
+func (b *Builder) Close() error {
+	return b.sorter.Close()
+}
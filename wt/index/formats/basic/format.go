@@ -2,10 +2,10 @@
 package basic
 
 import (
+	"github.com/eak1mov/go-libtiles/internal/morton"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt/fbs"
 	"github.com/eak1mov/go-libtiles/wt/index"
-	"github.com/eak1mov/go-libtiles/wt/index/internal/morton"
 	"github.com/eak1mov/go-libtiles/wt/index/packed"
 )
 
@@ -42,9 +42,14 @@ func Query(header *fbs.IndexHeader, tileID tile.ID, indexAccess index.FileAccess
 }
 
 func Write(header *fbs.IndexHeader, indexMap index.Map) ([]byte, error) {
-	maxZoom := uint32(0)
-	for tileID := range indexMap {
-		maxZoom = max(maxZoom, tileID.Z)
+	return WriteStream(header, indexMap.Sorted())
+}
+
+// WriteStream is like Write, but consumes entries from a stream.
+func WriteStream(header *fbs.IndexHeader, entries index.Stream) ([]byte, error) {
+	maxZoom := entries.MaxZoom()
+	if maxZoom > MaxZoom {
+		return nil, index.ErrInvalidIndex
 	}
 
 	header.MutateMagic(fbs.IndexMagicValue)
@@ -54,10 +59,14 @@ func Write(header *fbs.IndexHeader, indexMap index.Map) ([]byte, error) {
 
 	result := make([]byte, packed.LocationLength*Size(maxZoom+1))
 
-	for tileID, tileLocation := range indexMap {
+	err := entries.Visit(func(tileID tile.ID, tileLocation packed.Location) error {
 		location := QueryLocation(tileID)
 		locationData := result[location.Offset:][:location.Length]
 		packed.Write(locationData, tileLocation)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
@@ -2,16 +2,55 @@
 package plain
 
 import (
+	"github.com/eak1mov/go-libtiles/internal/morton"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt/fbs"
 	"github.com/eak1mov/go-libtiles/wt/index"
 	"github.com/eak1mov/go-libtiles/wt/index/block"
-	"github.com/eak1mov/go-libtiles/wt/index/internal/morton"
 	"github.com/eak1mov/go-libtiles/wt/index/packed"
 )
 
+// MaxZoom is the max zoom relative to the region root (see Region).
 const MaxZoom = 15
 
+const ErrOutsideRegion tile.Error = "libtiles: tile is outside of the index region"
+
+// Region returns the root tile of the sub-pyramid covered by the index, it is
+// stored in Reserved1 (zoom), Reserved2 (x) and Reserved3 (y) fields of the
+// header. Zero tile (the default) means the whole world.
+func Region(header *fbs.IndexHeader) tile.ID {
+	return tile.ID{
+		X: uint32(header.Reserved2()),
+		Y: uint32(header.Reserved3()),
+		Z: uint32(header.Reserved1()),
+	}
+}
+
+// RegionTile returns tileID relative to the region root, or false if the
+// tile is outside of the region.
+func RegionTile(root, tileID tile.ID) (tile.ID, bool) {
+	if tileID.Z < root.Z {
+		return tile.ID{}, false
+	}
+	zDiff := tileID.Z - root.Z
+	if tileID.X>>zDiff != root.X || tileID.Y>>zDiff != root.Y {
+		return tile.ID{}, false
+	}
+	return tile.ID{
+		X: tileID.X & (1<<zDiff - 1),
+		Y: tileID.Y & (1<<zDiff - 1),
+		Z: zDiff,
+	}, true
+}
+
+func fromRegionTile(root, regionTileID tile.ID) tile.ID {
+	return tile.ID{
+		X: root.X<<regionTileID.Z | regionTileID.X,
+		Y: root.Y<<regionTileID.Z | regionTileID.Y,
+		Z: root.Z + regionTileID.Z,
+	}
+}
+
 func calcBlockLength(zoomCount uint32) uint64 {
 	// = 4^0 + 4^1 + ... + 4^(zoomCount-1)
 	return ((1 << (2 * zoomCount)) - 1) / 3
@@ -61,6 +100,11 @@ func Query(header *fbs.IndexHeader, tileID tile.ID, indexAccess index.FileAccess
 		return tile.Location{}, nil
 	}
 
+	tileID, inRegion := RegionTile(Region(header), tileID)
+	if !inRegion {
+		return tile.Location{}, nil
+	}
+
 	blockLevels := block.LevelsMask(header.BlockLevelsMask())
 	blockRange := blockLevels.FindRange(tileID.Z)
 	location := QueryBlock(tileID, blockRange)
@@ -77,9 +121,28 @@ func Query(header *fbs.IndexHeader, tileID tile.ID, indexAccess index.FileAccess
 }
 
 func Write(header *fbs.IndexHeader, indexMap index.Map) ([]byte, error) {
-	maxZoom := uint32(0)
-	for tileID := range indexMap {
-		maxZoom = max(maxZoom, tileID.Z)
+	return WriteRegion(header, indexMap, tile.ID{})
+}
+
+// WriteRegion is like Write, but the index covers only the sub-pyramid rooted
+// at the given tile, up to MaxZoom levels below it. Block levels in the header
+// are relative to the root zoom, while MaxZoom of the header is absolute.
+func WriteRegion(header *fbs.IndexHeader, indexMap index.Map, root tile.ID) ([]byte, error) {
+	return WriteStream(header, indexMap.Sorted(), root)
+}
+
+// WriteStream is like WriteRegion, but consumes entries from a stream.
+func WriteStream(header *fbs.IndexHeader, entries index.Stream, root tile.ID) ([]byte, error) {
+	if !root.Valid() {
+		return nil, ErrOutsideRegion
+	}
+
+	maxZoom := uint32(0) // relative to the root
+	if entries.MaxZoom() > root.Z {
+		maxZoom = entries.MaxZoom() - root.Z
+	}
+	if maxZoom > MaxZoom {
+		return nil, ErrOutsideRegion
 	}
 
 	var blockLevels block.LevelsMask
@@ -91,25 +154,40 @@ func Write(header *fbs.IndexHeader, indexMap index.Map) ([]byte, error) {
 
 	header.MutateMagic(fbs.IndexMagicValue)
 	header.MutateFormat(fbs.IndexFormatPlain)
-	header.MutateMaxZoom(uint64(maxZoom))
+	header.MutateMaxZoom(uint64(root.Z + maxZoom))
 	header.MutateBlockLevelsMask(uint64(blockLevels))
+	header.MutateReserved1(uint64(root.Z))
+	header.MutateReserved2(uint64(root.X))
+	header.MutateReserved3(uint64(root.Y))
 
 	result := make([]byte, packed.LocationLength*calcBlockLength(maxZoom+1))
 
-	for tileID, tileLocation := range indexMap {
+	err := entries.Visit(func(tileID tile.ID, tileLocation packed.Location) error {
+		tileID, inRegion := RegionTile(root, tileID)
+		if !inRegion {
+			return ErrOutsideRegion
+		}
 		location := QueryBlock(tileID, blockLevels.FindRange(tileID.Z))
 		locationOffset := location.Block.Offset + location.Inner.Offset
 		locationLength := location.Inner.Length
 		locationData := result[locationOffset:][:locationLength]
 
 		packed.Write(locationData, tileLocation)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
 }
 
 func Read(header *fbs.IndexHeader, indexData []byte) (index.Map, error) {
-	maxZoom := uint32(header.MaxZoom())
+	root := Region(header)
+	if uint32(header.MaxZoom()) < root.Z {
+		return nil, index.ErrInvalidIndex
+	}
+	maxZoom := uint32(header.MaxZoom()) - root.Z // relative to the root
 	blockLevels := block.LevelsMask(header.BlockLevelsMask())
 
 	result := make(index.Map, len(indexData)/packed.LocationLength)
@@ -127,7 +205,7 @@ func Read(header *fbs.IndexHeader, indexData []byte) (index.Map, error) {
 				tileLocation := packed.Read(locationData)
 
 				if tileLocation.Length() != 0 {
-					result[tileID] = tileLocation
+					result[fromRegionTile(root, tileID)] = tileLocation
 				}
 			}
 		}
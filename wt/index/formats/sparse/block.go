@@ -5,10 +5,10 @@ import (
 	"slices"
 	"sort"
 
+	"github.com/eak1mov/go-libtiles/internal/morton"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt/fbs"
 	"github.com/eak1mov/go-libtiles/wt/index"
-	"github.com/eak1mov/go-libtiles/wt/index/internal/morton"
 	"github.com/eak1mov/go-libtiles/wt/index/packed"
 )
 
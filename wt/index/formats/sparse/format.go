@@ -5,16 +5,18 @@ import (
 	"crypto/md5"
 	"slices"
 
+	"github.com/eak1mov/go-libtiles/internal/morton"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt/fbs"
 	"github.com/eak1mov/go-libtiles/wt/index"
 	"github.com/eak1mov/go-libtiles/wt/index/block"
-	"github.com/eak1mov/go-libtiles/wt/index/internal/morton"
 	"github.com/eak1mov/go-libtiles/wt/index/packed"
 )
 
 const MaxZoom = 24
 
+const ErrInvalidLevels tile.Error = "libtiles: invalid block levels"
+
 func QueryBlock(tileID tile.ID, blockRange block.ZoomRange, blockData []byte) (tile.Location, error) {
 	nextZ := min(blockRange.End(), tileID.Z)
 
@@ -140,26 +142,52 @@ func Read(header *fbs.IndexHeader, indexData []byte) (index.Map, error) {
 	return result, nil
 }
 
-func Write(header *fbs.IndexHeader, indexMap index.Map) ([]byte, error) {
-	maxZoom := uint32(0)
-	for tileID := range indexMap {
-		maxZoom = max(maxZoom, tileID.Z)
-	}
-
-	var blockLevels block.LevelsMask
+// DefaultLevels returns block levels used by Write for tiles up to maxZoom.
+func DefaultLevels(maxZoom uint32) block.LevelsMask {
 	if maxZoom <= 8 {
-		blockLevels = block.NewLevelsMask(0, maxZoom+1)
+		return block.NewLevelsMask(0, maxZoom+1)
 	} else if maxZoom <= 15 {
 		// 9 -> [4]
 		// 10..11 -> [5]
 		// 12..13 -> [6]
 		// 14..15 -> [7]
-		blockLevels = block.NewLevelsMask(0, maxZoom/2, maxZoom+1)
+		return block.NewLevelsMask(0, maxZoom/2, maxZoom+1)
 	} else {
 		// 16..17 -> [5, 10]
 		// 18..20 -> [6, 12]
 		// 21..24 -> [7, 14]
-		blockLevels = block.NewLevelsMask(0, maxZoom/3, maxZoom/3*2, maxZoom+1)
+		return block.NewLevelsMask(0, maxZoom/3, maxZoom/3*2, maxZoom+1)
+	}
+}
+
+func Write(header *fbs.IndexHeader, indexMap index.Map) ([]byte, error) {
+	return WriteLevels(header, indexMap, 0)
+}
+
+// WriteLevels is like Write, but with custom block levels: bits of blockLevels
+// are zooms where levels start (see block.LevelsMask.Clip), zero means DefaultLevels.
+func WriteLevels(header *fbs.IndexHeader, indexMap index.Map, blockLevels block.LevelsMask) ([]byte, error) {
+	return WriteStream(header, indexMap.Sorted(), blockLevels)
+}
+
+// WriteStream is like WriteLevels, but consumes entries from a stream.
+//
+// Blocks are built in a single depth-first pass over the stream: only blocks
+// on the path from the root to the current tile are kept in memory, and each
+// block is written after all its child blocks.
+func WriteStream(header *fbs.IndexHeader, entries index.Stream, blockLevels block.LevelsMask) ([]byte, error) {
+	maxZoom := entries.MaxZoom()
+	if maxZoom > MaxZoom {
+		return nil, index.ErrInvalidIndex
+	}
+
+	if blockLevels == 0 {
+		blockLevels = DefaultLevels(maxZoom)
+	} else {
+		blockLevels = blockLevels.Clip(maxZoom)
+	}
+	if !blockLevels.Valid(maxZoom) {
+		return nil, ErrInvalidLevels
 	}
 
 	header.MutateMagic(fbs.IndexMagicValue)
@@ -167,87 +195,144 @@ func Write(header *fbs.IndexHeader, indexMap index.Map) ([]byte, error) {
 	header.MutateMaxZoom(uint64(maxZoom))
 	header.MutateBlockLevelsMask(uint64(blockLevels))
 
-	result := make([]byte, 0, len(indexMap)*packed.LocationLength)
+	w := newBlockWriter(slices.Collect(blockLevels.Ranges()))
 
-	// blockIdx -> [blockTileID]
-	usedBlocks := make([]map[tile.ID]bool, blockLevels.RangesCount())
-	for i := range usedBlocks {
-		usedBlocks[i] = make(map[tile.ID]bool)
+	err := entries.Visit(func(tileID tile.ID, location packed.Location) error {
+		w.add(tileID, location)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	w.closeBlocks(tile.ID{}, false)
 
-	for tileID := range indexMap {
-		for bIdx, blockRange := range slices.Collect(blockLevels.Ranges()) {
-			if tileID.Z < blockRange.Start {
-				break
-			}
-			blockTileID := parentN(tileID, tileID.Z-blockRange.Start)
-			usedBlocks[bIdx][blockTileID] = true
-		}
+	header.MutateRootOffset(w.rootLocation.Offset())
+	header.MutateRootSize(w.rootLocation.Length())
+
+	return w.result, nil
+}
+
+// openBlock is a block being filled, with dense locations of its tiles.
+type openBlock struct {
+	root      tile.ID
+	locations [][]packed.Location
+}
+
+type blockWriter struct {
+	blockRanges    []block.ZoomRange
+	blocks         []*openBlock // level -> open block on the path to the current tile
+	spare          []*openBlock // level -> closed block, reused to avoid allocations
+	hashToLocation map[[16]byte]packed.Location
+	rootLocation   packed.Location
+	result         []byte
+}
+
+func newBlockWriter(blockRanges []block.ZoomRange) *blockWriter {
+	return &blockWriter{
+		blockRanges:    blockRanges,
+		blocks:         make([]*openBlock, len(blockRanges)),
+		spare:          make([]*openBlock, len(blockRanges)),
+		hashToLocation: make(map[[16]byte]packed.Location),
 	}
+}
 
-	hashToLocation := make(map[[16]byte]packed.Location)
+// add adds the tile, tiles must be added in pyramid order (see index.PyramidKey).
+func (w *blockWriter) add(tileID tile.ID, location packed.Location) {
+	w.closeBlocks(tileID, true)
 
-	for bIdx, blockRange := range slices.Backward(slices.Collect(blockLevels.Ranges())) {
-		blockZoomCount := blockRange.Count
-		if bIdx != blockLevels.RangesCount()-1 {
-			blockZoomCount++ // location of the next block (blockRoot)
+	level := 0
+	for ; level+1 < len(w.blockRanges) && w.blockRanges[level+1].Start <= tileID.Z; level++ {
+	}
+
+	for l := range level + 1 {
+		if w.blocks[l] == nil {
+			w.blocks[l] = w.openBlock(l, parentN(tileID, tileID.Z-w.blockRanges[l].Start))
 		}
+	}
 
-		for blockTileID := range usedBlocks[bIdx] {
-			blockLocations := make([][]packed.Location, blockZoomCount)
+	b := w.blocks[level]
+	innerTileID := subtractTileIDs(tileID, b.root)
+	b.locations[innerTileID.Z][morton.Encode(innerTileID)] = location
+}
 
-			for innerZ := range blockZoomCount {
-				innerTilesCount := tilesCountOnZoom(innerZ)
-				blockLocations[innerZ] = make([]packed.Location, innerTilesCount)
+func (w *blockWriter) openBlock(level int, root tile.ID) *openBlock {
+	if b := w.spare[level]; b != nil {
+		w.spare[level] = nil
+		b.root = root
+		return b
+	}
 
-				for innerCode := range innerTilesCount {
-					innerTileID := morton.Decode(innerCode, innerZ)
-					tileID := combineTileIDs(blockTileID, innerTileID)
+	blockZoomCount := w.blockRanges[level].Count
+	if level != len(w.blockRanges)-1 {
+		blockZoomCount++ // location of the next block (blockRoot)
+	}
+	b := &openBlock{root: root, locations: make([][]packed.Location, blockZoomCount)}
+	for innerZ := range blockZoomCount {
+		b.locations[innerZ] = make([]packed.Location, tilesCountOnZoom(innerZ))
+	}
+	return b
+}
 
-					if location, found := indexMap[tileID]; found {
-						blockLocations[innerZ][innerCode] = location
-					}
-				}
-			}
+// closeBlocks writes open blocks, deepest first. If keepAncestors is set,
+// blocks containing tileID are kept open.
+func (w *blockWriter) closeBlocks(tileID tile.ID, keepAncestors bool) {
+	for level := len(w.blocks) - 1; level >= 0; level-- {
+		b := w.blocks[level]
+		if b == nil {
+			continue
+		}
+		if keepAncestors && tileID.Z >= b.root.Z && parentN(tileID, tileID.Z-b.root.Z) == b.root {
+			continue
+		}
 
-			denseData := writeDense(blockLocations)
-			denseDataHash := md5.Sum(denseData)
+		blockRoot := w.writeBlock(b.locations)
+		if level > 0 {
+			parentBlock := w.blocks[level-1]
+			innerTileID := subtractTileIDs(b.root, parentBlock.root)
+			parentBlock.locations[innerTileID.Z][morton.Encode(innerTileID)] = blockRoot
+		} else {
+			w.rootLocation = blockRoot
+		}
 
-			if location, found := hashToLocation[denseDataHash]; found {
-				indexMap[blockTileID] = location
-				continue
-			}
+		for _, locations := range b.locations {
+			clear(locations)
+		}
+		w.blocks[level] = nil
+		w.spare[level] = b
+	}
+}
 
-			sparseData := writeSparse(denseToSparse(blockLocations))
-			sparseDataHash := md5.Sum(sparseData)
+// writeBlock appends the block in the smallest encoding, identical blocks
+// are written once.
+func (w *blockWriter) writeBlock(blockLocations [][]packed.Location) packed.Location {
+	denseData := writeDense(blockLocations)
+	denseDataHash := md5.Sum(denseData)
 
-			if location, found := hashToLocation[sparseDataHash]; found {
-				indexMap[blockTileID] = location
-				continue
-			}
+	if location, found := w.hashToLocation[denseDataHash]; found {
+		return location
+	}
 
-			var blockData []byte
-			if len(denseData) <= len(sparseData) {
-				blockData = denseData
-			} else {
-				blockData = sparseData
-			}
+	sparseData := writeSparse(denseToSparse(blockLocations))
+	sparseDataHash := md5.Sum(sparseData)
 
-			blockRoot := packed.Pack(tile.Location{
-				Offset: uint64(len(result)),
-				Length: uint64(len(blockData)),
-			})
-			indexMap[blockTileID] = blockRoot
-			hashToLocation[denseDataHash] = blockRoot
-			hashToLocation[sparseDataHash] = blockRoot
+	if location, found := w.hashToLocation[sparseDataHash]; found {
+		return location
+	}
 
-			result = append(result, blockData...)
-		}
+	var blockData []byte
+	if len(denseData) <= len(sparseData) {
+		blockData = denseData
+	} else {
+		blockData = sparseData
 	}
 
-	rootLocation, _ := indexMap[tile.ID{X: 0, Y: 0, Z: 0}]
-	header.MutateRootOffset(rootLocation.Offset())
-	header.MutateRootSize(rootLocation.Length())
+	blockRoot := packed.Pack(tile.Location{
+		Offset: uint64(len(w.result)),
+		Length: uint64(len(blockData)),
+	})
+	w.hashToLocation[denseDataHash] = blockRoot
+	w.hashToLocation[sparseDataHash] = blockRoot
 
-	return result, nil
+	w.result = append(w.result, blockData...)
+	return blockRoot
 }
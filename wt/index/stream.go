@@ -0,0 +1,81 @@
+package index
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/eak1mov/go-libtiles/internal/morton"
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/eak1mov/go-libtiles/wt/index/packed"
+)
+
+// maxKeyZoom is the deepest zoom representable by PyramidKey.
+const maxKeyZoom = 24
+
+// PyramidKey returns the sort key of the tile in pyramid order: depth-first
+// traversal of the tile pyramid, children in Morton order. All tiles of any
+// subtree are contiguous in this order, and each tile precedes its subtree.
+// Supports zooms up to 24.
+func PyramidKey(tileID tile.ID) uint64 {
+	shift := maxKeyZoom - tileID.Z
+	return morton.Encode64(tileID.X<<shift, tileID.Y<<shift)<<5 | uint64(tileID.Z)
+}
+
+// DecodePyramidKey is the inverse of PyramidKey.
+func DecodePyramidKey(key uint64) tile.ID {
+	z := uint32(key & 0x1F)
+	x, y := morton.Decode64(key >> 5)
+	shift := maxKeyZoom - z
+	return tile.ID{X: x >> shift, Y: y >> shift, Z: z}
+}
+
+// Entry is an index entry in compact form (16 bytes).
+type Entry struct {
+	Key      uint64 // see PyramidKey
+	Location packed.Location
+}
+
+func compareEntries(a, b Entry) int {
+	return cmp.Compare(a.Key, b.Key)
+}
+
+// VisitFunc is called for each entry of a Stream.
+type VisitFunc func(tileID tile.ID, location packed.Location) error
+
+// Stream is a sequence of index entries sorted in pyramid order (see
+// PyramidKey), with at most one entry per tile. Index formats are written
+// from streams, so that large indexes don't need to fit in memory as a Map.
+type Stream interface {
+	MaxZoom() uint32
+	Visit(fn VisitFunc) error
+}
+
+// Entries is an in-memory Stream, entries must be sorted by Key.
+type Entries []Entry
+
+func (e Entries) MaxZoom() uint32 {
+	maxZoom := uint32(0)
+	for _, entry := range e {
+		maxZoom = max(maxZoom, uint32(entry.Key&0x1F))
+	}
+	return maxZoom
+}
+
+func (e Entries) Visit(fn VisitFunc) error {
+	for _, entry := range e {
+		if err := fn(DecodePyramidKey(entry.Key), entry.Location); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sorted returns entries of the map as a Stream.
+func (m Map) Sorted() Entries {
+	result := make(Entries, 0, len(m))
+	for tileID, location := range m {
+		result = append(result, Entry{Key: PyramidKey(tileID), Location: location})
+	}
+	slices.SortFunc(result, compareEntries)
+	return result
+}
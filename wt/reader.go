@@ -3,10 +3,15 @@ package wt
 
 import (
 	"os"
+	"slices"
 
+	"github.com/eak1mov/go-libtiles/internal/batch"
+	"github.com/eak1mov/go-libtiles/internal/hotprefix"
+	"github.com/eak1mov/go-libtiles/internal/mmap"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt/fbs"
 	"github.com/eak1mov/go-libtiles/wt/index"
+	"github.com/eak1mov/go-libtiles/wt/index/block"
 	"github.com/eak1mov/go-libtiles/wt/index/formats/basic"
 	"github.com/eak1mov/go-libtiles/wt/index/formats/plain"
 	"github.com/eak1mov/go-libtiles/wt/index/formats/sparse"
@@ -30,6 +35,8 @@ type Reader struct {
 	fileHeader     *fbs.FileHeader
 	indexHeader    *fbs.IndexHeader
 	headerMetadata []byte
+	maxGap         uint64
+	indexCache     *indexCache
 }
 
 type FileReader struct {
@@ -37,10 +44,54 @@ type FileReader struct {
 	file *os.File
 }
 
+// MmapReader is a Reader backed by a read-only memory mapping of a local file.
+type MmapReader struct {
+	Reader
+	mapping *mmap.Mapping
+}
+
+type readerConfig struct {
+	PinHotPrefix         bool
+	MaxGap               uint64
+	IndexCacheSize       uint64
+	PreloadedIndexLevels int
+}
+
+type ReaderOption func(*readerConfig)
+
+// WithPinnedHotPrefix enables prefetching of the hot-tile prefix (see
+// WithHotPrefix) at open time. Reads inside the prefix are served from memory,
+// returned slices share this memory and must not be modified.
+func WithPinnedHotPrefix(enable bool) ReaderOption {
+	return func(c *readerConfig) { c.PinHotPrefix = enable }
+}
+
+// WithMaxGap sets the largest gap (in bytes) between byte ranges which are
+// merged into a single file access by ReadTiles. Default is 0: only adjacent
+// ranges are merged. Larger values trade extra bytes for fewer requests.
+func WithMaxGap(maxGap uint64) ReaderOption {
+	return func(c *readerConfig) { c.MaxGap = maxGap }
+}
+
+// WithIndexCache enables a cache of index blocks (Plain and Sparse formats),
+// shared between goroutines and bounded by capacity bytes. The root block is
+// always pinned in the cache.
+func WithIndexCache(capacity uint64) ReaderOption {
+	return func(c *readerConfig) { c.IndexCacheSize = capacity }
+}
+
+// WithPreloadedIndex enables eager loading of the top block levels (see
+// IndexHeader.BlockLevelsMask) at open time, levels = 1 loads the root block
+// only. Loaded blocks are pinned in the index cache (see WithIndexCache).
+// Block levels are fetched level by level with coalesced reads (see WithMaxGap).
+func WithPreloadedIndex(levels int) ReaderOption {
+	return func(c *readerConfig) { c.PreloadedIndexLevels = levels }
+}
+
 // NewFileReader opens a local WebTiles file and returns a Reader for it.
 //
 // The returned Reader must be closed after use to release file resources.
-func NewFileReader(filePath string) (*FileReader, error) {
+func NewFileReader(filePath string, opts ...ReaderOption) (*FileReader, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
@@ -52,7 +103,7 @@ func NewFileReader(filePath string) (*FileReader, error) {
 		}
 		return buffer, nil
 	}
-	reader, err := NewReader(fileAccess)
+	reader, err := NewReader(fileAccess, opts...)
 	if err != nil {
 		file.Close()
 		return nil, err
@@ -64,9 +115,38 @@ func (r *FileReader) Close() error {
 	return r.file.Close()
 }
 
+// NewMmapReader maps a local WebTiles file into memory and returns a Reader for it.
+// It is only supported on Linux.
+//
+// Tile data, metadata and index are returned as slices of the mapping without
+// copying or extra syscalls. These slices must not be modified and must not
+// be used after Close: the memory is unmapped and accessing it crashes the process.
+func NewMmapReader(filePath string, opts ...ReaderOption) (*MmapReader, error) {
+	mapping, err := mmap.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(mapping.Access, opts...)
+	if err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return &MmapReader{*reader, mapping}, nil
+}
+
+// Close unmaps the file, all slices returned by the Reader become invalid.
+func (r *MmapReader) Close() error {
+	return r.mapping.Close()
+}
+
 // NewReader creates a Reader using a custom file access function.
 // This is useful for remote or in-memory access.
-func NewReader(fileAccess FileAccessFunc) (*Reader, error) {
+func NewReader(fileAccess FileAccessFunc, opts ...ReaderOption) (*Reader, error) {
+	config := readerConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
 	headerData, err := fileAccess(0, uint64(fbs.HeaderSizeExtended))
 	if err != nil {
 		return nil, err
@@ -87,12 +167,105 @@ func NewReader(fileAccess FileAccessFunc) (*Reader, error) {
 
 	headerMetadata := headerData[fileHeader.ExtendedOffset():][:fileHeader.ExtendedSize()]
 
-	return &Reader{
+	reader := &Reader{
 		fileAccess:     fileAccess,
 		fileHeader:     fileHeader,
 		indexHeader:    indexHeader,
 		headerMetadata: headerMetadata,
-	}, nil
+		maxGap:         config.MaxGap,
+	}
+
+	if config.PinHotPrefix {
+		hotPrefix, err := reader.HotPrefix()
+		if err != nil {
+			return nil, err
+		}
+		pinnedAccess, err := hotprefix.Pin(fileAccess, hotPrefix)
+		if err != nil {
+			return nil, err
+		}
+		reader.fileAccess = pinnedAccess
+	}
+
+	cacheable := indexHeader.Format() == fbs.IndexFormatPlain || indexHeader.Format() == fbs.IndexFormatSparse
+	if cacheable && (config.IndexCacheSize > 0 || config.PreloadedIndexLevels > 0) {
+		reader.indexCache = newIndexCache(config.IndexCacheSize)
+		if err := reader.preloadIndex(max(config.PreloadedIndexLevels, 1)); err != nil {
+			return nil, err
+		}
+	}
+
+	return reader, nil
+}
+
+// preloadIndex loads and pins blocks of the top block levels.
+func (r *Reader) preloadIndex(levels int) error {
+	blockRanges := slices.Collect(block.LevelsMask(r.indexHeader.BlockLevelsMask()).Ranges())
+	levels = min(levels, len(blockRanges))
+
+	type blockRef struct {
+		TileID   tile.ID // root tile of the block
+		Location tile.Location
+	}
+
+	var blocks []blockRef
+	switch r.indexHeader.Format() {
+	case fbs.IndexFormatSparse:
+		blocks = []blockRef{{Location: tile.Location{Offset: r.indexHeader.RootOffset(), Length: r.indexHeader.RootSize()}}}
+	case fbs.IndexFormatPlain:
+		blocks = []blockRef{{Location: plain.QueryBlock(tile.ID{}, blockRanges[0]).Block}}
+	}
+
+	indexAccess := func(offset, length uint64) ([]byte, error) {
+		return r.fileAccess(r.fileHeader.IndexOffset()+offset, length)
+	}
+
+	for level := range levels {
+		locations := make([]tile.Location, len(blocks))
+		for i, b := range blocks {
+			locations[i] = b.Location
+		}
+		blocksData, err := batch.ReadRanges(indexAccess, locations, r.maxGap)
+		if err != nil {
+			return err
+		}
+		for i, b := range blocks {
+			if b.Location.Length > 0 {
+				r.indexCache.pin(b.Location.Offset, blocksData[i])
+			}
+		}
+
+		if level+1 == levels {
+			break
+		}
+		nextRange := blockRanges[level+1]
+		var nextBlocks []blockRef
+		for i, b := range blocks {
+			if b.Location.Length == 0 {
+				continue
+			}
+			zDiff := nextRange.Start - b.TileID.Z
+			for dx := range uint32(1) << zDiff {
+				for dy := range uint32(1) << zDiff {
+					childID := tile.ID{X: b.TileID.X<<zDiff + dx, Y: b.TileID.Y<<zDiff + dy, Z: nextRange.Start}
+					var location tile.Location
+					switch r.indexHeader.Format() {
+					case fbs.IndexFormatSparse:
+						location, err = sparse.QueryBlock(childID, blockRanges[level], blocksData[i])
+					case fbs.IndexFormatPlain:
+						location = plain.QueryBlock(childID, nextRange).Block
+					}
+					if err != nil {
+						return err
+					}
+					nextBlocks = append(nextBlocks, blockRef{TileID: childID, Location: location})
+				}
+			}
+		}
+		blocks = nextBlocks
+	}
+
+	return nil
 }
 
 // HeaderMetadata returns the metadata from the WebTiles header.
@@ -105,6 +278,36 @@ func (r *Reader) ReadMetadata() ([]byte, error) {
 	return r.fileAccess(r.fileHeader.MetadataOffset(), r.fileHeader.MetadataSize())
 }
 
+// HotPrefix returns the absolute location of the hot-tile prefix: the most
+// requested tiles placed at the start of the data section (see WithHotPrefix).
+// It returns an empty location if the file has no hot prefix.
+func (r *Reader) HotPrefix() (tile.Location, error) {
+	if r.fileHeader.MetadataSize() == 0 {
+		return tile.Location{}, nil
+	}
+	metadata, err := r.ReadMetadata()
+	if err != nil {
+		return tile.Location{}, err
+	}
+	return tile.Location{
+		Offset: r.fileHeader.DataOffset(),
+		Length: min(hotprefix.ParseMetadata(metadata), r.fileHeader.DataSize()),
+	}, nil
+}
+
+// IndexOptions returns writer options reproducing the index layout of the
+// file: its format, block levels (Sparse format) and region (Plain format).
+func (r *Reader) IndexOptions() []WriterOption {
+	opts := []WriterOption{WithIndexFormat(r.indexHeader.Format())}
+	switch r.indexHeader.Format() {
+	case fbs.IndexFormatSparse:
+		opts = append(opts, WithBlockLevels(block.LevelsMask(r.indexHeader.BlockLevelsMask())))
+	case fbs.IndexFormatPlain:
+		opts = append(opts, WithIndexRegion(plain.Region(r.indexHeader)))
+	}
+	return opts
+}
+
 func queryIndex(header *fbs.IndexHeader, tileID tile.ID, indexAccess index.FileAccessFunc) (tile.Location, error) {
 	switch header.Format() {
 	case fbs.IndexFormatBasicPlain:
@@ -119,12 +322,25 @@ func queryIndex(header *fbs.IndexHeader, tileID tile.ID, indexAccess index.FileA
 }
 
 func (r *Reader) ReadLocation(tileID tile.ID) (tile.Location, error) {
+	return r.readLocation(r.fileAccess, tileID)
+}
+
+func (r *Reader) readLocation(fileAccess FileAccessFunc, tileID tile.ID) (tile.Location, error) {
 	if !tileID.Valid() || tileID.Z > MaxZoom {
 		return tile.Location{}, ErrInvalidRequest
 	}
 
 	indexAccess := func(offset, length uint64) ([]byte, error) {
-		return r.fileAccess(r.fileHeader.IndexOffset()+offset, length)
+		if r.indexCache != nil {
+			if data, found := r.indexCache.get(offset, length); found {
+				return data, nil
+			}
+		}
+		data, err := fileAccess(r.fileHeader.IndexOffset()+offset, length)
+		if err == nil && r.indexCache != nil {
+			r.indexCache.add(offset, data)
+		}
+		return data, err
 	}
 
 	tileLocation, err := queryIndex(r.indexHeader, tileID, indexAccess)
@@ -149,6 +365,29 @@ func (r *Reader) ReadTile(tileID tile.ID) ([]byte, error) {
 	return r.fileAccess(tileLocation.Offset, tileLocation.Length)
 }
 
+// ReadLocations returns locations of multiple tiles. Index blocks are fetched
+// once per batch, nearby blocks are fetched together (see WithMaxGap).
+func (r *Reader) ReadLocations(tileIDs []tile.ID) ([]tile.Location, error) {
+	query := func(i int, fileAccess batch.FileAccessFunc) (tile.Location, error) {
+		return r.readLocation(fileAccess, tileIDs[i])
+	}
+	return batch.Resolve(len(tileIDs), query, r.fileAccess, r.maxGap)
+}
+
+// ReadTiles reads multiple tiles with as few file accesses as possible: all
+// locations are resolved first (see ReadLocations), then adjacent or nearby
+// tiles are fetched with a single file access (see WithMaxGap).
+//
+// The result is aligned with tileIDs, missing tiles are empty slices.
+// Returned slices may share memory and must not be modified.
+func (r *Reader) ReadTiles(tileIDs []tile.ID) ([][]byte, error) {
+	locations, err := r.ReadLocations(tileIDs)
+	if err != nil {
+		return nil, err
+	}
+	return batch.ReadRanges(r.fileAccess, locations, r.maxGap)
+}
+
 func readIndex(header *fbs.IndexHeader, indexData []byte) (index.Map, error) {
 	switch header.Format() {
 	case fbs.IndexFormatBasicPlain:
@@ -0,0 +1,112 @@
+package wt
+
+import (
+	"os"
+
+	"github.com/eak1mov/go-libtiles/tile"
+	"github.com/eak1mov/go-libtiles/wt/fbs"
+	"github.com/eak1mov/go-libtiles/wt/index"
+	"github.com/eak1mov/go-libtiles/wt/index/packed"
+)
+
+// Reindex rebuilds the index of an existing WebTiles file in place, with the
+// index format, block levels, region, memory limit and temp dir from opts
+// (other options are ignored). The data section and metadata are not copied
+// or modified.
+//
+// The new index is appended to the end of the file, then the header is
+// updated, so the file stays valid if Reindex is interrupted. The space of
+// the old index is not reclaimed, use Import to compact the file.
+func Reindex(filePath string, opts ...WriterOption) error {
+	cfg, err := prepareConfig(opts...)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	fileAccess := func(offset, length uint64) ([]byte, error) {
+		buffer := make([]byte, length)
+		if _, err := file.ReadAt(buffer, int64(offset)); err != nil {
+			return nil, err
+		}
+		return buffer, nil
+	}
+	reader, err := NewReader(fileAccess)
+	if err != nil {
+		return err
+	}
+
+	cfg.Logger.Println("libtiles: read index")
+	dataOffset := reader.fileHeader.DataOffset()
+	indexBuilder := index.NewBuilder(cfg.MemoryLimit, cfg.TempDir)
+	defer indexBuilder.Close()
+	err = reader.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		if err := checkTile(tileID, cfg.IndexFormat, cfg.IndexRegion); err != nil {
+			return err
+		}
+		location.Offset -= dataOffset
+		return indexBuilder.Add(tileID, packed.Pack(location))
+	})
+	if err != nil {
+		return err
+	}
+
+	headerData, err := fileAccess(0, uint64(fbs.HeaderSizeExtended))
+	if err != nil {
+		return err
+	}
+	header := fbs.Header{}
+	header.Init(headerData, 0)
+
+	cfg.Logger.Println("libtiles: write index")
+	indexHeader := header.IndexHeader(nil)
+	resetIndexHeader(indexHeader)
+	indexData, err := writeIndex(indexHeader, indexBuilder, cfg.IndexFormat, cfg.BlockLevels, cfg.IndexRegion)
+	if err != nil {
+		return err
+	}
+
+	indexOffset := uint64(fileInfo.Size())
+	if _, err := file.WriteAt(indexData, int64(indexOffset)); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	cfg.Logger.Println("libtiles: write header")
+	fileHeader := header.FileHeader(nil)
+	fileHeader.MutateIndexOffset(indexOffset)
+	fileHeader.MutateIndexSize(uint64(len(indexData)))
+	if _, err := file.WriteAt(headerData, 0); err != nil {
+		return err
+	}
+
+	cfg.Logger.Println("libtiles: flush file")
+	return file.Sync()
+}
+
+func resetIndexHeader(header *fbs.IndexHeader) {
+	header.MutateMagic(0)
+	header.MutateFormat(fbs.IndexFormatInvalid)
+	header.MutateMaxZoom(0)
+	header.MutateBlockLevelsMask(0)
+	header.MutateRootOffset(0)
+	header.MutateRootSize(0)
+	header.MutateReserved1(0)
+	header.MutateReserved2(0)
+	header.MutateReserved3(0)
+	header.MutateReserved4(0)
+	header.MutateReserved5(0)
+	header.MutateReserved6(0)
+}
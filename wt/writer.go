@@ -2,16 +2,22 @@ package wt
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"crypto/md5"
+	"encoding/binary"
+	"errors"
 	"io"
 	"log"
 	"os"
 
 	"github.com/eak1mov/go-libtiles/internal/copier"
+	"github.com/eak1mov/go-libtiles/internal/digest"
+	"github.com/eak1mov/go-libtiles/internal/hotprefix"
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt/fbs"
 	"github.com/eak1mov/go-libtiles/wt/index"
+	"github.com/eak1mov/go-libtiles/wt/index/block"
 	"github.com/eak1mov/go-libtiles/wt/index/formats/basic"
 	"github.com/eak1mov/go-libtiles/wt/index/formats/plain"
 	"github.com/eak1mov/go-libtiles/wt/index/formats/sparse"
@@ -37,15 +43,24 @@ type Writer struct {
 	tileWriter *bufio.Writer
 	tileOffset uint64
 
-	hashToLocation map[[16]byte]packed.Location
-	indexMap       index.Map
-	indexFormat    fbs.IndexFormat
+	hashLocations    *digest.Table // nil if deduplication is disabled
+	contentLocations *digest.Table // nil if deduplication is disabled, see WriteContent
+	indexBuilder     *index.Builder
+	indexFormat      fbs.IndexFormat
+	blockLevels      block.LevelsMask
+	indexRegion      tile.ID
 }
 
 type writerConfig struct {
 	HeaderMetadata []byte
 	Metadata       []byte
 	IndexFormat    fbs.IndexFormat
+	BlockLevels    block.LevelsMask
+	IndexRegion    tile.ID
+	HotPrefix      uint64
+	Deduplication  bool
+	MemoryLimit    uint64
+	TempDir        string
 	Logger         *log.Logger
 }
 
@@ -63,6 +78,56 @@ func WithIndexFormat(indexFormat fbs.IndexFormat) WriterOption {
 	return func(c *writerConfig) { c.IndexFormat = indexFormat }
 }
 
+// WithBlockLevels sets block levels of the Sparse index: bits of blockLevels
+// are zooms where levels start, the first level must start at zoom 0, the last
+// one ends at the max zoom of the tileset (see block.LevelsMask.Clip).
+// For example, block.NewLevelsMask(0, 6, 12) splits z0-z14 into three levels.
+//
+// Fewer levels mean fewer requests per lookup but larger blocks. By default,
+// levels are selected from the max zoom (see sparse.DefaultLevels).
+func WithBlockLevels(blockLevels block.LevelsMask) WriterOption {
+	return func(c *writerConfig) { c.BlockLevels = blockLevels }
+}
+
+// WithIndexRegion restricts the Plain index to the sub-pyramid rooted at the
+// given tile: only tiles inside it can be written, up to plain.MaxZoom levels
+// below the root. This allows direct-offset lookups for dense extracts with
+// deep zooms (e.g. z16-z20 of a city rooted at a z12 tile).
+func WithIndexRegion(root tile.ID) WriterOption {
+	return func(c *writerConfig) { c.IndexRegion = root }
+}
+
+// WithHotPrefix sets maximum length of the hot-tile prefix for Import: tiles
+// from the beginning of the index (the most requested ones) are placed in one
+// contiguous region at the start of the data section, and its actual length is
+// recorded in the JSON metadata (see Reader.HotPrefix). The length is added to
+// the metadata object keeping its other keys as is, metadata which isn't a JSON
+// object is left unchanged, and readers don't see the prefix then.
+func WithHotPrefix(maxLength uint64) WriterOption {
+	return func(c *writerConfig) { c.HotPrefix = maxLength }
+}
+
+// WithDeduplication enables or disables deduplication of identical tiles in
+// Writer (enabled by default). Digests of written tiles are kept within the
+// memory limit (see WithMemoryLimit), disabling it saves temporary files and
+// lookups of digests.
+func WithDeduplication(enable bool) WriterOption {
+	return func(c *writerConfig) { c.Deduplication = enable }
+}
+
+// WithMemoryLimit sets the approximate memory limit in bytes for index entries
+// accumulated by Writer, Import and Reindex, and separately for digests of
+// tiles deduplicated by Writer (index.DefaultMemoryLimit by default). Above the
+// limit, sorted runs of entries are spilled to temporary files.
+func WithMemoryLimit(memoryLimit uint64) WriterOption {
+	return func(c *writerConfig) { c.MemoryLimit = memoryLimit }
+}
+
+// WithTempDir sets the directory for temporary files (os.TempDir by default).
+func WithTempDir(tempDir string) WriterOption {
+	return func(c *writerConfig) { c.TempDir = tempDir }
+}
+
 // WithLogger sets custom logger, otherwise log messages are discarded.
 func WithLogger(logger *log.Logger) WriterOption {
 	return func(c *writerConfig) { c.Logger = logger }
@@ -70,8 +135,9 @@ func WithLogger(logger *log.Logger) WriterOption {
 
 func prepareConfig(opts ...WriterOption) (*writerConfig, error) {
 	config := writerConfig{
-		IndexFormat: fbs.IndexFormatSparse,
-		Logger:      log.New(io.Discard, "", log.LstdFlags),
+		IndexFormat:   fbs.IndexFormatSparse,
+		Deduplication: true,
+		Logger:        log.New(io.Discard, "", log.LstdFlags),
 	}
 	for _, opt := range opts {
 		opt(&config)
@@ -86,6 +152,24 @@ func prepareConfig(opts ...WriterOption) (*writerConfig, error) {
 		return nil, tile.Error("libtiles: invalid index format")
 	}
 
+	if config.BlockLevels != 0 {
+		if config.IndexFormat != fbs.IndexFormatSparse {
+			return nil, tile.Error("libtiles: block levels are supported only by sparse index format")
+		}
+		if !config.BlockLevels.Clip(MaxZoom).Valid(MaxZoom) || config.BlockLevels>>(MaxZoom+1) > 1 {
+			return nil, sparse.ErrInvalidLevels
+		}
+	}
+
+	if config.IndexRegion != (tile.ID{}) {
+		if config.IndexFormat != fbs.IndexFormatPlain {
+			return nil, tile.Error("libtiles: index region is supported only by plain index format")
+		}
+		if !config.IndexRegion.Valid() || config.IndexRegion.Z > MaxZoom {
+			return nil, plain.ErrOutsideRegion
+		}
+	}
+
 	return &config, nil
 }
 
@@ -144,21 +228,45 @@ func NewWriter(filePath string, opts ...WriterOption) (*Writer, error) {
 
 	fileHeader.MutateDataOffset(dataOffset)
 
+	var hashLocations, contentLocations *digest.Table
+	if config.Deduplication {
+		memoryLimit := cmp.Or(config.MemoryLimit, index.DefaultMemoryLimit)
+		hashLocations = digest.New(memoryLimit, config.TempDir)
+		contentLocations = digest.New(memoryLimit, config.TempDir)
+	}
+
 	return &Writer{
-		logger:         config.Logger,
-		file:           file,
-		headerData:     headerData,
-		header:         header,
-		tileWriter:     bufio.NewWriter(file),
-		tileOffset:     0,
-		hashToLocation: make(map[[16]byte]packed.Location),
-		indexMap:       make(index.Map),
-		indexFormat:    config.IndexFormat,
+		logger:           config.Logger,
+		file:             file,
+		headerData:       headerData,
+		header:           header,
+		tileWriter:       bufio.NewWriter(file),
+		tileOffset:       0,
+		hashLocations:    hashLocations,
+		contentLocations: contentLocations,
+		indexBuilder:     index.NewBuilder(config.MemoryLimit, config.TempDir),
+		indexFormat:      config.IndexFormat,
+		blockLevels:      config.BlockLevels,
+		indexRegion:      config.IndexRegion,
 	}, nil
 }
 
 func (w *Writer) Close() error {
-	return w.file.Close()
+	indexErr := errors.Join(w.indexBuilder.Close(), w.closeDigests())
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return indexErr
+}
+
+// closeDigests removes temporary files of deduplication.
+func (w *Writer) closeDigests() error {
+	if w.hashLocations == nil {
+		return nil
+	}
+	err := errors.Join(w.hashLocations.Close(), w.contentLocations.Close())
+	w.hashLocations, w.contentLocations = nil, nil
+	return err
 }
 
 var maxZooms = map[fbs.IndexFormat]uint32{
@@ -167,51 +275,116 @@ var maxZooms = map[fbs.IndexFormat]uint32{
 	fbs.IndexFormatSparse:     sparse.MaxZoom,
 }
 
+// checkTile checks that the tile can be stored in the index of given format.
+func checkTile(tileID tile.ID, indexFormat fbs.IndexFormat, indexRegion tile.ID) error {
+	if !tileID.Valid() || tileID.Z > MaxZoom {
+		return ErrInvalidTile
+	}
+
+	if indexFormat == fbs.IndexFormatPlain {
+		regionTileID, inRegion := plain.RegionTile(indexRegion, tileID)
+		if !inRegion {
+			return plain.ErrOutsideRegion
+		}
+		if regionTileID.Z > plain.MaxZoom {
+			return ErrInvalidZoom
+		}
+	} else if tileID.Z > maxZooms[indexFormat] {
+		return ErrInvalidZoom
+	}
+
+	return nil
+}
+
 // WriteTile writes a single tile to the WebTiles file.
 func (w *Writer) WriteTile(tileID tile.ID, tileData []byte) error {
 	if w.tileWriter == nil {
 		return tile.Error("libtiles: write called after finalize")
 	}
 
-	if !tileID.Valid() || tileID.Z > MaxZoom {
-		return ErrInvalidTile
+	if err := checkTile(tileID, w.indexFormat, w.indexRegion); err != nil {
+		return err
 	}
 
-	if tileID.Z > maxZooms[w.indexFormat] {
-		return ErrInvalidZoom
+	if len(tileData) == 0 {
+		return nil
+	}
+
+	var key digest.Key
+	if w.hashLocations != nil {
+		key = md5.Sum(tileData)
+	}
+	return w.writeDeduplicated(w.hashLocations, key, tileID, tileData)
+}
+
+// WriteContent writes a single tile like WriteTile, deduplicating tiles by
+// contentID instead of hashing (see tile.ContentWriter).
+func (w *Writer) WriteContent(tileID tile.ID, contentID uint64, tileData []byte) error {
+	if w.tileWriter == nil {
+		return tile.Error("libtiles: write called after finalize")
+	}
+
+	if err := checkTile(tileID, w.indexFormat, w.indexRegion); err != nil {
+		return err
 	}
 
 	if len(tileData) == 0 {
 		return nil
 	}
 
-	digest := md5.Sum(tileData)
-	location, exists := w.hashToLocation[digest]
+	var key digest.Key
+	binary.LittleEndian.PutUint64(key[:], contentID)
+	return w.writeDeduplicated(w.contentLocations, key, tileID, tileData)
+}
+
+// writeDeduplicated writes tile data unless data with the same key is written
+// already (locations is nil if deduplication is disabled), and adds the tile
+// to the index.
+func (w *Writer) writeDeduplicated(locations *digest.Table, key digest.Key, tileID tile.ID, tileData []byte) error {
+	var value uint64
+	exists := false
+	if locations != nil {
+		var err error
+		if value, exists, err = locations.Get(key); err != nil {
+			return err
+		}
+	}
 
+	location := packed.Location(value)
 	if !exists {
-		if _, err := w.tileWriter.Write(tileData); err != nil {
+		var err error
+		if location, err = w.writeData(tileData); err != nil {
 			return err
 		}
-		location = packed.Pack(tile.Location{
-			Offset: w.tileOffset,
-			Length: uint64(len(tileData)),
-		})
-		w.hashToLocation[digest] = location
-		w.tileOffset += uint64(len(tileData))
+		if locations != nil {
+			if err := locations.Add(key, uint64(location)); err != nil {
+				return err
+			}
+		}
 	}
+	return w.indexBuilder.Add(tileID, location)
+}
 
-	w.indexMap[tileID] = location
-	return nil
+func (w *Writer) writeData(tileData []byte) (packed.Location, error) {
+	if _, err := w.tileWriter.Write(tileData); err != nil {
+		return 0, err
+	}
+	location := packed.Pack(tile.Location{
+		Offset: w.tileOffset,
+		Length: uint64(len(tileData)),
+	})
+	w.tileOffset += uint64(len(tileData))
+	return location, nil
 }
 
-func writeIndex(header *fbs.IndexHeader, indexMap index.Map, indexFormat fbs.IndexFormat) ([]byte, error) {
+func writeIndex(header *fbs.IndexHeader, entries index.Stream, indexFormat fbs.IndexFormat, blockLevels block.LevelsMask, indexRegion tile.ID) ([]byte, error) {
 	switch indexFormat {
 	case fbs.IndexFormatBasicPlain:
-		return basic.Write(header, indexMap)
+		return basic.WriteStream(header, entries)
 	case fbs.IndexFormatPlain:
-		return plain.Write(header, indexMap)
+		return plain.WriteStream(header, entries, indexRegion)
 	case fbs.IndexFormatSparse:
-		return sparse.Write(header, indexMap)
+		return sparse.WriteStream(header, entries, blockLevels)
 	default:
 		return nil, tile.Error("libtiles: invalid index format")
 	}
@@ -238,10 +411,16 @@ func (w *Writer) Finalize() error {
 	fileHeader.MutateDataSize(w.tileOffset)
 
 	w.logger.Println("libtiles: prepare index")
-	indexData, err := writeIndex(indexHeader, w.indexMap, w.indexFormat)
+	indexData, err := writeIndex(indexHeader, w.indexBuilder, w.indexFormat, w.blockLevels, w.indexRegion)
 	if err != nil {
 		return err
 	}
+	if err := w.closeDigests(); err != nil {
+		return err
+	}
+	if err := w.indexBuilder.Close(); err != nil {
+		return err
+	}
 	fileHeader.MutateIndexOffset(fileHeader.DataOffset() + fileHeader.DataSize())
 	fileHeader.MutateIndexSize(uint64(len(indexData)))
 
@@ -283,7 +462,8 @@ func Import(filePath string, tileIndex tile.LocationVisitor, tileDataReader io.R
 	header.Init(headerData, 0)
 
 	cfg.Logger.Println("libtiles: prepare index")
-	indexMap := make(index.Map)
+	indexBuilder := index.NewBuilder(cfg.MemoryLimit, cfg.TempDir)
+	defer indexBuilder.Close()
 	dataLocations := make([]tile.Location, 0)
 	dataLength := uint64(0)
 
@@ -292,6 +472,9 @@ func Import(filePath string, tileIndex tile.LocationVisitor, tileDataReader io.R
 	isFirst := true
 
 	err = tileIndex.VisitLocations(func(tileID tile.ID, location tile.Location) error {
+		if err := checkTile(tileID, cfg.IndexFormat, cfg.IndexRegion); err != nil {
+			return err
+		}
 		newOffset := lastNewOffset
 		if isFirst || location.Offset != lastOldOffset {
 			newOffset = dataLength
@@ -302,19 +485,24 @@ func Import(filePath string, tileIndex tile.LocationVisitor, tileDataReader io.R
 			lastNewOffset = newOffset
 			isFirst = false
 		}
-		indexMap[tileID] = packed.Pack(tile.Location{
+		return indexBuilder.Add(tileID, packed.Pack(tile.Location{
 			Offset: newOffset,
 			Length: location.Length,
-		})
-		return nil
+		}))
 	})
 	if err != nil {
 		return err
 	}
 
+	if cfg.HotPrefix > 0 {
+		cfg.Logger.Println("libtiles: prepare metadata")
+		hotPrefixLength := hotprefix.Length(dataLocations, cfg.HotPrefix)
+		cfg.Metadata = hotprefix.SetMetadata(cfg.Metadata, hotPrefixLength)
+	}
+
 	cfg.Logger.Println("libtiles: write index")
 	indexHeader := header.IndexHeader(nil)
-	indexData, err := writeIndex(indexHeader, indexMap, cfg.IndexFormat)
+	indexData, err := writeIndex(indexHeader, indexBuilder, cfg.IndexFormat, cfg.BlockLevels, cfg.IndexRegion)
 	if err != nil {
 		return err
 	}
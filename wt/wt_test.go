@@ -2,8 +2,10 @@ package wt_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"maps"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/wt"
 	"github.com/eak1mov/go-libtiles/wt/fbs"
+	"github.com/eak1mov/go-libtiles/wt/index/block"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -146,3 +149,457 @@ func TestImport(t *testing.T) {
 		}
 	}
 }
+
+func TestImportHotPrefix(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 0, Y: 0, Z: 2, Length: 3, Offset: 5},
+	}
+
+	testDataReader := bytes.NewReader(testData)
+	testItemsVisitor := index.ItemsVisitor(testItems)
+
+	filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+	err := wt.Import(
+		filePath,
+		testItemsVisitor,
+		testDataReader,
+		wt.WithMetadata([]byte(`{"foo":"bar"}`)),
+		wt.WithHotPrefix(4),
+	)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	reader, err := wt.NewFileReader(filePath, wt.WithPinnedHotPrefix(true))
+	if err != nil {
+		t.Fatalf("NewFileReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	hotPrefix, err := reader.HotPrefix()
+	if err != nil {
+		t.Fatalf("HotPrefix failed: %v", err)
+	}
+	if got, want := hotPrefix.Length, uint64(3); got != want {
+		t.Errorf("HotPrefix().Length = %v, want = %v", got, want)
+	}
+
+	for _, item := range testItems {
+		want := testData[item.Offset:][:item.Length]
+		got, err := reader.ReadTile(item.TileID())
+		if err != nil {
+			t.Fatalf("ReadTile(%v) failed: %v", item.TileID(), err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Fatalf("ReadTile(%v) = %s, want = %s", item.TileID(), got, want)
+		}
+	}
+}
+
+func TestMmapReader(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 0, Y: 0, Z: 2, Length: 3, Offset: 5},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+	err := wt.Import(
+		filePath,
+		index.ItemsVisitor(testItems),
+		bytes.NewReader(testData),
+		wt.WithMetadata([]byte(`{"foo":"bar"}`)),
+	)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	reader, err := wt.NewMmapReader(filePath)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("mmap is not supported")
+	}
+	if err != nil {
+		t.Fatalf("NewMmapReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	for _, item := range testItems {
+		want := testData[item.Offset:][:item.Length]
+		got, err := reader.ReadTile(item.TileID())
+		if err != nil {
+			t.Fatalf("ReadTile(%v) failed: %v", item.TileID(), err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Fatalf("ReadTile(%v) = %s, want = %s", item.TileID(), got, want)
+		}
+	}
+
+	got, err := reader.ReadTile(tile.ID{X: 1, Y: 1, Z: 1})
+	if err != nil || len(got) != 0 {
+		t.Errorf("ReadTile(missing) = %v, %v, want empty", got, err)
+	}
+}
+
+func TestReadTiles(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 0, Y: 0, Z: 2, Length: 3, Offset: 5},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+	err := wt.Import(
+		filePath,
+		index.ItemsVisitor(testItems),
+		bytes.NewReader(testData),
+		wt.WithMetadata([]byte(`{"foo":"bar"}`)),
+	)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	fileData, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	accessCount := 0
+	fileAccess := func(offset, length uint64) ([]byte, error) {
+		accessCount++
+		return fileData[offset:][:length], nil
+	}
+	reader, err := wt.NewReader(fileAccess)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+
+	tileIDs := []tile.ID{{X: 0, Y: 0, Z: 2}, {X: 1, Y: 1, Z: 1}, {X: 0, Y: 0, Z: 0}, {X: 0, Y: 0, Z: 1}}
+	want := [][]byte{[]byte("222"), {}, []byte("0"), []byte("11")}
+
+	accessCount = 0
+	got, err := reader.ReadTiles(tileIDs)
+	if err != nil {
+		t.Fatalf("ReadTiles failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReadTiles mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := accessCount, 2; got != want { // index root + tile data
+		t.Errorf("ReadTiles file accesses = %v, want = %v", got, want)
+	}
+}
+
+func TestIndexCache(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 5, Y: 7, Z: 9, Length: 3, Offset: 5},
+	}
+
+	for _, format := range []fbs.IndexFormat{
+		fbs.IndexFormatPlain,
+		fbs.IndexFormatSparse,
+	} {
+		t.Run(format.String(), func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+			err := wt.Import(
+				filePath,
+				index.ItemsVisitor(testItems),
+				bytes.NewReader(testData),
+				wt.WithIndexFormat(format),
+			)
+			if err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			fileData, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatalf("ReadFile failed: %v", err)
+			}
+
+			accessCount := 0
+			fileAccess := func(offset, length uint64) ([]byte, error) {
+				accessCount++
+				return fileData[offset:][:length], nil
+			}
+
+			for _, tc := range []struct {
+				name string
+				opts []wt.ReaderOption
+				want []int // file accesses for each ReadTile
+			}{
+				{"Cache", []wt.ReaderOption{wt.WithIndexCache(1 << 20)}, []int{1, 1, 2, 1}},
+				{"Preload", []wt.ReaderOption{wt.WithPreloadedIndex(2)}, []int{1, 1, 1, 1}},
+			} {
+				reader, err := wt.NewReader(fileAccess, tc.opts...)
+				if err != nil {
+					t.Fatalf("NewReader failed: %v", err)
+				}
+
+				for i, item := range append(testItems, testItems[2]) {
+					accessCount = 0
+					want := testData[item.Offset:][:item.Length]
+					got, err := reader.ReadTile(item.TileID())
+					if err != nil {
+						t.Fatalf("%s: ReadTile(%v) failed: %v", tc.name, item.TileID(), err)
+					}
+					if !cmp.Equal(got, want) {
+						t.Fatalf("%s: ReadTile(%v) = %s, want = %s", tc.name, item.TileID(), got, want)
+					}
+					if accessCount != tc.want[i] {
+						t.Errorf("%s: ReadTile(%v) file accesses = %v, want = %v", tc.name, item.TileID(), accessCount, tc.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestImportBlockLevels(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 5, Y: 7, Z: 9, Length: 3, Offset: 5},
+	}
+
+	for _, tc := range []struct {
+		name        string
+		blockLevels block.LevelsMask
+		want        int // file accesses for ReadTile(z9): index blocks + tile data
+	}{
+		{"Default", 0, 3},
+		{"One", block.NewLevelsMask(0), 2},
+		{"Three", block.NewLevelsMask(0, 3, 6), 4},
+		{"Clipped", block.NewLevelsMask(0, 5, 10, 15), 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+			err := wt.Import(
+				filePath,
+				index.ItemsVisitor(testItems),
+				bytes.NewReader(testData),
+				wt.WithBlockLevels(tc.blockLevels),
+			)
+			if err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			fileData, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatalf("ReadFile failed: %v", err)
+			}
+
+			accessCount := 0
+			fileAccess := func(offset, length uint64) ([]byte, error) {
+				accessCount++
+				return fileData[offset:][:length], nil
+			}
+			reader, err := wt.NewReader(fileAccess)
+			if err != nil {
+				t.Fatalf("NewReader failed: %v", err)
+			}
+
+			for _, item := range testItems {
+				accessCount = 0
+				want := testData[item.Offset:][:item.Length]
+				got, err := reader.ReadTile(item.TileID())
+				if err != nil {
+					t.Fatalf("ReadTile(%v) failed: %v", item.TileID(), err)
+				}
+				if !cmp.Equal(got, want) {
+					t.Fatalf("ReadTile(%v) = %s, want = %s", item.TileID(), got, want)
+				}
+			}
+			if accessCount != tc.want {
+				t.Errorf("ReadTile(%v) file accesses = %v, want = %v", testItems[2].TileID(), accessCount, tc.want)
+			}
+		})
+	}
+}
+
+func TestInvalidBlockLevels(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []wt.WriterOption
+	}{
+		{"NoZeroLevel", []wt.WriterOption{wt.WithBlockLevels(block.NewLevelsMask(5, 10))}},
+		{"TooLarge", []wt.WriterOption{wt.WithBlockLevels(block.NewLevelsMask(0, 26))}},
+		{"PlainFormat", []wt.WriterOption{wt.WithIndexFormat(fbs.IndexFormatPlain), wt.WithBlockLevels(block.NewLevelsMask(0, 5))}},
+	} {
+		filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+		if _, err := wt.NewWriter(filePath, tc.opts...); err == nil {
+			t.Errorf("%s: NewWriter() error = nil, want error", tc.name)
+		}
+	}
+}
+
+func TestIndexRegion(t *testing.T) {
+	root := tile.ID{X: 2200, Y: 1343, Z: 12}
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 2200, Y: 1343, Z: 12, Length: 1, Offset: 2},
+		{X: 35201, Y: 21490, Z: 16, Length: 2, Offset: 3},
+		{X: 563224, Y: 343855, Z: 20, Length: 3, Offset: 5},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+	writer, err := wt.NewWriter(filePath, wt.WithIndexFormat(fbs.IndexFormatPlain), wt.WithIndexRegion(root))
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	defer writer.Close()
+
+	for _, item := range testItems {
+		if err := writer.WriteTile(item.TileID(), testData[item.Offset:][:item.Length]); err != nil {
+			t.Fatalf("WriteTile(%v) failed: %v", item.TileID(), err)
+		}
+	}
+	for _, tileID := range []tile.ID{{X: 0, Y: 0, Z: 0}, {X: 2201, Y: 1343, Z: 12}, {X: 0, Y: 0, Z: 16}} {
+		if err := writer.WriteTile(tileID, []byte("x")); err == nil {
+			t.Errorf("WriteTile(%v) error = nil, want error", tileID)
+		}
+	}
+	if err := writer.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+
+	reader, err := wt.NewFileReader(filePath)
+	if err != nil {
+		t.Fatalf("NewFileReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	want := make(map[tile.ID][]byte)
+	for _, item := range testItems {
+		want[item.TileID()] = testData[item.Offset:][:item.Length]
+		got, err := reader.ReadTile(item.TileID())
+		if err != nil {
+			t.Fatalf("ReadTile(%v) failed: %v", item.TileID(), err)
+		}
+		if !cmp.Equal(got, want[item.TileID()]) {
+			t.Errorf("ReadTile(%v) = %s, want = %s", item.TileID(), got, want[item.TileID()])
+		}
+	}
+	for _, tileID := range []tile.ID{{X: 0, Y: 0, Z: 0}, {X: 2201, Y: 1343, Z: 12}, {X: 35201, Y: 21491, Z: 17}} {
+		got, err := reader.ReadTile(tileID)
+		if err != nil || len(got) != 0 {
+			t.Errorf("ReadTile(%v) = %v, %v, want empty", tileID, got, err)
+		}
+	}
+
+	got := maps.Collect(tile.IterTiles(reader))
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("IterTiles mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	testData := []byte("xx011222")
+	testItems := []index.Item{
+		{X: 0, Y: 0, Z: 0, Length: 1, Offset: 2},
+		{X: 0, Y: 0, Z: 1, Length: 2, Offset: 3},
+		{X: 5, Y: 7, Z: 9, Length: 3, Offset: 5},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+	err := wt.Import(
+		filePath,
+		index.ItemsVisitor(testItems),
+		bytes.NewReader(testData),
+		wt.WithMetadata([]byte(`{"foo":"bar"}`)),
+	)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	for _, format := range []fbs.IndexFormat{
+		fbs.IndexFormatPlain,
+		fbs.IndexFormatBasicPlain,
+		fbs.IndexFormatSparse,
+	} {
+		if err := wt.Reindex(filePath, wt.WithIndexFormat(format)); err != nil {
+			t.Fatalf("Reindex(%v) failed: %v", format, err)
+		}
+
+		reader, err := wt.NewFileReader(filePath)
+		if err != nil {
+			t.Fatalf("NewFileReader failed: %v", err)
+		}
+		defer reader.Close()
+
+		want := make(map[tile.ID][]byte)
+		for _, item := range testItems {
+			want[item.TileID()] = testData[item.Offset:][:item.Length]
+		}
+		got := maps.Collect(tile.IterTiles(reader))
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Reindex(%v): IterTiles mismatch (-want +got):\n%s", format, diff)
+		}
+		metadata, err := reader.ReadMetadata()
+		if err != nil || string(metadata) != `{"foo":"bar"}` {
+			t.Errorf("Reindex(%v): ReadMetadata() = %s, %v", format, metadata, err)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for _, format := range []fbs.IndexFormat{
+		fbs.IndexFormatPlain,
+		fbs.IndexFormatBasicPlain,
+		fbs.IndexFormatSparse,
+	} {
+		tempDir := t.TempDir()
+		filePath := filepath.Join(t.TempDir(), "tiles.wtiles")
+		writer, err := wt.NewWriter(
+			filePath,
+			wt.WithIndexFormat(format),
+			wt.WithMemoryLimit(64), // 4 entries per run, and 1 digest of deduplication
+			wt.WithTempDir(tempDir),
+		)
+		if err != nil {
+			t.Fatalf("NewWriter failed: %v", err)
+		}
+		defer writer.Close()
+
+		want := make(map[tile.ID][]byte)
+		for z := uint32(4); z <= 4; z-- {
+			for x := range uint32(1) << z {
+				for y := range uint32(1) << z {
+					tileID := tile.ID{X: x, Y: y, Z: z}
+					want[tileID] = fmt.Appendf(nil, "%d/%d/%d", z, x, y)
+				}
+			}
+		}
+		for tileID := range want {
+			if err := writer.WriteTile(tileID, []byte("old")); err != nil {
+				t.Fatalf("WriteTile failed: %v", err)
+			}
+		}
+		for tileID, tileData := range want {
+			if err := writer.WriteTile(tileID, tileData); err != nil {
+				t.Fatalf("WriteTile failed: %v", err)
+			}
+		}
+		if err := writer.Finalize(); err != nil {
+			t.Fatalf("Finalize failed: %v", err)
+		}
+		if tempFiles, _ := os.ReadDir(tempDir); len(tempFiles) != 0 {
+			t.Errorf("format %v: temporary files are not removed: %v", format, tempFiles)
+		}
+
+		reader, err := wt.NewFileReader(filePath)
+		if err != nil {
+			t.Fatalf("NewFileReader failed: %v", err)
+		}
+		defer reader.Close()
+
+		got := maps.Collect(tile.IterTiles(reader))
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("format %v: IterTiles mismatch (-want +got):\n%s", format, diff)
+		}
+	}
+}
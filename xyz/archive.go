@@ -0,0 +1,39 @@
+package xyz
+
+import (
+	"errors"
+	"path"
+	"time"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// ErrNotFinalized is returned by Close of archive writers if Finalize wasn't
+// called, the archive is incomplete then.
+var ErrNotFinalized = errors.New("libtiles: archive closed without Finalize")
+
+// archiveModTime returns the modification time of files written to archives,
+// in whole seconds as both tar and zip headers store it.
+func archiveModTime() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// archiveMetadataPath returns the path of the metadata file of the pattern
+// inside an archive.
+func archiveMetadataPath(pattern *filePattern) string {
+	return path.Join(pattern.rootDir(), MetadataFileName)
+}
+
+// matchArchivePath returns the tile of the file path inside an archive, or
+// false if it isn't a tile. Paths not matching the pattern are handled by the
+// unmatched policy, except the metadata file.
+func matchArchivePath(pattern *filePattern, config *readerConfig, filePath string) (tile.ID, bool, error) {
+	tileID, ok := pattern.parse(filePath)
+	if ok {
+		return tileID, true, nil
+	}
+	if filePath == archiveMetadataPath(pattern) {
+		return tile.ID{}, false, nil
+	}
+	return tile.ID{}, false, config.unmatched(pattern, filePath)
+}
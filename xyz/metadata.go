@@ -0,0 +1,229 @@
+package xyz
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// MetadataFileName is the name of the metadata file in the root directory of
+// a file pattern (the longest directory without placeholders).
+const MetadataFileName = "metadata.json"
+
+// Metadata is TileJSON-style metadata of tiles in XYZ format. Format names are
+// the ones of MBTiles: png, jpg, webp, avif or pbf (vector tiles).
+type Metadata struct {
+	TileJSON     string          `json:"tilejson,omitempty"`
+	Name         string          `json:"name,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	Attribution  string          `json:"attribution,omitempty"`
+	Format       string          `json:"format,omitempty"`
+	MinZoom      uint32          `json:"minzoom"`
+	MaxZoom      uint32          `json:"maxzoom"`
+	Bounds       []float64       `json:"bounds,omitempty"` // west, south, east, north
+	Center       []float64       `json:"center,omitempty"` // longitude, latitude, zoom
+	VectorLayers json.RawMessage `json:"vector_layers,omitempty"`
+}
+
+// ReadMetadata reads the metadata file of the pattern (see MetadataFileName).
+// If it doesn't exist, metadata is estimated from names of directories without
+// listing tile files: each directory is assumed to be full of tiles, e.g. for
+// "{z}/{x}/{y}.png" bounds span whole columns. Use InferMetadata for exact
+// bounds.
+func (r *Reader) ReadMetadata() (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(r.pattern.rootDir(), MetadataFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return r.estimateMetadata()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadata(data)
+}
+
+// InferMetadata derives metadata from tile files: format from the file
+// extension of the pattern or from contents of a tile, zoom range and bounds
+// from paths of all tiles. Tiles data is not read, except a single tile.
+func (r *Reader) InferMetadata() (*Metadata, error) {
+	var b metadataBuilder
+	var samplePath string
+	err := r.walk(&visitConfig{MinZoom: 0, MaxZoom: 31}, walkPaths, func(result walkResult) error {
+		if samplePath == "" {
+			samplePath = result.filePath
+		}
+		b.add(result.tileID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.build(r.pattern, func() ([]byte, error) { return os.ReadFile(samplePath) })
+}
+
+// estimateMetadata is like InferMetadata, but bounds are derived from the
+// deepest directories of the pattern. Without zoom levels in directory names
+// it falls back to InferMetadata.
+func (r *Reader) estimateMetadata() (*Metadata, error) {
+	dirs := r.walkSegments[:len(r.walkSegments)-1]
+	hasZoom := slices.ContainsFunc(dirs, func(s segment) bool {
+		return slices.ContainsFunc(s.placeholders, func(ph placeholder) bool { return ph.name == "z" && ph.divisor == 1 })
+	})
+	if !hasZoom {
+		return r.InferMetadata()
+	}
+
+	var b metadataBuilder
+	err := r.walk(&visitConfig{MinZoom: 0, MaxZoom: 31}, walkDirs, func(result walkResult) error {
+		if bounds, ok := directoryBounds(result.values); ok {
+			b.addBounds(bounds)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.build(r.pattern, r.readSample)
+}
+
+// readSample reads data of the first found tile.
+func (r *Reader) readSample() ([]byte, error) {
+	errFound := errors.New("found")
+	var samplePath string
+	err := r.walk(&visitConfig{MinZoom: 0, MaxZoom: 31}, walkPaths, func(result walkResult) error {
+		samplePath = result.filePath
+		return errFound
+	})
+	if err != nil && err != errFound {
+		return nil, err
+	}
+	if samplePath == "" {
+		return make([]byte, 0), nil
+	}
+	return os.ReadFile(samplePath)
+}
+
+func parseMetadata(data []byte) (*Metadata, error) {
+	var metadata Metadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// metadataBuilder infers metadata from tiles of a pattern.
+type metadataBuilder struct {
+	tileBounds map[uint32]*tile.Bounds
+}
+
+func (m *metadataBuilder) add(tileID tile.ID) {
+	m.addBounds(tile.Bounds{Z: tileID.Z, MinX: tileID.X, MinY: tileID.Y, MaxX: tileID.X, MaxY: tileID.Y})
+}
+
+func (m *metadataBuilder) addBounds(bounds tile.Bounds) {
+	if m.tileBounds == nil {
+		m.tileBounds = make(map[uint32]*tile.Bounds)
+	}
+	b, found := m.tileBounds[bounds.Z]
+	if !found {
+		m.tileBounds[bounds.Z] = &bounds
+		return
+	}
+	b.MinX, b.MaxX = min(b.MinX, bounds.MinX), max(b.MaxX, bounds.MaxX)
+	b.MinY, b.MaxY = min(b.MinY, bounds.MinY), max(b.MaxY, bounds.MaxY)
+}
+
+// build returns metadata of added tiles, readSample reads data of any of them
+// if the format can't be derived from the pattern.
+func (m *metadataBuilder) build(pattern *filePattern, readSample func() ([]byte, error)) (*Metadata, error) {
+	metadata := &Metadata{
+		TileJSON: "3.0.0",
+		Format:   formatByExtension(pattern.literals[len(pattern.literals)-1]),
+	}
+	if m.tileBounds == nil {
+		return metadata, nil // no tiles
+	}
+
+	if metadata.Format == "" {
+		tileData, err := readSample()
+		if err != nil {
+			return nil, err
+		}
+		metadata.Format = formatByContents(tileData)
+	}
+
+	metadata.MinZoom, metadata.MaxZoom = math.MaxUint32, 0
+	west, south, east, north := 180.0, 90.0, -180.0, -90.0
+	for z, b := range m.tileBounds {
+		metadata.MinZoom, metadata.MaxZoom = min(metadata.MinZoom, z), max(metadata.MaxZoom, z)
+		lon0, lat0 := tileLonLat(z, b.MinX, b.MinY)
+		lon1, lat1 := tileLonLat(z, b.MaxX+1, b.MaxY+1)
+		west, east = min(west, lon0), max(east, lon1)
+		south, north = min(south, lat1), max(north, lat0)
+	}
+	metadata.Bounds = []float64{west, south, east, north}
+	metadata.Center = []float64{(west + east) / 2, (south + north) / 2, float64(metadata.MinZoom)}
+	return metadata, nil
+}
+
+// tileLonLat returns coordinates of the north-west corner of the tile.
+func tileLonLat(z, x, y uint32) (float64, float64) {
+	n := float64(uint64(1) << z)
+	lon := float64(x)/n*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+	return lon, lat
+}
+
+func formatByExtension(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".png":
+		return "png"
+	case ".jpg", ".jpeg":
+		return "jpg"
+	case ".webp":
+		return "webp"
+	case ".avif":
+		return "avif"
+	case ".pbf", ".mvt":
+		return "pbf"
+	default:
+		return ""
+	}
+}
+
+func formatByContents(tileData []byte) string {
+	switch {
+	case bytes.HasPrefix(tileData, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(tileData, []byte{0xff, 0xd8, 0xff}):
+		return "jpg"
+	case len(tileData) >= 12 && string(tileData[:4]) == "RIFF" && string(tileData[8:12]) == "WEBP":
+		return "webp"
+	case len(tileData) >= 12 && string(tileData[4:12]) == "ftypavif":
+		return "avif"
+	case bytes.HasPrefix(tileData, []byte{0x1f, 0x8b}):
+		return "pbf" // gzip-compressed vector tile
+	default:
+		return ""
+	}
+}
+
+// writeMetadata writes the metadata file of the pattern.
+func (w *Writer) writeMetadata() error {
+	data, err := json.MarshalIndent(w.metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	rootDir := w.pattern.rootDir()
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(rootDir, MetadataFileName), data, w.sync)
+}
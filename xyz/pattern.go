@@ -1,10 +1,25 @@
 // Package xyz provides API for reading and writing tiles in XYZ directory format,
 // where tiles are stored as individual files with paths like "/z/x/y.ext".
+//
+// File patterns contain placeholders replaced by tile coordinates:
+//
+//	{z}, {x}, {y}  zoom level, column and row of the tile in XYZ scheme
+//	{-y}           row of the tile in TMS scheme (flipped y)
+//	{q}            quadkey of the tile (Bing Maps scheme), e.g. "0231"
+//
+// Placeholders of numbers may be divided and zero-padded, e.g. {x/1000} is
+// column divided by 1000 (for fan-out of large directories), {y:4} is row
+// padded with zeros to 4 digits, {x/1000:03} is both. Padding is used to
+// format paths only: parsed numbers may have any number of leading zeros. A pattern must contain
+// either {q}, or {z}, {x} and one of {y}, {-y} without division.
 package xyz
 
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/eak1mov/go-libtiles/tile"
@@ -12,19 +27,218 @@ import (
 
 var ErrInvalidPattern = errors.New("libtiles: invalid file pattern")
 
-func validatePattern(pattern string) error {
-	for _, p := range []string{"{x}", "{y}", "{z}"} {
-		if !strings.Contains(pattern, p) {
-			return fmt.Errorf("%w: placeholder %v not found", ErrInvalidPattern, p)
+var placeholderRegexp = regexp.MustCompile(`\{(z|x|y|-y|q)(?:/([1-9][0-9]*))?(?::([0-9]+))?\}`)
+
+// placeholder is a parsed placeholder of a file pattern.
+type placeholder struct {
+	name    string // z, x, y, -y or q
+	divisor uint32 // 1 if not divided
+	width   int    // minimal number of digits
+}
+
+func (p placeholder) format(tileID tile.ID) string {
+	if p.name == "q" {
+		return quadkey(tileID)
+	}
+	return fmt.Sprintf("%0*d", p.width, p.value(tileID))
+}
+
+// value returns the number of the placeholder (except {q}) for the tile.
+func (p placeholder) value(tileID tile.ID) uint32 {
+	var value uint32
+	switch p.name {
+	case "z":
+		value = tileID.Z
+	case "x":
+		value = tileID.X
+	case "y":
+		value = tileID.Y
+	case "-y":
+		value = (1 << tileID.Z) - 1 - tileID.Y // XYZ -> TMS
+	}
+	return value / p.divisor
+}
+
+// filePattern is a parsed file pattern, literals surround placeholders.
+type filePattern struct {
+	source       string
+	literals     []string // len(placeholders) + 1 items
+	placeholders []placeholder
+	pathRegexp   *regexp.Regexp
+}
+
+func parsePattern(pattern string) (*filePattern, error) {
+	p := &filePattern{source: pattern}
+	regexPattern := "^"
+	start := 0
+	for _, match := range placeholderRegexp.FindAllStringSubmatchIndex(pattern, -1) {
+		ph := placeholder{name: pattern[match[2]:match[3]], divisor: 1}
+		if match[4] >= 0 {
+			divisor, err := strconv.ParseUint(pattern[match[4]:match[5]], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+			}
+			ph.divisor = uint32(divisor)
+		}
+		if match[6] >= 0 {
+			ph.width, _ = strconv.Atoi(pattern[match[6]:match[7]])
+		}
+		if ph.name == "q" && (ph.divisor != 1 || ph.width != 0) {
+			return nil, fmt.Errorf("%w: placeholder {q} can't be divided or padded", ErrInvalidPattern)
+		}
+
+		literal := pattern[start:match[0]]
+		if strings.ContainsAny(literal, "{}") {
+			return nil, fmt.Errorf("%w: invalid placeholder in %q", ErrInvalidPattern, literal)
+		}
+		p.literals = append(p.literals, literal)
+		p.placeholders = append(p.placeholders, ph)
+		regexPattern += regexp.QuoteMeta(literal)
+		if ph.name == "q" {
+			regexPattern += "([0-3]*)"
+		} else {
+			regexPattern += "([0-9]+)"
+		}
+		start = match[1]
+	}
+	literal := pattern[start:]
+	if strings.ContainsAny(literal, "{}") {
+		return nil, fmt.Errorf("%w: invalid placeholder in %q", ErrInvalidPattern, literal)
+	}
+	p.literals = append(p.literals, literal)
+	regexPattern += regexp.QuoteMeta(literal) + "$"
+
+	if !p.has("q") {
+		for _, name := range []string{"z", "x", "y"} {
+			if !p.has(name) && !(name == "y" && p.has("-y")) {
+				return nil, fmt.Errorf("%w: placeholder {%v} not found", ErrInvalidPattern, name)
+			}
+		}
+	}
+
+	var err error
+	if p.pathRegexp, err = regexp.Compile(regexPattern); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+	}
+	return p, nil
+}
+
+// has reports whether the pattern has the placeholder without division.
+func (p *filePattern) has(name string) bool {
+	for _, ph := range p.placeholders {
+		if ph.name == name && ph.divisor == 1 {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-func formatPattern(pattern string, tileID tile.ID) string {
-	result := pattern
-	result = strings.ReplaceAll(result, "{x}", fmt.Sprintf("%d", tileID.X))
-	result = strings.ReplaceAll(result, "{y}", fmt.Sprintf("%d", tileID.Y))
-	result = strings.ReplaceAll(result, "{z}", fmt.Sprintf("%d", tileID.Z))
-	return result
+func (p *filePattern) format(tileID tile.ID) string {
+	var b strings.Builder
+	for i, ph := range p.placeholders {
+		b.WriteString(p.literals[i])
+		b.WriteString(ph.format(tileID))
+	}
+	b.WriteString(p.literals[len(p.literals)-1])
+	return b.String()
+}
+
+// parse returns the tile of the file path, or false if the path doesn't match
+// the pattern.
+func (p *filePattern) parse(filePath string) (tile.ID, bool) {
+	matches := p.pathRegexp.FindStringSubmatch(filePath)
+	if matches == nil {
+		return tile.ID{}, false
+	}
+
+	var tileID tile.ID
+	var tmsY uint64
+	hasTMS := false
+	for i, ph := range p.placeholders {
+		if ph.divisor != 1 {
+			continue // checked below, when the tile is known
+		}
+		if ph.name == "q" {
+			var ok bool
+			if tileID, ok = parseQuadkey(matches[i+1]); !ok {
+				return tile.ID{}, false
+			}
+			continue
+		}
+		value, err := strconv.ParseUint(matches[i+1], 10, 32)
+		if err != nil {
+			return tile.ID{}, false
+		}
+		switch ph.name {
+		case "z":
+			tileID.Z = uint32(value)
+		case "x":
+			tileID.X = uint32(value)
+		case "y":
+			tileID.Y = uint32(value)
+		case "-y":
+			tmsY, hasTMS = value, true
+		}
+	}
+	if hasTMS && !p.has("y") && !p.has("q") {
+		if tileID.Z >= 32 || tmsY >= 1<<tileID.Z {
+			return tile.ID{}, false
+		}
+		tileID.Y = uint32((1 << tileID.Z) - 1 - tmsY) // TMS -> XYZ
+	}
+
+	// all placeholders (e.g. divided ones) must be consistent with the tile,
+	// numbers may be padded with any number of zeros
+	if !tileID.Valid() {
+		return tile.ID{}, false
+	}
+	for i, ph := range p.placeholders {
+		if ph.name == "q" {
+			if matches[i+1] != quadkey(tileID) {
+				return tile.ID{}, false
+			}
+			continue
+		}
+		value, err := strconv.ParseUint(matches[i+1], 10, 32)
+		if err != nil || uint32(value) != ph.value(tileID) {
+			return tile.ID{}, false
+		}
+	}
+	return tileID, true
+}
+
+// rootDir returns the directory containing all files of the pattern.
+func (p *filePattern) rootDir() string {
+	return filepath.Dir(p.literals[0] + "_")
+}
+
+func quadkey(tileID tile.ID) string {
+	key := make([]byte, tileID.Z)
+	for i := range tileID.Z {
+		mask := uint32(1) << (tileID.Z - 1 - i)
+		digit := byte('0')
+		if tileID.X&mask != 0 {
+			digit++
+		}
+		if tileID.Y&mask != 0 {
+			digit += 2
+		}
+		key[i] = digit
+	}
+	return string(key)
+}
+
+func parseQuadkey(key string) (tile.ID, bool) {
+	if len(key) >= 32 {
+		return tile.ID{}, false
+	}
+	tileID := tile.ID{Z: uint32(len(key))}
+	for _, digit := range []byte(key) {
+		if digit < '0' || digit > '3' {
+			return tile.ID{}, false
+		}
+		tileID.X = tileID.X<<1 | uint32(digit-'0')&1
+		tileID.Y = tileID.Y<<1 | uint32(digit-'0')>>1
+	}
+	return tileID, true
 }
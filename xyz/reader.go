@@ -3,51 +3,92 @@ package xyz
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"log"
 	"os"
-	"path/filepath"
-	"regexp"
-	"strconv"
-	"strings"
 
 	"github.com/eak1mov/go-libtiles/tile"
 )
 
 // Reader implements tile.Reader interface for tiles in XYZ format.
+//
+// VisitTiles reads directories of the pattern (e.g. {z} and {x} ones) and
+// tile files concurrently, and calls the visitor function sequentially.
 type Reader struct {
-	filePattern string
-	rootDir     string
-	pathRegexp  *regexp.Regexp
+	pattern      *filePattern
+	config       readerConfig
+	walkRoot     string // empty or ending with a separator
+	walkSegments []segment
 }
 
-// NewReader creates a new Reader for the given file pattern (e.g. "/home/user/tiles/{z}/{x}/{y}.png").
-func NewReader(filePattern string) (*Reader, error) {
-	if err := validatePattern(filePattern); err != nil {
-		return nil, err
+type readerConfig struct {
+	WalkConcurrency int
+	UnmatchedPolicy UnmatchedPolicy
+	Logger          *log.Logger
+}
+
+type ReaderOption func(*readerConfig)
+
+// WithWalkConcurrency sets the number of goroutines reading directories and
+// tile files in VisitTiles (default is 16).
+func WithWalkConcurrency(concurrency int) ReaderOption {
+	return func(c *readerConfig) { c.WalkConcurrency = concurrency }
+}
+
+// WithUnmatchedPolicy sets handling of files and directories which don't match
+// the pattern (default is UnmatchedIgnore).
+func WithUnmatchedPolicy(policy UnmatchedPolicy) ReaderOption {
+	return func(c *readerConfig) { c.UnmatchedPolicy = policy }
+}
+
+// WithLogger sets the logger for UnmatchedWarn policy (default is log.Default()).
+func WithLogger(logger *log.Logger) ReaderOption {
+	return func(c *readerConfig) { c.Logger = logger }
+}
+
+func newReaderConfig(opts []ReaderOption) readerConfig {
+	config := readerConfig{
+		WalkConcurrency: 16,
+		UnmatchedPolicy: UnmatchedIgnore,
+		Logger:          log.Default(),
+	}
+	for _, opt := range opts {
+		opt(&config)
 	}
+	config.WalkConcurrency = max(config.WalkConcurrency, 1)
+	if config.Logger == nil {
+		config.Logger = log.New(io.Discard, "", 0)
+	}
+	return config
+}
 
-	regexPattern := regexp.QuoteMeta(filePattern)
-	regexPattern = strings.ReplaceAll(regexPattern, "\\{x\\}", "(?P<x>\\d+)")
-	regexPattern = strings.ReplaceAll(regexPattern, "\\{y\\}", "(?P<y>\\d+)")
-	regexPattern = strings.ReplaceAll(regexPattern, "\\{z\\}", "(?P<z>\\d+)")
-	pathRegex, err := regexp.Compile("^" + regexPattern + "$")
-	if err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+// unmatched handles the path of a file or directory which doesn't match the
+// pattern according to UnmatchedPolicy.
+func (c *readerConfig) unmatched(pattern *filePattern, filePath string) error {
+	switch c.UnmatchedPolicy {
+	case UnmatchedWarn:
+		c.Logger.Printf("libtiles: %s doesn't match pattern %s", filePath, pattern.source)
+	case UnmatchedError:
+		return fmt.Errorf("%w: %s", ErrUnmatchedFile, filePath)
 	}
+	return nil
+}
 
-	path0 := formatPattern(filePattern, tile.ID{X: 0, Y: 0, Z: 0})
-	path1 := formatPattern(filePattern, tile.ID{X: 1, Y: 1, Z: 1})
-	for path0 != path1 {
-		path0 = filepath.Dir(path0)
-		path1 = filepath.Dir(path1)
+// NewReader creates a new Reader for the given file pattern (e.g. "/home/user/tiles/{z}/{x}/{y}.png").
+func NewReader(filePattern string, opts ...ReaderOption) (*Reader, error) {
+	pattern, err := parsePattern(filePattern)
+	if err != nil {
+		return nil, err
 	}
-	rootDir := path0
 
-	return &Reader{filePattern, rootDir, pathRegex}, nil
+	r := &Reader{pattern: pattern, config: newReaderConfig(opts)}
+	r.walkRoot, r.walkSegments = pattern.walkSegments()
+	return r, nil
 }
 
 func (r *Reader) ReadTile(tileID tile.ID) ([]byte, error) {
-	filePath := formatPattern(r.filePattern, tileID)
+	filePath := r.pattern.format(tileID)
 	tileData, err := os.ReadFile(filePath)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
@@ -59,29 +100,17 @@ func (r *Reader) ReadTile(tileID tile.ID) ([]byte, error) {
 }
 
 func (r *Reader) VisitTiles(fn tile.VisitFunc) error {
-	return filepath.WalkDir(r.rootDir, func(filePath string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if d.IsDir() {
-			return nil
-		}
-
-		matches := r.pathRegexp.FindStringSubmatch(filePath)
-		if matches == nil {
-			return nil // TODO(eak1mov): should we return error?
-		}
-
-		x, _ := strconv.Atoi(matches[r.pathRegexp.SubexpIndex("x")])
-		y, _ := strconv.Atoi(matches[r.pathRegexp.SubexpIndex("y")])
-		z, _ := strconv.Atoi(matches[r.pathRegexp.SubexpIndex("z")])
-
-		tileData, err := os.ReadFile(filePath)
-		if err != nil {
-			return err
-		}
+	return r.Visit(fn)
+}
 
-		return fn(tile.ID{X: uint32(x), Y: uint32(y), Z: uint32(z)}, tileData)
+// Visit calls fn for tiles selected by options (all tiles by default), in no
+// particular order.
+func (r *Reader) Visit(fn tile.VisitFunc, opts ...VisitOption) error {
+	config := visitConfig{MinZoom: 0, MaxZoom: 31}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return r.walk(&config, walkData, func(result walkResult) error {
+		return fn(result.tileID, result.tileData)
 	})
 }
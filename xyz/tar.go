@@ -0,0 +1,328 @@
+package xyz
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// TarReader implements tile.Reader and tile.Visitor interfaces for tiles in
+// XYZ format inside a tar archive. Paths of tiles match a file pattern relative
+// to the archive root (e.g. "{z}/{x}/{y}.png").
+//
+// VisitTiles reads the archive sequentially, like a stream. ReadTile indexes
+// locations of all files in the archive on the first call, ReadMetadata too if
+// the metadata file isn't the first file (as written by TarWriter). Unmatched
+// files are handled only by the first complete read of the archive.
+type TarReader struct {
+	file    *os.File
+	size    int64
+	pattern *filePattern
+	config  readerConfig
+
+	indexOnce sync.Once
+	index     map[tile.ID]tarEntry
+	metadata  *tarEntry
+	indexErr  error
+	scanned   atomic.Bool // unmatched files are handled already
+}
+
+// tarEntry is a location of file data in the archive.
+type tarEntry struct {
+	offset int64
+	size   int64
+}
+
+// NewTarReader creates a new TarReader for the archive (e.g. "tiles.tar") and
+// the file pattern of its tiles (e.g. "{z}/{x}/{y}.png").
+// Options WithUnmatchedPolicy and WithLogger are applied, other ones are ignored.
+func NewTarReader(filePath, filePattern string, opts ...ReaderOption) (*TarReader, error) {
+	pattern, err := parsePattern(filePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &TarReader{file: file, size: info.Size(), pattern: pattern, config: newReaderConfig(opts)}, nil
+}
+
+func (r *TarReader) Close() error {
+	return r.file.Close()
+}
+
+func (r *TarReader) ReadTile(tileID tile.ID) ([]byte, error) {
+	if err := r.buildIndex(); err != nil {
+		return nil, err
+	}
+	entry, found := r.index[tileID]
+	if !found {
+		return make([]byte, 0), nil
+	}
+	return r.readEntry(entry)
+}
+
+func (r *TarReader) VisitTiles(fn tile.VisitFunc) error {
+	return r.visitFiles(func(filePath string, entry tarEntry, tr *tar.Reader, report bool) error {
+		tileID, ok, err := r.match(filePath, report)
+		if !ok {
+			return err
+		}
+		tileData, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		return fn(tileID, tileData)
+	})
+}
+
+// ReadMetadata reads the metadata file of the pattern (see MetadataFileName).
+// If it doesn't exist, metadata is inferred from tile paths (see Reader.InferMetadata).
+func (r *TarReader) ReadMetadata() (*Metadata, error) {
+	entry, found, err := r.firstMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if err := r.buildIndex(); err != nil {
+			return nil, err
+		}
+		if r.metadata != nil {
+			entry, found = *r.metadata, true
+		}
+	}
+	if found {
+		data, err := r.readEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		return parseMetadata(data)
+	}
+
+	var b metadataBuilder
+	var sample tarEntry
+	for tileID, entry := range r.index {
+		sample = entry
+		b.add(tileID)
+	}
+	return b.build(r.pattern, func() ([]byte, error) { return r.readEntry(sample) })
+}
+
+func (r *TarReader) buildIndex() error {
+	r.indexOnce.Do(func() {
+		index := make(map[tile.ID]tarEntry)
+		r.indexErr = r.visitFiles(func(filePath string, entry tarEntry, tr *tar.Reader, report bool) error {
+			if filePath == archiveMetadataPath(r.pattern) {
+				r.metadata = &entry
+			}
+			tileID, ok, err := r.match(filePath, report)
+			if ok {
+				index[tileID] = entry
+			}
+			return err
+		})
+		r.index = index
+	})
+	return r.indexErr
+}
+
+// firstMetadata returns the location of the metadata file if it's the first
+// file of the archive.
+func (r *TarReader) firstMetadata() (tarEntry, bool, error) {
+	section := io.NewSectionReader(r.file, 0, r.size)
+	tr := tar.NewReader(section)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return tarEntry{}, false, nil
+		}
+		if err != nil {
+			return tarEntry{}, false, err
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if !header.FileInfo().Mode().IsRegular() || path.Clean(header.Name) != archiveMetadataPath(r.pattern) {
+			return tarEntry{}, false, nil
+		}
+		offset, err := section.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return tarEntry{}, false, err
+		}
+		return tarEntry{offset: offset, size: header.Size}, true, nil
+	}
+}
+
+// match is matchArchivePath, but unmatched files are handled only if report
+// is set.
+func (r *TarReader) match(filePath string, report bool) (tile.ID, bool, error) {
+	if report {
+		return matchArchivePath(r.pattern, &r.config, filePath)
+	}
+	tileID, ok := r.pattern.parse(filePath)
+	return tileID, ok, nil
+}
+
+func (r *TarReader) readEntry(entry tarEntry) ([]byte, error) {
+	data := make([]byte, entry.size)
+	if _, err := r.file.ReadAt(data, entry.offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// visitFiles calls fn for each regular file of the archive, tr is positioned
+// at the start of its data. Unmatched files are reported (report is set) until
+// the archive is read completely once.
+func (r *TarReader) visitFiles(fn func(filePath string, entry tarEntry, tr *tar.Reader, report bool) error) error {
+	report := !r.scanned.Load()
+	// independent position for concurrent calls, tar reader skips data by seeking
+	section := io.NewSectionReader(r.file, 0, r.size)
+	tr := tar.NewReader(section)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			r.scanned.Store(true)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		filePath := path.Clean(header.Name)
+		switch {
+		case header.Typeflag == tar.TypeDir:
+			continue
+		case !header.FileInfo().Mode().IsRegular():
+			if report {
+				if err := r.config.unmatched(r.pattern, filePath); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		offset, err := section.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if err := fn(filePath, tarEntry{offset: offset, size: header.Size}, tr, report); err != nil {
+			return err
+		}
+	}
+}
+
+// TarWriter implements tile.Writer interface for tiles in XYZ format inside a
+// tar archive. Paths of tiles are formatted by a file pattern relative to the
+// archive root (e.g. "{z}/{x}/{y}.png").
+//
+// Metadata is written first, so that readers find it without reading the
+// whole archive. Finalize must be called after all tiles are written, and Close after it.
+type TarWriter struct {
+	file      *os.File
+	buffer    *bufio.Writer
+	tw        *tar.Writer
+	pattern   *filePattern
+	modTime   time.Time
+	finalized bool
+}
+
+// NewTarWriter creates a new TarWriter for the archive (e.g. "tiles.tar") and
+// the file pattern of its tiles (e.g. "{z}/{x}/{y}.png").
+// Option WithMetadata is applied, other ones are ignored.
+func NewTarWriter(filePath, filePattern string, opts ...WriterOption) (*TarWriter, error) {
+	var config writerConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	pattern, err := parsePattern(filePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufio.NewWriter(file)
+	w := &TarWriter{
+		file:    file,
+		buffer:  buffer,
+		tw:      tar.NewWriter(buffer),
+		pattern: pattern,
+		modTime: archiveModTime(),
+	}
+	if config.Metadata != nil {
+		if err := w.writeMetadata(config.Metadata); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Close closes the archive file, it returns ErrNotFinalized if Finalize wasn't
+// called successfully.
+func (w *TarWriter) Close() error {
+	err := w.file.Close()
+	if !w.finalized {
+		return errors.Join(ErrNotFinalized, err)
+	}
+	return err
+}
+
+func (w *TarWriter) WriteTile(tileID tile.ID, tileData []byte) error {
+	return w.writeFile(w.pattern.format(tileID), tileData)
+}
+
+// Finalize writes the end of the archive.
+func (w *TarWriter) Finalize() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if err := w.buffer.Flush(); err != nil {
+		return err
+	}
+	w.finalized = true
+	return nil
+}
+
+func (w *TarWriter) writeMetadata(metadata *Metadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.writeFile(archiveMetadataPath(w.pattern), data)
+}
+
+func (w *TarWriter) writeFile(filePath string, data []byte) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filePath,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  w.modTime,
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(data)
+	return err
+}
@@ -0,0 +1,335 @@
+package xyz
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/eak1mov/go-libtiles/tile"
+	"golang.org/x/sync/errgroup"
+)
+
+var ErrUnmatchedFile = errors.New("libtiles: file doesn't match pattern")
+
+// UnmatchedPolicy defines how VisitTiles handles files and directories which
+// don't match the pattern. Temporary files of Writer (".<name>.<random>.tmp")
+// are always skipped silently.
+type UnmatchedPolicy int
+
+const (
+	UnmatchedIgnore UnmatchedPolicy = iota // skip them silently
+	UnmatchedWarn                          // skip them, and log their paths
+	UnmatchedError                         // stop visiting with ErrUnmatchedFile
+)
+
+type visitConfig struct {
+	MinZoom uint32
+	MaxZoom uint32
+	Bounds  *tile.Bounds
+}
+
+type VisitOption func(*visitConfig)
+
+// WithZoomRange limits visited tiles to zoom levels from minZoom to maxZoom
+// inclusive. Directories of other zoom levels are not read.
+func WithZoomRange(minZoom, maxZoom uint32) VisitOption {
+	return func(c *visitConfig) {
+		c.MinZoom = minZoom
+		c.MaxZoom = maxZoom
+	}
+}
+
+// WithBounds limits visited tiles to the bounds, scaled to each zoom level.
+// Directories of tiles outside the bounds are not read.
+func WithBounds(bounds tile.Bounds) VisitOption {
+	return func(c *visitConfig) { c.Bounds = &bounds }
+}
+
+func (c *visitConfig) contains(tileID tile.ID) bool {
+	return tileID.Z >= c.MinZoom && tileID.Z <= c.MaxZoom && (c.Bounds == nil || c.Bounds.Contains(tileID))
+}
+
+// mayContain reports whether a directory with values of placeholders in its
+// path may contain visited tiles.
+func (c *visitConfig) mayContain(values []placeholderValue) bool {
+	i := slices.IndexFunc(values, func(v placeholderValue) bool { return v.name == "z" && v.divisor == 1 })
+	if i < 0 {
+		return true
+	}
+	z := values[i].value
+	if z < uint64(c.MinZoom) || z > uint64(c.MaxZoom) {
+		return false
+	}
+	b, ok := directoryBounds(values)
+	if !ok {
+		return false
+	}
+	if c.Bounds == nil {
+		return true
+	}
+	cb := c.Bounds.At(b.Z)
+	return b.MaxX >= cb.MinX && b.MinX <= cb.MaxX && b.MaxY >= cb.MinY && b.MinY <= cb.MaxY
+}
+
+// directoryBounds returns bounds of all tiles which may be in a directory with
+// values of placeholders in its path, or false if the zoom level isn't among
+// them or no tile fits.
+func directoryBounds(values []placeholderValue) (tile.Bounds, bool) {
+	i := slices.IndexFunc(values, func(v placeholderValue) bool { return v.name == "z" && v.divisor == 1 })
+	if i < 0 || values[i].value >= 32 {
+		return tile.Bounds{}, false
+	}
+	z := values[i].value
+	maxRow := uint64(1)<<z - 1
+	minX, maxX, minY, maxY := uint64(0), maxRow, uint64(0), maxRow
+	for _, v := range values {
+		// range of values of all tiles in the directory
+		lo := v.value * uint64(v.divisor)
+		hi := lo + uint64(v.divisor) - 1
+		switch v.name {
+		case "x":
+			minX, maxX = max(minX, lo), min(maxX, hi)
+		case "y":
+			minY, maxY = max(minY, lo), min(maxY, hi)
+		case "-y":
+			if lo > maxRow {
+				return tile.Bounds{}, false
+			}
+			minY, maxY = max(minY, maxRow-min(hi, maxRow)), min(maxY, maxRow-lo)
+		}
+	}
+	if minX > maxX || minY > maxY {
+		return tile.Bounds{}, false
+	}
+	return tile.Bounds{Z: uint32(z), MinX: uint32(minX), MinY: uint32(minY), MaxX: uint32(maxX), MaxY: uint32(maxY)}, true
+}
+
+// segment is a parsed path segment of a file pattern (a directory or a file
+// name), which matches names of directory entries.
+type segment struct {
+	nameRegexp   *regexp.Regexp
+	placeholders []placeholder
+}
+
+type placeholderValue struct {
+	placeholder
+	value uint64
+}
+
+// walkSegments returns the root directory prefix (empty or ending with a
+// separator) and segments of the pattern after it.
+func (p *filePattern) walkSegments() (string, []segment) {
+	separator := string(filepath.Separator)
+	root := p.literals[0][:strings.LastIndex(p.literals[0], separator)+1]
+
+	var segments []segment
+	var current segment
+	regexPattern := "^"
+	for i, literal := range p.literals {
+		if i == 0 {
+			literal = literal[len(root):]
+		}
+		// separators are only in literals, e.g. not in "{x/1000}"
+		parts := strings.Split(literal, separator)
+		for _, part := range parts[:len(parts)-1] {
+			current.nameRegexp = regexp.MustCompile(regexPattern + regexp.QuoteMeta(part) + "$")
+			segments = append(segments, current)
+			current, regexPattern = segment{}, "^"
+		}
+		regexPattern += regexp.QuoteMeta(parts[len(parts)-1])
+		if i < len(p.placeholders) {
+			ph := p.placeholders[i]
+			current.placeholders = append(current.placeholders, ph)
+			if ph.name == "q" {
+				regexPattern += "([0-3]*)"
+			} else {
+				regexPattern += "([0-9]+)"
+			}
+		}
+	}
+	current.nameRegexp = regexp.MustCompile(regexPattern + "$")
+	return root, append(segments, current)
+}
+
+// walkMode defines what walker sends to results.
+type walkMode int
+
+const (
+	walkPaths walkMode = iota // tiles and their file paths
+	walkData                  // tiles, their file paths and data
+	walkDirs                  // only the deepest directories, with values of placeholders in their paths
+)
+
+// walker reads directories of the pattern concurrently, and sends found tiles
+// to results.
+type walker struct {
+	reader  *Reader
+	config  *visitConfig
+	mode    walkMode
+	ctx     context.Context
+	group   *errgroup.Group
+	results chan walkResult
+}
+
+type walkResult struct {
+	tileID   tile.ID
+	filePath string // a directory path for walkDirs
+	tileData []byte
+	values   []placeholderValue // only for walkDirs
+}
+
+// walk visits tiles (or directories) selected by config, calling fn for each
+// on the calling goroutine.
+func (r *Reader) walk(config *visitConfig, mode walkMode, fn func(result walkResult) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(r.config.WalkConcurrency)
+	w := &walker{
+		reader:  r,
+		config:  config,
+		mode:    mode,
+		ctx:     groupCtx,
+		group:   group,
+		results: make(chan walkResult, r.config.WalkConcurrency),
+	}
+
+	group.Go(func() error { return w.walkDir(r.walkRoot, 0, nil) })
+	walkErr := make(chan error, 1)
+	go func() {
+		walkErr <- group.Wait()
+		close(w.results)
+	}()
+
+	for result := range w.results {
+		if err := fn(result); err != nil {
+			cancel()
+			for range w.results {
+				// wait for walkers
+			}
+			return err
+		}
+	}
+	return <-walkErr
+}
+
+func (w *walker) walkDir(dir string, depth int, values []placeholderValue) error {
+	entries, err := os.ReadDir(cmp.Or(dir, "."))
+	if err != nil {
+		return err
+	}
+
+	s := w.reader.walkSegments[depth]
+	last := depth == len(w.reader.walkSegments)-1
+
+	for _, entry := range entries {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+
+		entryPath := dir + entry.Name()
+		if depth == 0 && entry.Name() == MetadataFileName {
+			continue
+		}
+		if !entry.IsDir() && isTempFile(entry.Name()) {
+			continue // written by Writer right now, or left after a crash
+		}
+
+		matches := s.nameRegexp.FindStringSubmatch(entry.Name())
+		if matches == nil || entry.IsDir() == last {
+			if err := w.unmatched(entryPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if last {
+			if err := w.visitFile(entryPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		childValues, ok := appendValues(values, s.placeholders, matches[1:])
+		if !ok {
+			if err := w.unmatched(entryPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if !w.config.mayContain(childValues) {
+			continue
+		}
+
+		childDir := entryPath + string(filepath.Separator)
+		if w.mode == walkDirs && depth+2 == len(w.reader.walkSegments) {
+			if err := w.send(walkResult{filePath: childDir, values: childValues}); err != nil {
+				return err
+			}
+			continue
+		}
+		walkChild := func() error { return w.walkDir(childDir, depth+1, childValues) }
+		if !w.group.TryGo(walkChild) {
+			if err := walkChild(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendValues appends parsed values of placeholders (except quadkeys, which
+// are not used for pruning) to values.
+func appendValues(values []placeholderValue, placeholders []placeholder, matches []string) ([]placeholderValue, bool) {
+	values = slices.Clip(values)
+	for i, ph := range placeholders {
+		if ph.name == "q" {
+			continue
+		}
+		value, err := strconv.ParseUint(matches[i], 10, 32)
+		if err != nil {
+			return nil, false
+		}
+		values = append(values, placeholderValue{ph, value})
+	}
+	return values, true
+}
+
+func (w *walker) visitFile(filePath string) error {
+	tileID, ok := w.reader.pattern.parse(filePath)
+	if !ok {
+		return w.unmatched(filePath)
+	}
+	if !w.config.contains(tileID) {
+		return nil
+	}
+
+	result := walkResult{tileID: tileID, filePath: filePath}
+	if w.mode == walkData {
+		var err error
+		if result.tileData, err = os.ReadFile(filePath); err != nil {
+			return err
+		}
+	}
+	return w.send(result)
+}
+
+func (w *walker) send(result walkResult) error {
+	select {
+	case w.results <- result:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+func (w *walker) unmatched(filePath string) error {
+	return w.reader.config.unmatched(w.reader.pattern, filePath)
+}
@@ -1,36 +1,233 @@
 package xyz
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/eak1mov/go-libtiles/tile"
+	"golang.org/x/sync/errgroup"
 )
 
 // Writer implements tile.Writer interface for tiles in XYZ format.
+//
+// Each tile is written to a temporary file, which is renamed to the tile file
+// when complete, so that tile files are never truncated. Data of files may be
+// lost on a system crash, unless WithSync is enabled.
 type Writer struct {
-	filePattern string
+	pattern       *filePattern
+	skipIdentical bool
+	sync          bool
+	metadata      *Metadata
+	dirs          sync.Map // created directories
+
+	// worker pool, nil if tiles are written synchronously
+	group    *errgroup.Group
+	ctx      context.Context
+	requests chan writeRequest
+	err      error
+}
+
+type writeRequest struct {
+	tileID   tile.ID
+	tileData []byte
+}
+
+type writerConfig struct {
+	Concurrency   int
+	SkipIdentical bool
+	Sync          bool
+	Metadata      *Metadata
+}
+
+type WriterOption func(*writerConfig)
+
+// WithConcurrency sets the number of goroutines writing files. Default is 1:
+// WriteTile writes the file synchronously. Otherwise, WriteTile queues a copy
+// of tile data, and write errors are returned by later calls of WriteTile or
+// by Finalize, which waits for all queued tiles.
+func WithConcurrency(concurrency int) WriterOption {
+	return func(c *writerConfig) { c.Concurrency = concurrency }
+}
+
+// WithSkipIdentical enables skipping of tiles whose files exist and contain
+// the same data, so that repeated exports to the same directory only write
+// changed tiles (disabled by default).
+func WithSkipIdentical(enable bool) WriterOption {
+	return func(c *writerConfig) { c.SkipIdentical = enable }
+}
+
+// WithSync enables flushing of each file to disk before it is renamed, so that
+// tile files are complete even after a system crash or power loss (disabled
+// by default, as it makes writing much slower).
+func WithSync(enable bool) WriterOption {
+	return func(c *writerConfig) { c.Sync = enable }
+}
+
+// WithMetadata sets metadata, which is written by Finalize to the metadata
+// file of the pattern (see MetadataFileName).
+func WithMetadata(metadata Metadata) WriterOption {
+	return func(c *writerConfig) { c.Metadata = &metadata }
 }
 
 // NewWriter creates a new Writer for the given file pattern (e.g. "/home/user/tiles/{z}/{x}/{y}.png").
-func NewWriter(filePattern string) (*Writer, error) {
-	if err := validatePattern(filePattern); err != nil {
+//
+// Finalize must be called after all tiles are written.
+func NewWriter(filePattern string, opts ...WriterOption) (*Writer, error) {
+	config := writerConfig{
+		Concurrency:   1,
+		SkipIdentical: false,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	pattern, err := parsePattern(filePattern)
+	if err != nil {
 		return nil, err
 	}
-	return &Writer{filePattern}, nil
+
+	w := &Writer{pattern: pattern, skipIdentical: config.SkipIdentical, sync: config.Sync, metadata: config.Metadata}
+	if config.Concurrency > 1 {
+		w.group, w.ctx = errgroup.WithContext(context.Background())
+		w.requests = make(chan writeRequest, config.Concurrency)
+		for range config.Concurrency {
+			w.group.Go(w.work)
+		}
+	}
+	return w, nil
+}
+
+func (w *Writer) work() error {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return nil
+		case request, ok := <-w.requests:
+			if !ok {
+				return nil
+			}
+			if err := w.writeFile(request.tileID, request.tileData); err != nil {
+				return err
+			}
+		}
+	}
 }
 
 func (w *Writer) WriteTile(tileID tile.ID, tileData []byte) error {
-	filePath := formatPattern(w.filePattern, tileID)
+	if w.err != nil {
+		return w.err
+	}
+	if w.group == nil {
+		return w.writeFile(tileID, tileData)
+	}
+
+	select {
+	case w.requests <- writeRequest{tileID, bytes.Clone(tileData)}:
+		return nil
+	case <-w.ctx.Done():
+		return w.wait()
+	}
+}
+
+// Finalize waits for all queued tiles to be written, and writes metadata.
+func (w *Writer) Finalize() error {
+	if w.group != nil {
+		w.wait()
+	}
+	if w.err != nil || w.metadata == nil {
+		return w.err
+	}
+	return w.writeMetadata()
+}
+
+// wait stops the worker pool and returns its error, tiles are written
+// synchronously after it.
+func (w *Writer) wait() error {
+	close(w.requests)
+	w.err = w.group.Wait()
+	w.group = nil
+	return w.err
+}
+
+func (w *Writer) writeFile(tileID tile.ID, tileData []byte) error {
+	filePath := w.pattern.format(tileID)
+
+	if w.skipIdentical {
+		if identical, err := fileEquals(filePath, tileData); err != nil || identical {
+			return err
+		}
+	}
 
 	dirPath := filepath.Dir(filePath)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return err
+	if _, found := w.dirs.Load(dirPath); !found {
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			return err
+		}
+		w.dirs.Store(dirPath, struct{}{})
 	}
 
-	return os.WriteFile(filePath, tileData, 0644)
+	return writeFileAtomic(filePath, tileData, w.sync)
 }
 
-func (w *Writer) Finalize() error {
-	return nil
+// fileEquals reports whether the file exists and contains data.
+func fileEquals(filePath string, data []byte) (bool, error) {
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil || info.Size() != int64(len(data)) {
+		return false, err
+	}
+	fileData, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(fileData, data), nil
+}
+
+// tempFileSuffix is the suffix of temporary files of writeFileAtomic, which are
+// named ".<file name>.<random>.tmp".
+const tempFileSuffix = ".tmp"
+
+// isTempFile reports whether the file name is a name of a temporary file of
+// writeFileAtomic.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempFileSuffix)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of
+// filePath, flushes it to disk if sync is set, and renames it to filePath.
+func writeFileAtomic(filePath string, data []byte, sync bool) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	if err = file.Chmod(0644); err != nil {
+		return err
+	}
+	if sync {
+		if err = file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
 }
@@ -1,13 +1,22 @@
 package xyz_test
 
 import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"maps"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/eak1mov/go-libtiles/tile"
 	"github.com/eak1mov/go-libtiles/xyz"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestWriterReader(t *testing.T) {
@@ -64,3 +73,471 @@ func TestWriterReader(t *testing.T) {
 		t.Errorf("ReadTile(missing tile) expected empty tile, got: %v bytes", len(tileData))
 	}
 }
+
+func TestPatterns(t *testing.T) {
+	tiles := map[tile.ID][]byte{
+		{X: 0, Y: 0, Z: 0}:        []byte("tile000"),
+		{X: 1, Y: 0, Z: 1}:        []byte("tile101"),
+		{X: 6, Y: 5, Z: 3}:        []byte("tile653"),
+		{X: 1234, Y: 2345, Z: 12}: []byte("tile_12"),
+	}
+	tileID := tile.ID{X: 1234, Y: 2345, Z: 12}
+
+	for _, tc := range []struct {
+		pattern string
+		path    string // path of tileID
+	}{
+		{"{z}/{x}/{y}.png", "12/1234/2345.png"},
+		{"{z}/{x}/{-y}.png", "12/1234/1750.png"},
+		{"q/{q}.png", "q/210211212012.png"},
+		{"{z}/{x/1000}/{x}/{y:6}.png", "12/1/1234/002345.png"},
+		{"{z}/{x/100:03}/{y/100:03}/{x}_{-y}.png", "12/012/023/1234_1750.png"},
+	} {
+		rootDir := t.TempDir()
+		pattern := filepath.Join(rootDir, tc.pattern)
+		wantPath := filepath.Join(rootDir, tc.path)
+
+		writer, err := xyz.NewWriter(pattern)
+		if err != nil {
+			t.Fatalf("%s: NewWriter failed: %v", tc.pattern, err)
+		}
+		for tileID, tileData := range tiles {
+			if err := writer.WriteTile(tileID, tileData); err != nil {
+				t.Fatalf("%s: WriteTile(%v) failed: %v", tc.pattern, tileID, err)
+			}
+		}
+		if err := writer.Finalize(); err != nil {
+			t.Fatalf("%s: Finalize failed: %v", tc.pattern, err)
+		}
+
+		if data, err := os.ReadFile(wantPath); err != nil || !cmp.Equal(data, tiles[tileID]) {
+			t.Errorf("%s: ReadFile(%s) = %q, %v, want = %q", tc.pattern, tc.path, data, err, tiles[tileID])
+		}
+
+		reader, err := xyz.NewReader(pattern)
+		if err != nil {
+			t.Fatalf("%s: NewReader failed: %v", tc.pattern, err)
+		}
+		if diff := cmp.Diff(tiles, maps.Collect(tile.IterTiles(reader))); diff != "" {
+			t.Errorf("%s: VisitTiles mismatch (-want +got):\n%s", tc.pattern, diff)
+		}
+	}
+}
+
+func TestZeroPaddedPaths(t *testing.T) {
+	rootDir := t.TempDir()
+	for _, path := range []string{"5/01/3.png", "05/2/004.png", "5/3/4.png"} {
+		filePath := filepath.Join(rootDir, path)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(filePath, []byte(path), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	reader, err := xyz.NewReader(filepath.Join(rootDir, "{z}/{x}/{y:2}.png"))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	want := map[tile.ID][]byte{
+		{X: 1, Y: 3, Z: 5}: []byte("5/01/3.png"),
+		{X: 2, Y: 4, Z: 5}: []byte("05/2/004.png"),
+		{X: 3, Y: 4, Z: 5}: []byte("5/3/4.png"),
+	}
+	if diff := cmp.Diff(want, maps.Collect(tile.IterTiles(reader))); diff != "" {
+		t.Errorf("VisitTiles mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"{z}/{x}.png",
+		"{z}/{x/10}/{y}.png",
+		"{z}/{x}/{y}/{w}.png",
+		"{q/10}.png",
+	} {
+		if _, err := xyz.NewReader(pattern); !errors.Is(err, xyz.ErrInvalidPattern) {
+			t.Errorf("NewReader(%q) error = %v, want = %v", pattern, err, xyz.ErrInvalidPattern)
+		}
+	}
+}
+
+func TestWriterOptions(t *testing.T) {
+	rootDir := t.TempDir()
+	pattern := filepath.Join(rootDir, "{z}", "{x}", "{y}.png")
+
+	tiles := make(map[tile.ID][]byte)
+	for x := range uint32(16) {
+		for y := range uint32(16) {
+			tiles[tile.ID{X: x, Y: y, Z: 4}] = fmt.Appendf(nil, "tile-%v-%v", x, y)
+		}
+	}
+
+	writeTiles := func(tiles map[tile.ID][]byte) {
+		writer, err := xyz.NewWriter(pattern, xyz.WithConcurrency(4), xyz.WithSkipIdentical(true))
+		if err != nil {
+			t.Fatalf("NewWriter failed: %v", err)
+		}
+		for tileID, tileData := range tiles {
+			if err := writer.WriteTile(tileID, tileData); err != nil {
+				t.Fatalf("WriteTile(%v) failed: %v", tileID, err)
+			}
+		}
+		if err := writer.Finalize(); err != nil {
+			t.Fatalf("Finalize failed: %v", err)
+		}
+	}
+	writeTiles(tiles)
+
+	// files of identical tiles are not rewritten
+	oldTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for tileID := range tiles {
+		if err := os.Chtimes(filepath.Join(rootDir, fmt.Sprintf("4/%d/%d.png", tileID.X, tileID.Y)), oldTime, oldTime); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+	changedID := tile.ID{X: 3, Y: 5, Z: 4}
+	tiles[changedID] = []byte("changed")
+	writeTiles(tiles)
+
+	for tileID := range tiles {
+		info, err := os.Stat(filepath.Join(rootDir, fmt.Sprintf("4/%d/%d.png", tileID.X, tileID.Y)))
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if rewritten := !info.ModTime().Equal(oldTime); rewritten != (tileID == changedID) {
+			t.Errorf("tile %v rewritten = %v, want = %v", tileID, rewritten, tileID == changedID)
+		}
+	}
+
+	reader, err := xyz.NewReader(pattern)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	if diff := cmp.Diff(tiles, maps.Collect(tile.IterTiles(reader))); diff != "" {
+		t.Errorf("VisitTiles mismatch (-want +got):\n%s", diff)
+	}
+
+	tempFiles, _ := filepath.Glob(filepath.Join(rootDir, "4", "*", "*.tmp"))
+	if len(tempFiles) != 0 {
+		t.Errorf("temporary files are not removed: %v", tempFiles)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	rootDir := t.TempDir()
+	pattern := filepath.Join(rootDir, "{z}", "{x}", "{y}")
+	pngData := []byte("\x89PNG\r\n\x1a\ntile")
+
+	metadata := xyz.Metadata{TileJSON: "3.0.0", Name: "test", Format: "png", MinZoom: 1, MaxZoom: 2, Bounds: []float64{0, 0, 180, 85}}
+	writer, err := xyz.NewWriter(pattern, xyz.WithMetadata(metadata))
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for _, tileID := range []tile.ID{{X: 1, Y: 0, Z: 1}, {X: 2, Y: 1, Z: 2}, {X: 3, Y: 0, Z: 2}} {
+		if err := writer.WriteTile(tileID, pngData); err != nil {
+			t.Fatalf("WriteTile failed: %v", err)
+		}
+	}
+	if err := writer.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+
+	reader, err := xyz.NewReader(pattern)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	got, err := reader.ReadMetadata()
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if diff := cmp.Diff(&metadata, got); diff != "" {
+		t.Errorf("ReadMetadata mismatch (-want +got):\n%s", diff)
+	}
+	if tiles := maps.Collect(tile.IterTiles(reader)); len(tiles) != 3 {
+		t.Errorf("VisitTiles visited %d tiles, want = 3", len(tiles))
+	}
+
+	if err := os.Remove(filepath.Join(rootDir, xyz.MetadataFileName)); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	got, err = reader.InferMetadata()
+	if err != nil {
+		t.Fatalf("InferMetadata failed: %v", err)
+	}
+	want := &xyz.Metadata{
+		TileJSON: "3.0.0",
+		Format:   "png",
+		MinZoom:  1,
+		MaxZoom:  2,
+		Bounds:   []float64{0, 0, 180, 85.0511287798066},
+		Center:   []float64{90, 42.5255643899033, 1},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Errorf("InferMetadata mismatch (-want +got):\n%s", diff)
+	}
+
+	// estimated by directories {z}/{x}, rows are unknown
+	got, err = reader.ReadMetadata()
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	want.Bounds = []float64{0, -85.0511287798066, 180, 85.0511287798066}
+	want.Center = []float64{90, 0, 1}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Errorf("ReadMetadata without file mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestVisit(t *testing.T) {
+	tiles := make(map[tile.ID][]byte)
+	for z := range uint32(6) {
+		for x := range uint32(1) << z {
+			for y := range uint32(1) << z {
+				tiles[tile.ID{X: x, Y: y, Z: z}] = fmt.Appendf(nil, "tile-%v-%v-%v", x, y, z)
+			}
+		}
+	}
+	bounds := tile.Bounds{Z: 3, MinX: 1, MinY: 2, MaxX: 2, MaxY: 5}
+	want := make(map[tile.ID][]byte)
+	for tileID, tileData := range tiles {
+		if tileID.Z >= 2 && tileID.Z <= 4 && bounds.Contains(tileID) {
+			want[tileID] = tileData
+		}
+	}
+
+	for _, filePattern := range []string{
+		"{z}/{x}/{y}.png",
+		"{z}/{x}/{-y}.png",
+		"{z}/{x/4}/{-y/4}/{x}_{y}.png",
+	} {
+		rootDir := t.TempDir()
+		pattern := filepath.Join(rootDir, filePattern)
+		writer, err := xyz.NewWriter(pattern)
+		if err != nil {
+			t.Fatalf("%s: NewWriter failed: %v", filePattern, err)
+		}
+		for tileID, tileData := range tiles {
+			if err := writer.WriteTile(tileID, tileData); err != nil {
+				t.Fatalf("%s: WriteTile(%v) failed: %v", filePattern, tileID, err)
+			}
+		}
+		if err := writer.Finalize(); err != nil {
+			t.Fatalf("%s: Finalize failed: %v", filePattern, err)
+		}
+
+		// directories outside of the filter are not read
+		if err := os.WriteFile(filepath.Join(rootDir, "5", "unmatched"), nil, 0644); err != nil {
+			t.Fatalf("%s: WriteFile failed: %v", filePattern, err)
+		}
+
+		reader, err := xyz.NewReader(pattern, xyz.WithWalkConcurrency(4), xyz.WithUnmatchedPolicy(xyz.UnmatchedError))
+		if err != nil {
+			t.Fatalf("%s: NewReader failed: %v", filePattern, err)
+		}
+		got := make(map[tile.ID][]byte)
+		err = reader.Visit(func(tileID tile.ID, tileData []byte) error {
+			got[tileID] = tileData
+			return nil
+		}, xyz.WithZoomRange(2, 4), xyz.WithBounds(bounds))
+		if err != nil {
+			t.Fatalf("%s: Visit failed: %v", filePattern, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: Visit mismatch (-want +got):\n%s", filePattern, diff)
+		}
+	}
+}
+
+func TestUnmatchedPolicy(t *testing.T) {
+	rootDir := t.TempDir()
+	pattern := filepath.Join(rootDir, "{z}", "{x}", "{y}.png")
+
+	writer, err := xyz.NewWriter(pattern)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := writer.WriteTile(tile.ID{X: 1, Y: 1, Z: 1}, []byte("tile111")); err != nil {
+		t.Fatalf("WriteTile failed: %v", err)
+	}
+	if err := writer.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+	unmatchedPath := filepath.Join(rootDir, "1", "1", "readme.txt")
+	if err := os.WriteFile(unmatchedPath, nil, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	// temporary files of the writer are never reported
+	tempPath := filepath.Join(rootDir, "1", "1", ".0.png.12345.tmp")
+	if err := os.WriteFile(tempPath, nil, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	for _, policy := range []xyz.UnmatchedPolicy{xyz.UnmatchedIgnore, xyz.UnmatchedWarn, xyz.UnmatchedError} {
+		var logs strings.Builder
+		reader, err := xyz.NewReader(pattern, xyz.WithUnmatchedPolicy(policy), xyz.WithLogger(log.New(&logs, "", 0)))
+		if err != nil {
+			t.Fatalf("NewReader failed: %v", err)
+		}
+		tiles := 0
+		err = reader.VisitTiles(func(tile.ID, []byte) error {
+			tiles++
+			return nil
+		})
+
+		if wantErr := policy == xyz.UnmatchedError; errors.Is(err, xyz.ErrUnmatchedFile) != wantErr {
+			t.Errorf("policy %v: VisitTiles error = %v, want = %v", policy, err, wantErr)
+		}
+		if wantLogs := policy == xyz.UnmatchedWarn; strings.Contains(logs.String(), unmatchedPath) != wantLogs {
+			t.Errorf("policy %v: logs = %q, want = %v", policy, logs.String(), wantLogs)
+		}
+		if strings.Contains(logs.String(), tempPath) || (err != nil && strings.Contains(err.Error(), tempPath)) {
+			t.Errorf("policy %v: temporary file is reported: %v, %q", policy, err, logs.String())
+		}
+		if policy != xyz.UnmatchedError && tiles != 1 {
+			t.Errorf("policy %v: VisitTiles visited %d tiles, want = 1", policy, tiles)
+		}
+	}
+}
+
+func TestTarUnmatched(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "tiles.tar")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	tw := tar.NewWriter(file)
+	for _, name := range []string{"0/0/0.png", "readme.txt"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: 4, Mode: 0644}); err != nil {
+			t.Fatalf("WriteHeader failed: %v", err)
+		}
+		if _, err := tw.Write([]byte("tile")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	file.Close()
+
+	var logs strings.Builder
+	reader, err := xyz.NewTarReader(filePath, "{z}/{x}/{y}.png", xyz.WithUnmatchedPolicy(xyz.UnmatchedWarn), xyz.WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatalf("NewTarReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	// both read the whole archive, the file is reported once
+	if _, err := reader.ReadMetadata(); err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if tiles := maps.Collect(tile.IterTiles(reader)); len(tiles) != 1 {
+		t.Errorf("VisitTiles visited %d tiles, want = 1", len(tiles))
+	}
+	if count := strings.Count(logs.String(), "readme.txt"); count != 1 {
+		t.Errorf("logs = %q, want readme.txt reported once", logs.String())
+	}
+}
+
+func TestArchives(t *testing.T) {
+	tiles := map[tile.ID][]byte{
+		{X: 0, Y: 0, Z: 0}: []byte("tile000"),
+		{X: 1, Y: 1, Z: 1}: []byte("tile111"),
+		{X: 0, Y: 0, Z: 6}: []byte("tile006"),
+		{X: 6, Y: 6, Z: 6}: []byte("tile666"),
+	}
+	metadata := xyz.Metadata{TileJSON: "3.0.0", Name: "test", Format: "png", MinZoom: 0, MaxZoom: 6}
+	pattern := "tiles/{z}/{x}/{y}.png"
+
+	type archiveReader interface {
+		tile.Reader
+		tile.Visitor
+		ReadMetadata() (*xyz.Metadata, error)
+		Close() error
+	}
+	for _, tc := range []struct {
+		name      string
+		newWriter func(filePath string, opts ...xyz.WriterOption) (tile.Writer, error)
+		newReader func(filePath string) (archiveReader, error)
+	}{
+		{
+			name: "tar",
+			newWriter: func(filePath string, opts ...xyz.WriterOption) (tile.Writer, error) {
+				return xyz.NewTarWriter(filePath, pattern, opts...)
+			},
+			newReader: func(filePath string) (archiveReader, error) {
+				return xyz.NewTarReader(filePath, pattern, xyz.WithUnmatchedPolicy(xyz.UnmatchedError))
+			},
+		},
+		{
+			name: "zip",
+			newWriter: func(filePath string, opts ...xyz.WriterOption) (tile.Writer, error) {
+				return xyz.NewZipWriter(filePath, pattern, opts...)
+			},
+			newReader: func(filePath string) (archiveReader, error) {
+				return xyz.NewZipReader(filePath, pattern, xyz.WithUnmatchedPolicy(xyz.UnmatchedError))
+			},
+		},
+	} {
+		for _, withMetadata := range []bool{true, false} {
+			filePath := filepath.Join(t.TempDir(), "tiles."+tc.name)
+
+			var opts []xyz.WriterOption
+			if withMetadata {
+				opts = append(opts, xyz.WithMetadata(metadata))
+			}
+			writer, err := tc.newWriter(filePath, opts...)
+			if err != nil {
+				t.Fatalf("%s: NewWriter failed: %v", tc.name, err)
+			}
+			for tileID, tileData := range tiles {
+				if err := writer.WriteTile(tileID, tileData); err != nil {
+					t.Fatalf("%s: WriteTile(%v) failed: %v", tc.name, tileID, err)
+				}
+			}
+			if err := writer.Finalize(); err != nil {
+				t.Fatalf("%s: Finalize failed: %v", tc.name, err)
+			}
+			writer.(io.Closer).Close()
+
+			reader, err := tc.newReader(filePath)
+			if err != nil {
+				t.Fatalf("%s: NewReader failed: %v", tc.name, err)
+			}
+			defer reader.Close()
+
+			if diff := cmp.Diff(tiles, maps.Collect(tile.IterTiles(reader))); diff != "" {
+				t.Errorf("%s: VisitTiles mismatch (-want +got):\n%s", tc.name, diff)
+			}
+			for tileID, tileData := range tiles {
+				if data, err := reader.ReadTile(tileID); err != nil || !cmp.Equal(data, tileData) {
+					t.Errorf("%s: ReadTile(%v) = %q, %v, want = %q", tc.name, tileID, data, err, tileData)
+				}
+			}
+			if data, err := reader.ReadTile(tile.ID{X: 9, Y: 9, Z: 9}); err != nil || len(data) != 0 {
+				t.Errorf("%s: ReadTile(missing tile) = %q, %v, want empty tile", tc.name, data, err)
+			}
+
+			got, err := reader.ReadMetadata()
+			if err != nil {
+				t.Fatalf("%s: ReadMetadata failed: %v", tc.name, err)
+			}
+			if withMetadata {
+				if diff := cmp.Diff(&metadata, got); diff != "" {
+					t.Errorf("%s: ReadMetadata mismatch (-want +got):\n%s", tc.name, diff)
+				}
+			} else if got.Format != "png" || got.MinZoom != 0 || got.MaxZoom != 6 {
+				t.Errorf("%s: inferred metadata = %+v, want png format and zooms 0-6", tc.name, got)
+			}
+		}
+
+		writer, err := tc.newWriter(filepath.Join(t.TempDir(), "tiles."+tc.name))
+		if err != nil {
+			t.Fatalf("%s: NewWriter failed: %v", tc.name, err)
+		}
+		if err := writer.(io.Closer).Close(); !errors.Is(err, xyz.ErrNotFinalized) {
+			t.Errorf("%s: Close without Finalize = %v, want = %v", tc.name, err, xyz.ErrNotFinalized)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package xyz
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/eak1mov/go-libtiles/tile"
+)
+
+// ZipReader implements tile.Reader and tile.Visitor interfaces for tiles in
+// XYZ format inside a zip archive. Paths of tiles match a file pattern relative
+// to the archive root (e.g. "{z}/{x}/{y}.png").
+//
+// Tiles are located by the central directory of the archive, which is read
+// by NewZipReader. Methods are safe for concurrent use.
+type ZipReader struct {
+	archive  *zip.ReadCloser
+	pattern  *filePattern
+	config   readerConfig
+	files    map[tile.ID]*zip.File
+	metadata *zip.File
+}
+
+// NewZipReader creates a new ZipReader for the archive (e.g. "tiles.zip") and
+// the file pattern of its tiles (e.g. "{z}/{x}/{y}.png").
+// Options WithUnmatchedPolicy and WithLogger are applied, other ones are ignored.
+func NewZipReader(filePath, filePattern string, opts ...ReaderOption) (*ZipReader, error) {
+	pattern, err := parsePattern(filePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	r := &ZipReader{
+		archive: archive,
+		pattern: pattern,
+		config:  newReaderConfig(opts),
+		files:   make(map[tile.ID]*zip.File),
+	}
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue // directory
+		}
+		filePath := path.Clean(file.Name)
+		if filePath == archiveMetadataPath(pattern) {
+			r.metadata = file
+		}
+		tileID, ok, err := matchArchivePath(pattern, &r.config, filePath)
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		if ok {
+			r.files[tileID] = file
+		}
+	}
+	return r, nil
+}
+
+func (r *ZipReader) Close() error {
+	return r.archive.Close()
+}
+
+func (r *ZipReader) ReadTile(tileID tile.ID) ([]byte, error) {
+	file, found := r.files[tileID]
+	if !found {
+		return make([]byte, 0), nil
+	}
+	return readZipFile(file)
+}
+
+// VisitTiles visits tiles in order of the archive.
+func (r *ZipReader) VisitTiles(fn tile.VisitFunc) error {
+	for _, file := range r.archive.File {
+		tileID, ok := r.pattern.parse(path.Clean(file.Name))
+		if !ok || r.files[tileID] != file {
+			continue // reported by NewZipReader
+		}
+		tileData, err := readZipFile(file)
+		if err != nil {
+			return err
+		}
+		if err := fn(tileID, tileData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMetadata reads the metadata file of the pattern (see MetadataFileName).
+// If it doesn't exist, metadata is inferred from tile paths (see Reader.InferMetadata).
+func (r *ZipReader) ReadMetadata() (*Metadata, error) {
+	if r.metadata != nil {
+		data, err := readZipFile(r.metadata)
+		if err != nil {
+			return nil, err
+		}
+		return parseMetadata(data)
+	}
+
+	var b metadataBuilder
+	var sample *zip.File
+	for tileID, file := range r.files {
+		sample = file
+		b.add(tileID)
+	}
+	return b.build(r.pattern, func() ([]byte, error) { return readZipFile(sample) })
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// ZipWriter implements tile.Writer interface for tiles in XYZ format inside a
+// zip archive. Paths of tiles are formatted by a file pattern relative to the
+// archive root (e.g. "{z}/{x}/{y}.png").
+//
+// Tiles are stored without compression, as tile formats are compressed already.
+// Finalize must be called after all tiles are written, and Close after it.
+type ZipWriter struct {
+	file      *os.File
+	zw        *zip.Writer
+	pattern   *filePattern
+	metadata  *Metadata
+	modTime   time.Time
+	finalized bool
+}
+
+// NewZipWriter creates a new ZipWriter for the archive (e.g. "tiles.zip") and
+// the file pattern of its tiles (e.g. "{z}/{x}/{y}.png").
+// Option WithMetadata is applied, other ones are ignored.
+func NewZipWriter(filePath, filePattern string, opts ...WriterOption) (*ZipWriter, error) {
+	var config writerConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	pattern, err := parsePattern(filePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &ZipWriter{
+		file:     file,
+		zw:       zip.NewWriter(file),
+		pattern:  pattern,
+		metadata: config.Metadata,
+		modTime:  archiveModTime(),
+	}, nil
+}
+
+// Close closes the archive file, it returns ErrNotFinalized if Finalize wasn't
+// called successfully.
+func (w *ZipWriter) Close() error {
+	err := w.file.Close()
+	if !w.finalized {
+		return errors.Join(ErrNotFinalized, err)
+	}
+	return err
+}
+
+func (w *ZipWriter) WriteTile(tileID tile.ID, tileData []byte) error {
+	return w.writeFile(w.pattern.format(tileID), tileData, zip.Store)
+}
+
+// Finalize writes metadata, and the central directory of the archive.
+func (w *ZipWriter) Finalize() error {
+	if w.metadata != nil {
+		data, err := json.MarshalIndent(w.metadata, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := w.writeFile(archiveMetadataPath(w.pattern), data, zip.Deflate); err != nil {
+			return err
+		}
+	}
+	if err := w.zw.Close(); err != nil {
+		return err
+	}
+	w.finalized = true
+	return nil
+}
+
+func (w *ZipWriter) writeFile(filePath string, data []byte, method uint16) error {
+	writer, err := w.zw.CreateHeader(&zip.FileHeader{
+		Name:     filePath,
+		Method:   method,
+		Modified: w.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}